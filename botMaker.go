@@ -2,21 +2,30 @@ package botMaker
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 	"text/template"
 
-	"github.com/pkoukk/tiktoken-go"
 	"github.com/sashabaranov/go-openai"
 )
 
 var DEFAULT_TEMPLATE = `
-{{ if .ContextToRender }}Use the following context to help with your response:
-{{ range $ctx := .ContextToRender }}
+{{ if .Examples }}Here are some examples of good answers:
+{{ range $ex := .Examples }}
+user: {{$ex.Question}}
+assistant: {{$ex.Answer}}
+{{ end }}
+===={{ end }}{{ if .ContextToRender }}Use the following context to help with your response:
+{{ if .Citations }}{{ range $ctx := .NumberedContexts }}
 {{$ctx}}
 {{ end }}
+Cite the context you use by its number in square brackets, for example [1].
+{{ else }}{{ range $ctx := .ContextToRender }}
+{{$ctx}}
+{{ end }}{{ end }}
 ===={{ end }}
 
 user: {{.Body}}
@@ -26,17 +35,27 @@ user: {{.Body}}
 
 // BotSettings holds configs for OpenAI APIs
 type BotSettings struct {
-	ID                string // Used when retrieving contexts
-	Model             string
-	Temp              float32
-	TopP              float32
-	FrequencyPenalty  float32
-	PresencePenalty   float32
-	MaxTokens         int // Max to receive
-	TokenLimit        int // Max to send
-	EmbeddingModel    openai.EmbeddingModel
-	Memory            Storage
-	MemoryAcceptScore float32
+	ID                  string // Used when retrieving contexts
+	Model               string
+	Temp                float32
+	TopP                float32
+	FrequencyPenalty    float32
+	PresencePenalty     float32
+	MaxTokens           int                   // Max tokens for prompt and completion, 0 uses the model's context window
+	TokenLimit          int                   // Max to send, 0 uses the model's context window
+	MaxCompletionTokens int                   // Max tokens for the completion, 0 is what MaxTokens leaves
+	EmbeddingModel      openai.EmbeddingModel // Deprecated: use Retrieval.EmbeddingModel
+	Memory              Storage
+	MemoryAcceptScore   float32 // Deprecated: use Retrieval.MinScore
+	Retrieval           RetrievalSettings
+	Keywords            KeywordStore   // Optional, enables hybrid keyword + vector retrieval
+	VectorWeight        float32        // Weight of vector matches when fusing with keyword matches, 0 means 1
+	KeywordWeight       float32        // Weight of keyword matches when fusing with vector matches, 0 means 1
+	Reranker            Reranker       // Optional, reorders retrieved candidates before they are packed
+	Sources             []MemorySource // Optional, retrieve from several namespaces or stores instead of Memory and ID
+	Examples            *ExampleStore  // Optional, the most similar examples are added to every prompt
+
+	noResponseCache bool // Set on helper settings, their completions are not answers to cache
 }
 
 // NewBotSettings Returns settings for OpenAI with sane defaults
@@ -47,40 +66,116 @@ func NewBotSettings() *BotSettings {
 		FrequencyPenalty:  0.0,
 		PresencePenalty:   0.6,
 		Model:             openai.GPT3TextDavinci003,
-		MaxTokens:         4096,
-		TokenLimit:        4096,
 		EmbeddingModel:    openai.AdaEmbeddingV2,
 		MemoryAcceptScore: 0.9,
+		Retrieval:         NewRetrievalSettings(),
+		VectorWeight:      1.0,
+		KeywordWeight:     1.0,
 	}
 }
 
+// HelperSettings returns a copy of the settings for internal one-off completions such as query rewriting,
+// reranking and judging. They use the same model and sampling but never retrieve, add examples, rewrite queries or
+// touch the response cache, so a helper cannot recurse into retrieval or be served as an answer
+func (s *BotSettings) HelperSettings() *BotSettings {
+	h := *s
+	h.Memory = nil
+	h.Sources = nil
+	h.Keywords = nil
+	h.Reranker = nil
+	h.Examples = nil
+	h.Retrieval.Rewrite = QueryRewriteSettings{}
+	h.noResponseCache = true
+	return &h
+}
+
+// WithoutResponseCache returns a copy of the settings whose completions are neither looked up in nor added to the
+// client's ResponseCache, e.g. to evaluate a bot on fresh answers and the contexts they were retrieved with
+func (s *BotSettings) WithoutResponseCache() *BotSettings {
+	u := *s
+	u.noResponseCache = true
+	return &u
+}
+
 type RenderContext struct {
-	Role    string
-	Content string
+	Role    string `json:"role"`
+	Content string `json:"content"`
 }
 
 // BotPrompt has the components to make a call to OpenAPI
 type BotPrompt struct {
-	OAIClient       LLMAPIClient
-	Instructions    string   // You are an AI assistant that is happy, helpful and tries to offer insightful answers
-	Body            string   // The actual prompt
-	DesiredFormat   string   // Provide your answer using the following output
-	ContextToRender []string // Rendered context (within token limit)
-	ContextTitles   []string // titles and references to the content
-	Stop            []string // Human: AI:
-	History         []*RenderContext
-	Template        string
-	RenderedPrompt  string
-	PromptLength    int
-	tpl             *template.Template
+	OAIClient        LLMAPIClient
+	Instructions     string           // You are an AI assistant that is happy, helpful and tries to offer insightful answers
+	Body             string           // The actual prompt
+	DesiredFormat    string           // Provide your answer using the following output
+	ContextToRender  []string         // Rendered context (within token limit)
+	ContextTitles    []string         // titles and references to the content
+	IncludedContexts []Context        // Contexts rendered into the last prompt
+	DroppedContexts  []DroppedContext // Contexts retrieved for the last prompt but left out
+	RetrievalQueries []string         // Queries used to retrieve contexts for the last prompt
+	Citations        bool             // Number contexts in the prompt and ask the model to cite them as [n]
+	JSONMode         bool             // Ask for a JSON object response on models that support it
+	ChatTemplate     *ChatTemplate    // Optional, renders chat models' messages instead of Template
+	Examples         []Example        // Few-shot examples selected for the last prompt
+	Session          string           // Optional, usage is attributed to this session as well as the bot ID
+	Stop             []string         // Human: AI:
+	History          []*RenderContext
+	Template         string
+	RenderedPrompt   string
+	PromptLength     int
+	Usage            openai.Usage // Tokens the last completion used as reported by the API, zero for cached responses
+	tpl              *template.Template
+	loadErr          error
+	ctx              context.Context // Of the span the prompt is being answered in, see context
 }
 
 type Context struct {
-	Text  string `json:"text"`
-	Title string `json:"title"`
+	ID       string  `json:"id,omitempty"`
+	Source   string  `json:"source,omitempty"`
+	Text     string  `json:"text"`
+	Title    string  `json:"title"`
+	FileName string  `json:"file_name,omitempty"`
+	Start    int     `json:"start"`
+	End      int     `json:"end"`
+	Score    float32 `json:"score"`
+	Tokens   int     `json:"tokens,omitempty"`
 }
 
-func NewBotPrompt(promptTemplate string, withClient LLMAPIClient) *BotPrompt {
+// NewContextFromMatch converts a memory match into a Context
+func NewContextFromMatch(m *QueryMatch) Context {
+	start, _ := strconv.Atoi(m.Metadata["start"])
+	end, _ := strconv.Atoi(m.Metadata["end"])
+
+	return Context{
+		ID:       m.ID,
+		Source:   m.Source,
+		Text:     strings.TrimSpace(strings.ReplaceAll(m.Metadata["text"], "\n", " ")),
+		Title:    m.Metadata["title"],
+		FileName: m.Metadata["file_name"],
+		Start:    start,
+		End:      end,
+		Score:    m.Score,
+	}
+}
+
+// Reasons a retrieved context was left out of the prompt
+const (
+	DroppedBelowMinScore = "below_min_score"
+	DroppedDuplicate     = "duplicate"
+	DroppedTopK          = "top_k"
+	DroppedTokenBudget   = "token_budget"
+	DroppedSourceQuota   = "source_quota"
+)
+
+// DroppedContext is a retrieved context that was not rendered into the prompt, and why
+type DroppedContext struct {
+	Context
+	Reason string `json:"reason"`
+}
+
+// LoadBotPrompt returns a prompt that renders promptTemplate, or DEFAULT_TEMPLATE when it is empty. A template
+// prefixed with file:// is read from that path, failures wrap ErrTemplateLoad
+func LoadBotPrompt(promptTemplate string, withClient LLMAPIClient) (*BotPrompt, error) {
 	b := &BotPrompt{
 		OAIClient:       withClient,
 		ContextToRender: make([]string, 0),
@@ -98,19 +193,51 @@ func NewBotPrompt(promptTemplate string, withClient LLMAPIClient) *BotPrompt {
 	// load from a file if it's a file
 	pf := strings.HasPrefix(promptTemplate, "file://")
 	if pf {
-		c, err := os.ReadFile(promptTemplate)
+		c, err := os.ReadFile(strings.TrimPrefix(promptTemplate, "file://"))
 		if err != nil {
-			log.Fatal(err)
+			return b, fmt.Errorf("%w: %w", ErrTemplateLoad, err)
 		}
 
 		b.Template = string(c)
 	}
 
+	return b, nil
+}
+
+// NewBotPrompt returns a prompt that renders promptTemplate, a template file that cannot be read is logged and
+// returned by the prompt's first call to Prompt
+//
+// Deprecated: use LoadBotPrompt, which returns the error
+func NewBotPrompt(promptTemplate string, withClient LLMAPIClient) *BotPrompt {
+	b, err := LoadBotPrompt(promptTemplate, withClient)
+	if err != nil {
+		DefaultLogger.Error("failed to load prompt template", "template", promptTemplate, "error", err)
+		b.loadErr = err
+	}
+
 	return b
 }
 
 func (b *BotPrompt) renderPrompt() (string, error) {
 	var out bytes.Buffer
+	if b.ChatTemplate != nil {
+		messages, err := b.ChatTemplate.Render(b)
+		if err != nil {
+			return "", err
+		}
+
+		writeTranscript(&out, messages)
+		return out.String(), nil
+	}
+
+	if b.tpl == nil {
+		tpl, err := NewPromptTemplate("prompt-tpl", b.Template)
+		if err != nil {
+			return "", err
+		}
+		b.tpl = tpl
+	}
+
 	err := b.tpl.Execute(&out, b)
 	if err != nil {
 		return "", err
@@ -119,6 +246,51 @@ func (b *BotPrompt) renderPrompt() (string, error) {
 	return out.String(), nil
 }
 
+// context returns the context of the span the prompt is being answered in, so that the retrieval and helper calls
+// made for it are traced as its children
+func (b *BotPrompt) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+
+	return b.ctx
+}
+
+// withContext sets the prompt's context until restore is called
+func (b *BotPrompt) withContext(ctx context.Context) (restore func()) {
+	prev := b.ctx
+	b.ctx = ctx
+	return func() { b.ctx = prev }
+}
+
+// retrieveContexts selects few-shot examples and fills the contexts for the prompt from memory, if the bot has any
+func (b *BotPrompt) retrieveContexts(settings *BotSettings) error {
+	if settings.Examples != nil {
+		examples, err := settings.Examples.selectExamples(b.context(), b.Body, settings.Model)
+		if err != nil {
+			return err
+		}
+		b.Examples = examples
+	}
+
+	if settings.Memory == nil && len(settings.Sources) == 0 {
+		return nil
+	}
+
+	_, err := GetContexts(b, settings, settings.Memory, b.OAIClient)
+	return err
+}
+
+// NumberedContexts returns the contexts to render prefixed with their citation number, e.g. "[1] ..."
+func (b *BotPrompt) NumberedContexts() []string {
+	numbered := make([]string, len(b.ContextToRender))
+	for i := range b.ContextToRender {
+		numbered[i] = fmt.Sprintf("[%d] %s", i+1, b.ContextToRender[i])
+	}
+
+	return numbered
+}
+
 func (b *BotPrompt) GetContextsForLastPrompt() []string {
 	if len(b.ContextToRender) > 0 {
 		return b.ContextToRender
@@ -129,21 +301,13 @@ func (b *BotPrompt) GetContextsForLastPrompt() []string {
 
 // Prompt renders the prompt to the prompt template
 func (b *BotPrompt) Prompt(settings *BotSettings) (string, error) {
-	var err error
-	if b.tpl == nil {
-		b.tpl = template.New("prompt-tpl")
-		b.tpl, err = b.tpl.Parse(b.Template)
-		if err != nil {
-			return "", err
-		}
+	if b.loadErr != nil {
+		return "", b.loadErr
 	}
 
 	// check for context or memory to embed
-	if settings.Memory != nil {
-		_, err := GetContexts(b, settings, settings.Memory, b.OAIClient)
-		if err != nil {
-			return "", err
-		}
+	if err := b.retrieveContexts(settings); err != nil {
+		return "", err
 	}
 
 	// render it again
@@ -152,8 +316,8 @@ func (b *BotPrompt) Prompt(settings *BotSettings) (string, error) {
 		return "", err
 	}
 
-	if !b.OAIClient.CheckTokenLimit(finalPrompt, settings.Model, settings.TokenLimit) {
-		return "", fmt.Errorf("prompt is longer than token limit, please shorten your prompt")
+	if !b.OAIClient.CheckTokenLimit(finalPrompt, settings.Model, settings.tokenLimit()) {
+		return "", &TokenLimitError{Model: settings.Model, Limit: settings.tokenLimit()}
 	}
 
 	// save this
@@ -165,7 +329,7 @@ func (b *BotPrompt) Prompt(settings *BotSettings) (string, error) {
 
 func CountTokens(text, model string) (int, error) {
 	// Get tiktoken encoding for the model
-	tke, err := tiktoken.EncodingForModel(model)
+	tke, err := ModelEncoding(model)
 	if err != nil {
 		return 0, err
 	}
@@ -181,11 +345,17 @@ func (b *BotPrompt) AsCompletionRequest(s *BotSettings) (*openai.CompletionReque
 		return nil, err
 	}
 
+	// can't be 0
+	mtokens := s.completionTokens(b.PromptLength)
+	if mtokens < 1 {
+		return nil, &TokenLimitError{Model: s.Model, Tokens: b.PromptLength, Limit: b.PromptLength + mtokens}
+	}
+
 	return &openai.CompletionRequest{
 		Model:            s.Model,
 		Prompt:           p,
 		Temperature:      s.Temp,
-		MaxTokens:        s.MaxTokens - b.PromptLength,
+		MaxTokens:        mtokens,
 		TopP:             s.TopP,
 		FrequencyPenalty: s.FrequencyPenalty,
 		PresencePenalty:  s.PresencePenalty,
@@ -193,7 +363,9 @@ func (b *BotPrompt) AsCompletionRequest(s *BotSettings) (*openai.CompletionReque
 	}, nil
 }
 
-func (b *BotPrompt) AsChatCompletionRequest(s *BotSettings) (*openai.ChatCompletionRequest, error) {
+// defaultChatMessages sends the instructions as the system message, then the history, then the rendered
+// template as the user message
+func (b *BotPrompt) defaultChatMessages(s *BotSettings) ([]openai.ChatCompletionMessage, error) {
 	p, err := b.Prompt(s)
 	if err != nil {
 		return nil, err
@@ -206,6 +378,9 @@ func (b *BotPrompt) AsChatCompletionRequest(s *BotSettings) (*openai.ChatComplet
 		Content: b.Instructions,
 	})
 
+	// Examples
+	messages = append(messages, exampleMessages(b.Examples)...)
+
 	// Context
 	for i, _ := range b.History {
 		messages = append(messages, openai.ChatCompletionMessage{
@@ -220,23 +395,92 @@ func (b *BotPrompt) AsChatCompletionRequest(s *BotSettings) (*openai.ChatComplet
 		Content: p,
 	})
 
-	numTokens := 1 // odd, but necessary
+	return messages, nil
+}
+
+// chatTemplateMessages retrieves contexts and renders the ChatTemplate
+func (b *BotPrompt) chatTemplateMessages(s *BotSettings) ([]openai.ChatCompletionMessage, error) {
+	if err := b.retrieveContexts(s); err != nil {
+		return nil, err
+	}
+
+	messages, err := b.ChatTemplate.Render(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.JSONMode {
+		messages = withDesiredFormat(messages, b.DesiredFormat)
+	}
+
+	numTokens := CountChatTokens(messages, s.Model)
+	if numTokens >= s.tokenLimit() {
+		return nil, &TokenLimitError{Model: s.Model, Tokens: numTokens, Limit: s.tokenLimit()}
+	}
+
+	var transcript bytes.Buffer
+	writeTranscript(&transcript, messages)
+	b.RenderedPrompt = transcript.String()
+	b.PromptLength = numTokens
+
+	return messages, nil
+}
+
+// withDesiredFormat appends the desired format to the last message when a custom template left it out, so that
+// structured output still tells the model what to reply with
+func withDesiredFormat(messages []openai.ChatCompletionMessage, format string) []openai.ChatCompletionMessage {
+	if format == "" || len(messages) == 0 {
+		return messages
+	}
+
 	for _, m := range messages {
-		numTokens += 4
-		rC, _ := CountTokens(m.Role, s.Model)
-		numTokens += rC
-		cC, _ := CountTokens(m.Content, s.Model)
-		numTokens += cC
+		if strings.Contains(m.Content, format) {
+			return messages
+		}
+	}
+
+	last := &messages[len(messages)-1]
+	last.Content = strings.TrimRight(last.Content, "\n") + "\n\n" + format
+	return messages
+}
+
+func mentionsJSON(messages []openai.ChatCompletionMessage) bool {
+	for _, m := range messages {
+		if strings.Contains(strings.ToLower(m.Content), "json") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *BotPrompt) AsChatCompletionRequest(s *BotSettings) (*openai.ChatCompletionRequest, error) {
+	var messages []openai.ChatCompletionMessage
+	var err error
+
+	if b.ChatTemplate != nil {
+		messages, err = b.chatTemplateMessages(s)
+	} else {
+		messages, err = b.defaultChatMessages(s)
 	}
-	numTokens += 2
+
+	if err != nil {
+		return nil, err
+	}
+
+	if b.JSONMode {
+		messages = withDesiredFormat(messages, b.DesiredFormat)
+	}
+
+	numTokens := CountChatTokens(messages, s.Model)
 
 	// can't be 0
-	mtokens := s.MaxTokens - numTokens
+	mtokens := s.completionTokens(numTokens)
 	if mtokens < 1 {
-		return nil, fmt.Errorf("prompt is too long")
+		return nil, &TokenLimitError{Model: s.Model, Tokens: numTokens, Limit: numTokens + mtokens}
 	}
 
-	return &openai.ChatCompletionRequest{
+	req := &openai.ChatCompletionRequest{
 		Model:            s.Model,
 		Messages:         messages,
 		Temperature:      s.Temp,
@@ -245,5 +489,14 @@ func (b *BotPrompt) AsChatCompletionRequest(s *BotSettings) (*openai.ChatComplet
 		FrequencyPenalty: s.FrequencyPenalty,
 		PresencePenalty:  s.PresencePenalty,
 		Stop:             b.Stop,
-	}, nil
+	}
+
+	// the API rejects JSON mode unless a message asks for JSON
+	if b.JSONMode && SupportsJSONMode(s.Model) && mentionsJSON(messages) {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	}
+
+	return req, nil
 }
@@ -0,0 +1,344 @@
+// Package botmakertest provides fakes for testing code built on botMaker without OpenAI or Pinecone: a scripted
+// LLMAPIClient with deterministic embeddings that records its calls, an in-memory Storage and an httptest server
+// that mocks the OpenAI and Pinecone APIs for code that uses the real clients. Call UseOfflineTokenizer first so that
+// counting tokens doesn't download tiktoken's encodings.
+package botmakertest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrNoResponse is returned when a completion matches no rule and the script has run out of responses
+var ErrNoResponse = errors.New("botmakertest: no scripted response")
+
+// Call is a completion the client was asked for
+type Call struct {
+	Model    string
+	Body     string                         // The user's message, empty for calls made through the mock server
+	Prompt   string                         // The rendered prompt, chat messages are joined by newlines
+	Messages []openai.ChatCompletionMessage // Set for chat models
+	Contexts []string                       // Contexts retrieved for the prompt
+	Streamed bool
+	Response string
+	Err      error
+}
+
+// EmbeddingCall is a request for embeddings
+type EmbeddingCall struct {
+	Model openai.EmbeddingModel
+	Texts []string
+}
+
+// Rule answers every completion whose prompt contains Contains, with Response or, if set, Err
+type Rule struct {
+	Contains string
+	Response string
+	Err      error
+}
+
+// Client is a fake LLMAPIClient. A completion is answered by Respond if set, else by the first rule whose text is
+// in the prompt, else by the next of Responses, else by Default. Prompts are rendered, and contexts retrieved, the
+// same way as by OAIClient. Embeddings are hashed from the text, see Embed
+type Client struct {
+	Responses      []string // Consumed in order
+	Rules          []Rule
+	Default        string                          // Answers once Responses run out, ErrNoResponse when empty
+	Respond        func(call Call) (string, error) // Optional, answers every completion
+	Dimensions     int                             // Of the embeddings, defaults to 64
+	EmbeddingModel openai.EmbeddingModel           // Defaults to text-embedding-ada-002
+
+	mu             sync.Mutex
+	calls          []Call
+	embeddingCalls []EmbeddingCall
+}
+
+// NewClient returns a client that answers with the given responses in order
+func NewClient(responses ...string) *Client {
+	return &Client{Responses: responses}
+}
+
+// When adds a rule answering prompts that contain text with response
+func (c *Client) When(text, response string) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Rules = append(c.Rules, Rule{Contains: text, Response: response})
+	return c
+}
+
+// Calls returns the completions made so far
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+}
+
+// LastCall returns the latest completion, false when none was made
+func (c *Client) LastCall() (Call, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.calls) == 0 {
+		return Call{}, false
+	}
+
+	return c.calls[len(c.calls)-1], true
+}
+
+// EmbeddingCalls returns the embedding requests made so far
+func (c *Client) EmbeddingCalls() []EmbeddingCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]EmbeddingCall(nil), c.embeddingCalls...)
+}
+
+// Reset forgets the recorded calls, the script is kept
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = nil
+	c.embeddingCalls = nil
+}
+
+func (c *Client) CallCompletionAPI(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt) (string, int, error) {
+	call, err := c.render(settings, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, err := c.respond(call)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return resp, c.usage(settings, prompt, resp), nil
+}
+
+// StreamCompletionAPI sends the response to onDelta a word at a time
+func (c *Client) StreamCompletionAPI(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt,
+	onDelta func(delta string) error) (string, int, error) {
+	call, err := c.render(settings, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	call.Streamed = true
+
+	resp, err := c.respond(call)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, delta := range deltas(resp) {
+		if err := onDelta(delta); err != nil {
+			return "", 0, err
+		}
+	}
+
+	return resp, c.usage(settings, prompt, resp), nil
+}
+
+// usage sets the prompt's usage as OAIClient does and returns the total
+func (c *Client) usage(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt, resp string) int {
+	completion := countTokens(resp, settings.Model)
+	prompt.Usage = openai.Usage{
+		PromptTokens:     prompt.PromptLength,
+		CompletionTokens: completion,
+		TotalTokens:      prompt.PromptLength + completion,
+	}
+
+	return prompt.Usage.TotalTokens
+}
+
+// render builds the request OAIClient would send, retrieving contexts on the way
+func (c *Client) render(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt) (Call, error) {
+	prompt.Usage = openai.Usage{}
+	if err := settings.Validate(); err != nil {
+		return Call{}, err
+	}
+
+	call := Call{Model: settings.Model, Body: prompt.Body}
+	if botMaker.IsChatModel(settings.Model) {
+		req, err := prompt.AsChatCompletionRequest(settings)
+		if err != nil {
+			return Call{}, err
+		}
+		call.Messages = req.Messages
+		call.Prompt = joinMessages(req.Messages)
+	} else {
+		req, err := prompt.AsCompletionRequest(settings)
+		if err != nil {
+			return Call{}, err
+		}
+		call.Prompt = fmt.Sprint(req.Prompt)
+	}
+
+	call.Contexts = append([]string(nil), prompt.ContextToRender...)
+	return call, nil
+}
+
+// respond picks the scripted response for a call and records it
+func (c *Client) respond(call Call) (string, error) {
+	c.mu.Lock()
+	respond := c.Respond
+	c.mu.Unlock()
+
+	// Respond may call back into the client, so it runs unlocked
+	if respond != nil {
+		call.Response, call.Err = respond(call)
+		c.record(call)
+		return call.Response, call.Err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call.Response, call.Err = c.next(call.Prompt)
+	c.calls = append(c.calls, call)
+	return call.Response, call.Err
+}
+
+func (c *Client) next(prompt string) (string, error) {
+	for _, r := range c.Rules {
+		if strings.Contains(prompt, r.Contains) {
+			return r.Response, r.Err
+		}
+	}
+
+	if len(c.Responses) > 0 {
+		resp := c.Responses[0]
+		c.Responses = c.Responses[1:]
+		return resp, nil
+	}
+
+	if c.Default != "" {
+		return c.Default, nil
+	}
+
+	return "", fmt.Errorf("%w for prompt %q", ErrNoResponse, prompt)
+}
+
+func (c *Client) record(call Call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, call)
+}
+
+func (c *Client) CallEmbeddingAPIWithRetry(texts []string, embedModel openai.EmbeddingModel,
+	maxRetries int) (*openai.EmbeddingResponse, error) {
+	c.mu.Lock()
+	c.embeddingCalls = append(c.embeddingCalls, EmbeddingCall{Model: embedModel, Texts: append([]string(nil), texts...)})
+	c.mu.Unlock()
+
+	res := &openai.EmbeddingResponse{Object: "list", Model: embedModel, Data: make([]openai.Embedding, len(texts))}
+	for i, text := range texts {
+		res.Data[i] = openai.Embedding{Object: "embedding", Embedding: c.Embed(text), Index: i}
+		res.Usage.PromptTokens += countTokens(text, embedModel.String())
+	}
+	res.Usage.TotalTokens = res.Usage.PromptTokens
+
+	return res, nil
+}
+
+func (c *Client) GetEmbeddingsForData(chunks []botMaker.Chunk, batchSize int,
+	embedModel openai.EmbeddingModel) ([][]float32, error) {
+	if batchSize <= 0 {
+		batchSize = len(chunks)
+	}
+
+	embeddings := make([][]float32, 0, len(chunks))
+	for i := 0; i < len(chunks); i += batchSize {
+		end := i + batchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+
+		texts := make([]string, 0, end-i)
+		for _, chunk := range chunks[i:end] {
+			texts = append(texts, chunk.Text)
+		}
+
+		res, err := c.CallEmbeddingAPIWithRetry(texts, embedModel, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range res.Data {
+			embeddings = append(embeddings, d.Embedding)
+		}
+	}
+
+	return embeddings, nil
+}
+
+func (c *Client) GetEmbeddingsForPrompt(text string, embedModel openai.EmbeddingModel) ([]float32, error) {
+	res, err := c.CallEmbeddingAPIWithRetry([]string{text}, embedModel, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Data[0].Embedding, nil
+}
+
+func (c *Client) GetEmbeddingModel() openai.EmbeddingModel {
+	if c.EmbeddingModel == openai.Unknown {
+		return openai.AdaEmbeddingV2
+	}
+
+	return c.EmbeddingModel
+}
+
+func (c *Client) CheckTokenLimit(text, model string, tokenLimit int) bool {
+	n, err := botMaker.CountTokens(text, model)
+	if err != nil {
+		return false
+	}
+
+	return n < tokenLimit
+}
+
+// Embed returns the embedding of a text, a normalised bag of hashed words. The same text always has the same
+// embedding and texts sharing words are similar, which is enough for retrieval to find the chunk a question quotes
+func (c *Client) Embed(text string) []float32 {
+	return Embed(text, c.Dimensions)
+}
+
+func joinMessages(messages []openai.ChatCompletionMessage) string {
+	parts := make([]string, len(messages))
+	for i, m := range messages {
+		parts[i] = m.Content
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// deltas splits a response into words, each with the spaces that follow it
+func deltas(resp string) []string {
+	parts := strings.SplitAfter(resp, " ")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// countTokens counts with the model's tokenizer, or words when it can't be loaded
+func countTokens(text, model string) int {
+	if n, err := botMaker.CountTokens(text, model); err == nil {
+		return n
+	}
+
+	return len(strings.Fields(text))
+}
@@ -0,0 +1,158 @@
+package botmakertest_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMain(m *testing.M) {
+	botmakertest.UseOfflineTokenizer()
+	os.Exit(m.Run())
+}
+
+func newSettings(store botMaker.Storage) *botMaker.BotSettings {
+	settings := botMaker.NewBotSettings()
+	settings.ID = "capitals"
+	settings.Model = openai.GPT3Dot5Turbo
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+	return settings
+}
+
+func ask(t *testing.T, client botMaker.LLMAPIClient, settings *botMaker.BotSettings, body string) (string,
+	*botMaker.BotPrompt) {
+	t.Helper()
+
+	prompt, err := botMaker.LoadBotPrompt("", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt.Body = body
+
+	resp, _, err := client.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, prompt
+}
+
+func TestClientAnswersInOrderThenDefault(t *testing.T) {
+	client := botmakertest.NewClient("one", "two")
+	client.Default = "default"
+	settings := newSettings(nil)
+
+	for _, want := range []string{"one", "two", "default", "default"} {
+		if got, _ := ask(t, client, settings, "hello"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if n := len(client.Calls()); n != 4 {
+		t.Errorf("recorded %d calls, want 4", n)
+	}
+}
+
+func TestClientRulesComeFirst(t *testing.T) {
+	client := botmakertest.NewClient("scripted").When("Berlin", "Germany")
+	settings := newSettings(nil)
+
+	if got, _ := ask(t, client, settings, "Where is Berlin?"); got != "Germany" {
+		t.Errorf("got %q, want the rule's response", got)
+	}
+
+	if got, _ := ask(t, client, settings, "Where is Paris?"); got != "scripted" {
+		t.Errorf("got %q, want the scripted response", got)
+	}
+}
+
+func TestClientWithoutResponse(t *testing.T) {
+	client := botmakertest.NewClient()
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "hello"
+
+	_, _, err := client.CallCompletionAPI(newSettings(nil), prompt)
+	if !errors.Is(err, botmakertest.ErrNoResponse) {
+		t.Fatalf("got %v, want ErrNoResponse", err)
+	}
+
+	call, ok := client.LastCall()
+	if !ok || !errors.Is(call.Err, botmakertest.ErrNoResponse) {
+		t.Errorf("the failed call was not recorded: %+v", call)
+	}
+}
+
+func TestClientRetrievesContexts(t *testing.T) {
+	client := botmakertest.NewClient().When("Brandenburg", "The Brandenburg Gate.")
+	store := botmakertest.NewStorage("capitals")
+	learn := &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store, Client: client}
+	if _, err := learn.Learn("Berlin is known for the Brandenburg Gate. Paris has the Eiffel Tower.",
+		"capitals"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, prompt := ask(t, client, newSettings(store), "What is Berlin known for, the Brandenburg Gate?")
+	if resp != "The Brandenburg Gate." {
+		t.Errorf("got %q", resp)
+	}
+
+	call, _ := client.LastCall()
+	if len(call.Contexts) == 0 || !strings.Contains(call.Contexts[0], "Brandenburg") {
+		t.Errorf("expected the Berlin chunk first, got %q", call.Contexts)
+	}
+
+	if !strings.Contains(call.Prompt, prompt.Body) {
+		t.Errorf("the rendered prompt is missing the question: %q", call.Prompt)
+	}
+
+	if len(client.EmbeddingCalls()) == 0 {
+		t.Error("no embedding calls were recorded")
+	}
+
+	client.Reset()
+	if len(client.Calls()) != 0 || len(client.EmbeddingCalls()) != 0 {
+		t.Error("Reset kept calls")
+	}
+}
+
+func TestClientStreamsWords(t *testing.T) {
+	client := botmakertest.NewClient("one two three")
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "count"
+
+	var deltas []string
+	resp, _, err := client.StreamCompletionAPI(newSettings(nil), prompt, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != "one two three" || strings.Join(deltas, "") != resp || len(deltas) != 3 {
+		t.Errorf("got %q from deltas %q", resp, deltas)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	a := botmakertest.Embed("the capital of Germany", 0)
+	if len(a) != 64 {
+		t.Fatalf("got %d dimensions, want 64", len(a))
+	}
+
+	if botMaker.CosineSimilarity(a, botmakertest.Embed("the capital of Germany", 0)) < 0.999 {
+		t.Error("the same text embedded differently")
+	}
+
+	close := botMaker.CosineSimilarity(a, botmakertest.Embed("capital of Germany", 0))
+	far := botMaker.CosineSimilarity(a, botmakertest.Embed("penguins swim", 0))
+	if close <= far {
+		t.Errorf("texts sharing words should be closer: %v <= %v", close, far)
+	}
+}
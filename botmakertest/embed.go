@@ -0,0 +1,76 @@
+package botmakertest
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Embed returns a deterministic embedding of a text with the given number of dimensions, 64 when 0. Every word is
+// hashed to a dimension and a sign, and the sum is normalised, so the cosine similarity of two texts grows with the
+// words they share
+func Embed(text string, dimensions int) []float32 {
+	if dimensions <= 0 {
+		dimensions = 64
+	}
+
+	vec := make([]float64, dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for _, w := range words {
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		sum := h.Sum64()
+
+		sign := 1.0
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vec[sum%uint64(dimensions)] += sign
+	}
+
+	norm := 0.0
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	out := make([]float32, dimensions)
+	for i, v := range vec {
+		if norm > 0 {
+			out[i] = float32(v / norm)
+		}
+	}
+
+	return out
+}
+
+var offlineOnce sync.Once
+
+// UseOfflineTokenizer replaces tiktoken's encodings with a byte-level one for the rest of the process, so that
+// rendering prompts and counting tokens don't download them. Every byte is a token, counts are higher than with the
+// real encodings but deterministic. Call it before anything counts tokens, e.g. in TestMain
+func UseOfflineTokenizer() {
+	offlineOnce.Do(func() {
+		ranks := make(map[string]int, 256)
+		for b := 0; b < 256; b++ {
+			ranks[string([]byte{byte(b)})] = b
+		}
+
+		for _, name := range []string{"cl100k_base", "p50k_base", "p50k_edit", "r50k_base"} {
+			tiktoken.ENCODING_MAP[name] = &tiktoken.Encoding{
+				Name:           name,
+				PatStr:         `\S+|\s+`,
+				MergeableRanks: ranks,
+				SpecialTokens:  map[string]int{tiktoken.ENDOFTEXT: 256},
+				ExplicitNVocab: 257,
+			}
+		}
+	})
+}
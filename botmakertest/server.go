@@ -0,0 +1,429 @@
+package botmakertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Request is an HTTP request received by the mock server
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Server mocks the OpenAI API under /v1 and a Pinecone index under /pinecone, for testing code that uses OAIClient
+// and Pinecone. Completions and embeddings come from Client, vectors are kept in Storage
+type Server struct {
+	*httptest.Server
+	Client  *Client
+	Storage *Storage
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewServer starts a mock server, a nil client or store is replaced by an empty one. Close it when done
+func NewServer(client *Client, store *Storage) *Server {
+	if client == nil {
+		client = NewClient()
+	}
+
+	if store == nil {
+		store = NewStorage("")
+	}
+
+	s := &Server{Client: client, Storage: store}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/completions", s.handleCompletions)
+	mux.HandleFunc("/v1/embeddings", s.handleEmbeddings)
+	mux.HandleFunc("/pinecone/query", s.handleQuery)
+	mux.HandleFunc("/pinecone/vectors/upsert", s.handleUpsert)
+	mux.HandleFunc("/pinecone/vectors/delete", s.handleDelete)
+	mux.HandleFunc("/pinecone/vectors/list", s.handleList)
+	mux.HandleFunc("/pinecone/vectors/fetch", s.handleFetch)
+	mux.HandleFunc("/pinecone/describe_index_stats", s.handleStats)
+
+	s.Server = httptest.NewServer(s.record(mux))
+	return s
+}
+
+// OpenAIConfig returns a go-openai configuration pointing at the server
+func (s *Server) OpenAIConfig() openai.ClientConfig {
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = s.URL + "/v1"
+	return cfg
+}
+
+// NewOAIClient returns a real OAIClient that talks to the server
+func (s *Server) NewOAIClient() *botMaker.OAIClient {
+	return &botMaker.OAIClient{Client: openai.NewClientWithConfig(s.OpenAIConfig())}
+}
+
+// NewPinecone returns a real Pinecone store that talks to the server and uploads into namespace
+func (s *Server) NewPinecone(namespace string) *botMaker.Pinecone {
+	return &botMaker.Pinecone{APIEndpoint: s.URL + "/pinecone", APIKey: "test", UUID: namespace}
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+		s.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	call := Call{Model: req.Model, Messages: req.Messages, Prompt: joinMessages(req.Messages), Streamed: req.Stream}
+	resp, err := s.Client.respond(call)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	if !req.Stream {
+		writeJSON(w, openai.ChatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: resp},
+				FinishReason: openai.FinishReasonStop,
+			}},
+			Usage: usage(call.Prompt, resp, req.Model),
+		})
+		return
+	}
+
+	stream(w, func(send func(v interface{})) {
+		chunk := func(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason) {
+			send(openai.ChatCompletionStreamResponse{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: time.Now().Unix(),
+				Model:   req.Model,
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finish}},
+			})
+		}
+
+		chunk(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "")
+		for _, d := range deltas(resp) {
+			chunk(openai.ChatCompletionStreamChoiceDelta{Content: d}, "")
+		}
+		chunk(openai.ChatCompletionStreamChoiceDelta{}, openai.FinishReasonStop)
+	})
+}
+
+func (s *Server) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openai.CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	prompt, ok := req.Prompt.(string)
+	if !ok {
+		prompt = fmt.Sprint(req.Prompt)
+	}
+
+	call := Call{Model: req.Model, Prompt: prompt, Streamed: req.Stream}
+	resp, err := s.Client.respond(call)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id := fmt.Sprintf("cmpl-%d", time.Now().UnixNano())
+	if !req.Stream {
+		writeJSON(w, openai.CompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+			Choices: []openai.CompletionChoice{{Text: resp, FinishReason: string(openai.FinishReasonStop)}},
+			Usage:   usage(prompt, resp, req.Model),
+		})
+		return
+	}
+
+	stream(w, func(send func(v interface{})) {
+		for _, d := range deltas(resp) {
+			send(openai.CompletionResponse{
+				ID:      id,
+				Object:  "text_completion",
+				Created: time.Now().Unix(),
+				Model:   req.Model,
+				Choices: []openai.CompletionChoice{{Text: d}},
+			})
+		}
+	})
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input json.RawMessage       `json:"input"`
+		Model openai.EmbeddingModel `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var texts []string
+	if err := json.Unmarshal(req.Input, &texts); err != nil {
+		var text string
+		if err := json.Unmarshal(req.Input, &text); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("input must be a string or a list of strings"))
+			return
+		}
+		texts = []string{text}
+	}
+
+	res, err := s.Client.CallEmbeddingAPIWithRetry(texts, req.Model, 1)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		botMaker.PineconeQueryRequest
+		Vector []float32 `json:"vector"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queries := req.Queries
+	if len(req.Vector) > 0 {
+		queries = append(queries, botMaker.PineconeQueryItem{Values: req.Vector})
+	}
+
+	res := botMaker.PineconeQueryResponse{Results: make([]botMaker.PineconeQueryResponseResult, len(queries))}
+	for i, q := range queries {
+		matches := s.Storage.query(req.Namespace, q.Values, req.TopK, req.IncludeValues)
+		if !req.IncludeMetadata {
+			for j := range matches {
+				matches[j].Metadata = nil
+			}
+		}
+		res.Results[i].Matches = matches
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleUpsert(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Vectors   []botMaker.PineconeVector `json:"vectors"`
+		Namespace string                    `json:"namespace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Storage.Upsert(req.Namespace, req.Vectors); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]int{"upsertedCount": len(req.Vectors)})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs       []string `json:"ids"`
+		DeleteAll bool     `json:"deleteAll"`
+		Namespace string   `json:"namespace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DeleteAll {
+		s.Storage.DeleteNamespace(req.Namespace)
+	} else {
+		s.Storage.Delete(req.Namespace, req.IDs...)
+	}
+
+	writeJSON(w, struct{}{})
+}
+
+// handleList pages through the IDs of a namespace, the pagination token is the offset of the next page
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	namespace := q.Get("namespace")
+
+	limit := 100
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	offset := 0
+	if token := q.Get("paginationToken"); token != "" {
+		var err error
+		if offset, err = strconv.Atoi(token); err != nil {
+			http.Error(w, "invalid pagination token", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids := make([]string, 0)
+	for _, v := range s.Storage.Vectors(namespace) {
+		if strings.HasPrefix(v.ID, q.Get("prefix")) {
+			ids = append(ids, v.ID)
+		}
+	}
+
+	type id struct {
+		ID string `json:"id"`
+	}
+	res := struct {
+		Vectors    []id              `json:"vectors"`
+		Pagination map[string]string `json:"pagination,omitempty"`
+		Namespace  string            `json:"namespace"`
+	}{Vectors: make([]id, 0), Namespace: namespace}
+
+	for i := offset; i < len(ids) && i < offset+limit; i++ {
+		res.Vectors = append(res.Vectors, id{ID: ids[i]})
+	}
+
+	if offset+limit < len(ids) {
+		res.Pagination = map[string]string{"next": strconv.Itoa(offset + limit)}
+	}
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	namespace := q.Get("namespace")
+
+	wanted := make(map[string]bool)
+	for _, id := range q["ids"] {
+		wanted[id] = true
+	}
+
+	vectors := make(map[string]botMaker.PineconeVector)
+	for _, v := range s.Storage.Vectors(namespace) {
+		if wanted[v.ID] {
+			vectors[v.ID] = v
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"vectors": vectors, "namespace": namespace})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	counts, err := s.Storage.Namespaces()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stats := botMaker.PineconeIndexStats{Namespaces: make(map[string]botMaker.PineconeNamespaceStats, len(counts))}
+	names := make([]string, 0, len(counts))
+	for ns, n := range counts {
+		stats.Namespaces[ns] = botMaker.PineconeNamespaceStats{VectorCount: n}
+		stats.TotalVectorCount += n
+		names = append(names, ns)
+	}
+
+	// the dimension of the first vector found, in a stable order
+	sort.Strings(names)
+	for _, ns := range names {
+		if vectors := s.Storage.Vectors(ns); len(vectors) > 0 {
+			stats.Dimension = len(vectors[0].Values)
+			break
+		}
+	}
+
+	writeJSON(w, stats)
+}
+
+func usage(prompt, response, model string) openai.Usage {
+	u := openai.Usage{PromptTokens: countTokens(prompt, model), CompletionTokens: countTokens(response, model)}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": err.Error(), "type": "invalid_request_error"},
+	})
+}
+
+// stream writes the values sent by fn as server-sent events, followed by [DONE]
+func stream(w http.ResponseWriter, fn func(send func(v interface{}))) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	write := func(data string) {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	fn(func(v interface{}) {
+		data, err := json.Marshal(v)
+		if err == nil {
+			write(string(data))
+		}
+	})
+	write("[DONE]")
+}
@@ -0,0 +1,70 @@
+package botmakertest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestServerWithRealClients(t *testing.T) {
+	client := botmakertest.NewClient().When("Eiffel", "The Eiffel Tower.")
+	srv := botmakertest.NewServer(client, nil)
+	defer srv.Close()
+
+	cl := srv.NewOAIClient()
+	pc := srv.NewPinecone("capitals")
+	learn := &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: pc, Client: cl}
+	if _, err := learn.Learn("Paris is known for the Eiffel Tower. Berlin has the Brandenburg Gate.",
+		"capitals"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(srv.Storage.Vectors("capitals")); n == 0 {
+		t.Fatal("nothing was upserted through the Pinecone API")
+	}
+
+	resp, prompt := ask(t, cl, newSettings(pc), "What is Paris known for, the Eiffel Tower?")
+	if resp != "The Eiffel Tower." {
+		t.Errorf("got %q", resp)
+	}
+
+	if len(prompt.ContextToRender) == 0 || !strings.Contains(prompt.ContextToRender[0], "Eiffel") {
+		t.Errorf("expected the Paris chunk first, got %q", prompt.ContextToRender)
+	}
+
+	paths := map[string]int{}
+	for _, r := range srv.Requests() {
+		paths[r.Path]++
+	}
+	for _, path := range []string{"/v1/embeddings", "/v1/chat/completions", "/pinecone/vectors/upsert",
+		"/pinecone/query"} {
+		if paths[path] == 0 {
+			t.Errorf("no request to %s, got %v", path, paths)
+		}
+	}
+}
+
+func TestServerStreams(t *testing.T) {
+	srv := botmakertest.NewServer(botmakertest.NewClient("streamed in words"), nil)
+	defer srv.Close()
+
+	cl := srv.NewOAIClient()
+	prompt, _ := botMaker.LoadBotPrompt("", cl)
+	prompt.Body = "stream please"
+
+	var deltas []string
+	resp, _, err := cl.StreamCompletionAPI(newSettings(nil), prompt, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != "streamed in words" || len(deltas) < 2 {
+		t.Errorf("got %q from %q", resp, deltas)
+	}
+}
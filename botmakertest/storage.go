@@ -0,0 +1,188 @@
+package botmakertest
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/lonelycode/botMaker"
+)
+
+// Storage is an in-memory botMaker.Storage and NamespaceStore that ranks vectors by cosine similarity, like a
+// Pinecone index with the cosine metric. It is safe for concurrent use
+type Storage struct {
+	UUID          string // Namespace UploadEmbeddings writes to, as with Pinecone
+	IncludeValues bool   // Return match vectors from Retrieve, needed by rerankers such as MMR
+
+	mu         sync.RWMutex
+	namespaces map[string]map[string]botMaker.PineconeVector
+}
+
+// NewStorage returns an empty store that uploads into namespace
+func NewStorage(namespace string) *Storage {
+	return &Storage{UUID: namespace}
+}
+
+func (s *Storage) UploadEmbeddings(embeddings [][]float32, chunks []botMaker.Chunk) error {
+	vectors := make([]botMaker.PineconeVector, len(embeddings))
+	for i, embedding := range embeddings {
+		vectors[i] = botMaker.PineconeVector{
+			ID:       botMaker.ChunkID(chunks[i].Title, i),
+			Values:   embedding,
+			Metadata: botMaker.ChunkMetadata(chunks[i]),
+		}
+	}
+
+	return s.Upsert(s.UUID, vectors)
+}
+
+// Retrieve returns the topK vectors of the namespace most similar to the embedding, ties are broken by ID
+func (s *Storage) Retrieve(questionEmbedding []float32, topK int, uuid string) ([]botMaker.QueryMatch, error) {
+	return s.query(uuid, questionEmbedding, topK, s.IncludeValues), nil
+}
+
+func (s *Storage) query(namespace string, embedding []float32, topK int, includeValues bool) []botMaker.QueryMatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make([]botMaker.QueryMatch, 0, len(s.namespaces[namespace]))
+	for _, v := range s.namespaces[namespace] {
+		m := botMaker.QueryMatch{ID: v.ID, Score: cosine(embedding, v.Values), Metadata: copyMetadata(v.Metadata)}
+		if includeValues {
+			m.Values = append([]float32(nil), v.Values...)
+		}
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+
+	return matches
+}
+
+// Upsert writes vectors into a namespace, replacing those with the same ID
+func (s *Storage) Upsert(namespace string, vectors []botMaker.PineconeVector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]map[string]botMaker.PineconeVector)
+	}
+
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		ns = make(map[string]botMaker.PineconeVector)
+		s.namespaces[namespace] = ns
+	}
+
+	for _, v := range vectors {
+		ns[v.ID] = botMaker.PineconeVector{
+			ID:       v.ID,
+			Values:   append([]float32(nil), v.Values...),
+			Metadata: copyMetadata(v.Metadata),
+		}
+	}
+
+	return nil
+}
+
+// Namespaces returns the number of vectors in each namespace
+func (s *Storage) Namespaces() (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int, len(s.namespaces))
+	for name, ns := range s.namespaces {
+		counts[name] = len(ns)
+	}
+
+	return counts, nil
+}
+
+// DeleteNamespace deletes every vector in a namespace
+func (s *Storage) DeleteNamespace(namespace string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.namespaces, namespace)
+	return nil
+}
+
+// Delete deletes vectors from a namespace by ID
+func (s *Storage) Delete(namespace string, ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.namespaces[namespace], id)
+	}
+}
+
+// ExportNamespace calls fn with every vector in a namespace, in ID order
+func (s *Storage) ExportNamespace(namespace string, fn func(botMaker.PineconeVector) error) error {
+	for _, v := range s.Vectors(namespace) {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Vectors returns copies of the vectors in a namespace, in ID order
+func (s *Storage) Vectors(namespace string) []botMaker.PineconeVector {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vectors := make([]botMaker.PineconeVector, 0, len(s.namespaces[namespace]))
+	for _, v := range s.namespaces[namespace] {
+		vectors = append(vectors, botMaker.PineconeVector{
+			ID:       v.ID,
+			Values:   append([]float32(nil), v.Values...),
+			Metadata: copyMetadata(v.Metadata),
+		})
+	}
+
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i].ID < vectors[j].ID })
+	return vectors
+}
+
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+
+	return out
+}
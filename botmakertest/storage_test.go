@@ -0,0 +1,67 @@
+package botmakertest_test
+
+import (
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func TestStorageRanksByCosine(t *testing.T) {
+	store := botmakertest.NewStorage("ns")
+	err := store.Upsert("ns", []botMaker.PineconeVector{
+		{ID: "a", Values: []float32{1, 0}},
+		{ID: "b", Values: []float32{0.7, 0.7}},
+		{ID: "c", Values: []float32{0, 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := store.Retrieve([]float32{1, 0.1}, 2, "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 2 || matches[0].ID != "a" || matches[1].ID != "b" {
+		t.Fatalf("got %+v, want a then b", matches)
+	}
+
+	if matches[0].Values != nil {
+		t.Error("values returned without IncludeValues")
+	}
+
+	if other, _ := store.Retrieve([]float32{1, 0}, 2, "other"); len(other) != 0 {
+		t.Errorf("namespaces leaked: %+v", other)
+	}
+}
+
+func TestStorageNamespaces(t *testing.T) {
+	store := botmakertest.NewStorage("docs")
+	chunks := []botMaker.Chunk{{Title: "doc", Text: "one"}, {Title: "doc", Text: "two"}}
+	if err := store.UploadEmbeddings([][]float32{{1}, {2}}, chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, _ := store.Namespaces()
+	if counts["docs"] != 2 {
+		t.Fatalf("got %v", counts)
+	}
+
+	vectors := store.Vectors("docs")
+	if vectors[0].Metadata["text"] != "one" || vectors[0].ID != botMaker.ChunkID("doc", 0) {
+		t.Errorf("unexpected vector %+v", vectors[0])
+	}
+
+	store.Delete("docs", vectors[0].ID)
+	if len(store.Vectors("docs")) != 1 {
+		t.Error("Delete kept the vector")
+	}
+
+	if err := store.DeleteNamespace("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := store.Namespaces(); len(counts) != 0 {
+		t.Errorf("DeleteNamespace kept %v", counts)
+	}
+}
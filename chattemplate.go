@@ -0,0 +1,183 @@
+package botMaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Placeholder roles in a ChatTemplate, they expand to the prompt's History and to its few-shot Examples as
+// user/assistant pairs
+const (
+	ChatRoleHistory  = "history"
+	ChatRoleExamples = "examples"
+)
+
+// ChatTemplate declares the messages sent to a chat model, every message content is a text/template rendered
+// against the BotPrompt and messages that render empty are left out (e.g. the context message when nothing was
+// retrieved). Templates are JSON:
+//
+//	{"messages": [
+//	  {"role": "system", "content": "{{.Instructions}}"},
+//	  {"role": "user", "content": "What is a sentinel?"},
+//	  {"role": "assistant", "content": "A process that monitors redis instances..."},
+//	  {"role": "examples"},
+//	  {"role": "system", "content": "{{ range numbered .ContextToRender }}{{.}}\n{{ end }}"},
+//	  {"role": "history"},
+//	  {"role": "user", "content": "{{.Body}}"}
+//	]}
+type ChatTemplate struct {
+	Messages []ChatTemplateMessage `json:"messages"`
+}
+
+type ChatTemplateMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content,omitempty"`
+
+	tpl *template.Template
+}
+
+// DEFAULT_CHAT_TEMPLATE mirrors the messages AsChatCompletionRequest builds without a chat template, with the
+// contexts moved into their own system message
+var DEFAULT_CHAT_TEMPLATE = `{"messages": [
+  {"role": "system", "content": "{{.Instructions}}"},
+  {"role": "examples"},
+  {"role": "system", "content": "{{ if .ContextToRender }}Use the following context to help with your response:\n{{ range $ctx := .ContextToRender }}\n{{$ctx}}\n{{ end }}{{ end }}"},
+  {"role": "history"},
+  {"role": "user", "content": "{{.Body}}{{ if .DesiredFormat }}\nProvide your output using the following format:\n{{.DesiredFormat}}{{ end }}"}
+]}`
+
+// ParseChatTemplate parses and validates a JSON chat template
+func ParseChatTemplate(data []byte) (*ChatTemplate, error) {
+	ct := &ChatTemplate{}
+	if err := json.Unmarshal(data, ct); err != nil {
+		return nil, fmt.Errorf("%w: invalid chat template: %w", ErrTemplateLoad, err)
+	}
+
+	for i := range ct.Messages {
+		m := &ct.Messages[i]
+		switch m.Role {
+		case ChatRoleHistory, ChatRoleExamples:
+			continue
+		case openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+		default:
+			return nil, fmt.Errorf("%w: chat template message %d: unknown role %q", ErrTemplateLoad, i, m.Role)
+		}
+
+		tpl, err := NewPromptTemplate(fmt.Sprintf("message-%d", i), m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: chat template message %d: %w", ErrTemplateLoad, i, err)
+		}
+		m.tpl = tpl
+	}
+
+	// test render so that mistakes show up now and not on the first prompt
+	if _, err := ct.Render(&BotPrompt{
+		Instructions:    "instructions",
+		Body:            "body",
+		ContextToRender: []string{"context"},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTemplateLoad, err)
+	}
+
+	return ct, nil
+}
+
+// LoadChatTemplate reads and parses a JSON chat template file
+func LoadChatTemplate(path string) (*ChatTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTemplateLoad, err)
+	}
+
+	return ParseChatTemplate(data)
+}
+
+// Render returns the chat messages for the prompt
+func (ct *ChatTemplate) Render(b *BotPrompt) ([]openai.ChatCompletionMessage, error) {
+	messages := make([]openai.ChatCompletionMessage, 0, len(ct.Messages)+len(b.History))
+	for i := range ct.Messages {
+		m := &ct.Messages[i]
+		if m.Role == ChatRoleExamples {
+			messages = append(messages, exampleMessages(b.Examples)...)
+			continue
+		}
+
+		if m.Role == ChatRoleHistory {
+			for _, h := range b.History {
+				messages = append(messages, openai.ChatCompletionMessage{
+					Role:    h.Role,
+					Content: h.Content,
+				})
+			}
+			continue
+		}
+
+		if m.tpl == nil {
+			return nil, fmt.Errorf("chat template was not created with ParseChatTemplate")
+		}
+
+		var out bytes.Buffer
+		if err := m.tpl.Execute(&out, b); err != nil {
+			return nil, err
+		}
+
+		content := strings.TrimSpace(out.String())
+		if content == "" {
+			continue
+		}
+
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    m.Role,
+			Content: content,
+		})
+	}
+
+	return messages, nil
+}
+
+// writeTranscript writes messages as "role: content" blocks, used as the rendered prompt of chat templates
+func writeTranscript(w io.Writer, messages []openai.ChatCompletionMessage) {
+	for _, m := range messages {
+		fmt.Fprintf(w, "%s: %s\n\n", m.Role, m.Content)
+	}
+}
+
+// ChatMessageTokens returns the number of tokens a single chat message uses, including the per message overhead
+func ChatMessageTokens(m openai.ChatCompletionMessage, model string) int {
+	// see https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+	overhead := 3
+	if model == openai.GPT3Dot5Turbo0301 {
+		overhead = 4
+	}
+
+	role, _ := CountTokens(m.Role, model)
+	content, _ := CountTokens(m.Content, model)
+	tokens := overhead + role + content
+
+	if m.Name != "" {
+		name, _ := CountTokens(m.Name, model)
+		tokens += name
+		if model == openai.GPT3Dot5Turbo0301 {
+			tokens--
+		}
+	}
+
+	return tokens
+}
+
+// CountChatTokens returns the number of prompt tokens a list of chat messages uses
+func CountChatTokens(messages []openai.ChatCompletionMessage, model string) int {
+	tokens := 3 // every reply is primed with <|start|>assistant<|message|>
+	for _, m := range messages {
+		tokens += ChatMessageTokens(m, model)
+	}
+
+	return tokens
+}
@@ -0,0 +1,99 @@
+package botMaker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+const supportChat = `{"messages": [
+  {"role": "system", "content": "{{.Instructions}}"},
+  {"role": "examples"},
+  {"role": "system", "content": "{{ range numbered .ContextToRender }}{{.}}\n{{ end }}"},
+  {"role": "history"},
+  {"role": "user", "content": "{{.Body}}"}
+]}`
+
+func TestChatTemplateRender(t *testing.T) {
+	ct, err := botMaker.ParseChatTemplate([]byte(supportChat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := &botMaker.BotPrompt{
+		Instructions:    "Be brief.",
+		Body:            "And Italy?",
+		ContextToRender: []string{"Rome is the capital of Italy."},
+		History:         []*botMaker.RenderContext{{Role: "user", Content: "Capital of France?"}},
+		Examples:        []botMaker.Example{{Question: "Capital of Spain?", Answer: "Madrid."}},
+	}
+
+	messages, err := ct.Render(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []openai.ChatCompletionMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Capital of Spain?"},
+		{Role: "assistant", Content: "Madrid."},
+		{Role: "system", Content: "[1] Rome is the capital of Italy."},
+		{Role: "user", Content: "Capital of France?"},
+		{Role: "user", Content: "And Italy?"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("got %+v", messages)
+	}
+	for i := range want {
+		if messages[i].Role != want[i].Role || messages[i].Content != want[i].Content {
+			t.Errorf("message %d is %+v, want %+v", i, messages[i], want[i])
+		}
+	}
+
+	// messages that render empty are left out
+	prompt.ContextToRender, prompt.History, prompt.Examples = nil, nil, nil
+	if messages, _ := ct.Render(prompt); len(messages) != 2 {
+		t.Errorf("got %+v", messages)
+	}
+}
+
+func TestParseChatTemplateErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"json":     `{"messages": [`,
+		"role":     `{"messages": [{"role": "narrator", "content": "hi"}]}`,
+		"template": `{"messages": [{"role": "user", "content": "{{ .Body "}]}`,
+		"render":   `{"messages": [{"role": "user", "content": "{{ .Missing }}"}]}`,
+	} {
+		if _, err := botMaker.ParseChatTemplate([]byte(data)); !errors.Is(err, botMaker.ErrTemplateLoad) {
+			t.Errorf("%s: got %v, want ErrTemplateLoad", name, err)
+		}
+	}
+}
+
+func TestChatTemplateIsSentAsMessages(t *testing.T) {
+	ct, err := botMaker.ParseChatTemplate([]byte(supportChat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := botmakertest.NewClient("ok")
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.ChatTemplate = ct
+	prompt.Instructions = "Be brief."
+	prompt.Body = "Capital of Germany?"
+	if _, _, err := client.CallCompletionAPI(newSettings(), prompt); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := client.LastCall()
+	if len(call.Messages) != 2 || call.Messages[1].Content != "Capital of Germany?" {
+		t.Errorf("got %+v", call.Messages)
+	}
+
+	if tokens := botMaker.CountChatTokens(call.Messages, openai.GPT3Dot5Turbo); tokens <= 3 {
+		t.Errorf("got %d tokens", tokens)
+	}
+}
@@ -0,0 +1,91 @@
+package botMaker
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Citation maps a [n] reference in an answer back to the context it refers to
+type Citation struct {
+	Number   int     `json:"number"`
+	ID       string  `json:"id,omitempty"`
+	Source   string  `json:"source,omitempty"`
+	Title    string  `json:"title"`
+	FileName string  `json:"file_name,omitempty"`
+	Start    int     `json:"start"`
+	End      int     `json:"end"`
+	Score    float32 `json:"score"`
+}
+
+// Answer is a completion together with the sources it cited
+type Answer struct {
+	Text      string     `json:"text"`
+	Tokens    int        `json:"tokens"`
+	Citations []Citation `json:"citations"`
+}
+
+var citationRef = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+var citationNumber = regexp.MustCompile(`\d+`)
+
+// Cite finds the [n] (or [n, m]) references in a response and returns a citation for each distinct context that
+// was referenced, in number order. References that don't match a rendered context are ignored
+func (b *BotPrompt) Cite(response string) []Citation {
+	contexts := b.IncludedContexts
+	if len(contexts) != len(b.ContextToRender) {
+		// contexts were set by hand rather than by GetContexts
+		contexts = make([]Context, len(b.ContextToRender))
+		for i := range b.ContextToRender {
+			contexts[i].Text = b.ContextToRender[i]
+			if i < len(b.ContextTitles) {
+				contexts[i].Title = b.ContextTitles[i]
+			}
+		}
+	}
+
+	seen := make(map[int]bool)
+	citations := make([]Citation, 0)
+	for _, ref := range citationRef.FindAllStringSubmatch(response, -1) {
+		for _, num := range citationNumber.FindAllString(ref[1], -1) {
+			n, err := strconv.Atoi(num)
+			if err != nil || n < 1 || n > len(contexts) || seen[n] {
+				continue
+			}
+			seen[n] = true
+
+			ctx := contexts[n-1]
+			citations = append(citations, Citation{
+				Number:   n,
+				ID:       ctx.ID,
+				Source:   ctx.Source,
+				Title:    ctx.Title,
+				FileName: ctx.FileName,
+				Start:    ctx.Start,
+				End:      ctx.End,
+				Score:    ctx.Score,
+			})
+		}
+	}
+
+	sort.Slice(citations, func(i, j int) bool {
+		return citations[i].Number < citations[j].Number
+	})
+
+	return citations
+}
+
+// AnswerWithCitations runs the prompt in citation mode and returns the answer with the sources it cited
+func AnswerWithCitations(c LLMAPIClient, settings *BotSettings, prompt *BotPrompt) (*Answer, error) {
+	prompt.Citations = true
+
+	resp, tokens, err := c.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Answer{
+		Text:      resp,
+		Tokens:    tokens,
+		Citations: prompt.Cite(resp),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package botMaker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+)
+
+func TestCite(t *testing.T) {
+	prompt := &botMaker.BotPrompt{
+		ContextToRender: []string{"Berlin is in Germany.", "Paris is in France.", "Rome is in Italy."},
+		ContextTitles:   []string{"germany.md", "france.md", "italy.md"},
+	}
+
+	citations := prompt.Cite("Berlin [3] and Paris [1, 2] are capitals [2][7] [0].")
+	if len(citations) != 3 {
+		t.Fatalf("got %+v", citations)
+	}
+
+	for i, want := range []string{"germany.md", "france.md", "italy.md"} {
+		if citations[i].Number != i+1 || citations[i].Title != want {
+			t.Errorf("citation %d is %+v, want %s", i, citations[i], want)
+		}
+	}
+}
+
+func TestCiteUsesIncludedContexts(t *testing.T) {
+	ctx := botMaker.Context{ID: "doc-1", Source: "docs", Title: "Doc", FileName: "doc.md", Start: 3, End: 9}
+	prompt := &botMaker.BotPrompt{
+		ContextToRender:  []string{"text"},
+		IncludedContexts: []botMaker.Context{ctx},
+	}
+
+	citations := prompt.Cite("As stated [1].")
+	if len(citations) != 1 || citations[0].ID != "doc-1" || citations[0].FileName != "doc.md" ||
+		citations[0].Start != 3 {
+		t.Errorf("got %+v", citations)
+	}
+
+	if got := prompt.Cite("No references here."); len(got) != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestAnswerWithCitations(t *testing.T) {
+	client, store := learned(t, "Berlin is the capital of Germany.")
+	client.Default = "Berlin [1]."
+	settings := newSettings()
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "What is the capital of Germany?"
+	answer, err := botMaker.AnswerWithCitations(client, settings, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(answer.Citations) != 1 || answer.Citations[0].Title != "capitals" {
+		t.Errorf("got %+v", answer.Citations)
+	}
+
+	call, _ := client.LastCall()
+	if !strings.Contains(call.Prompt, "[1]") {
+		t.Errorf("the contexts are not numbered in citation mode: %q", call.Prompt)
+	}
+}
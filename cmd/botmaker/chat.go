@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/sashabaranov/go-openai"
+)
+
+func (a *app) chat(args []string) error {
+	flags := flag.NewFlagSet("chat", flag.ContinueOnError)
+	sources := flags.Bool("sources", false, "list the contexts used after every response")
+	maxHistory := flags.Int("history", 20, "most recent messages kept in the conversation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("Chatting with %s (%s). Type /reset to start over, /quit or Ctrl-D to leave.\n",
+		a.bot.ID, a.bot.Settings.Model)
+
+	history := make([]*botMaker.RenderContext, 0)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Print("\n> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+
+		text := strings.TrimSpace(scanner.Text())
+		switch text {
+		case "":
+			continue
+		case "/quit", "/exit":
+			return nil
+		case "/reset":
+			history = history[:0]
+			fmt.Println("(conversation cleared)")
+			continue
+		}
+
+		prompt, err := a.bot.NewPrompt(a.client)
+		if err != nil {
+			return err
+		}
+		prompt.Body = text
+		prompt.History = history
+
+		fmt.Println()
+		resp, _, err := a.client.StreamCompletionAPI(a.bot.Settings, prompt, func(delta string) error {
+			fmt.Print(delta)
+			return nil
+		})
+		fmt.Println()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			continue
+		}
+
+		if *sources {
+			for i, c := range prompt.IncludedContexts {
+				fmt.Printf("  [%d] %s (%.3f)\n", i+1, c.Title, c.Score)
+			}
+		}
+
+		history = append(history,
+			&botMaker.RenderContext{Role: openai.ChatMessageRoleUser, Content: text},
+			&botMaker.RenderContext{Role: openai.ChatMessageRoleAssistant, Content: resp})
+		if *maxHistory > 0 && len(history) > *maxHistory {
+			history = history[len(history)-*maxHistory:]
+		}
+	}
+}
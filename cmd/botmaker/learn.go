@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+)
+
+func (a *app) learn(args []string) error {
+	flags := flag.NewFlagSet("learn", flag.ContinueOnError)
+	namespace := flags.String("ns", a.namespace(), "namespace to learn into")
+	chunkSize := flags.Int("chunk-size", 20, "sentences per chunk")
+	overlap := flags.Int("overlap", 5, "sentences shared by consecutive chunks")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("learn needs at least one file, directory or URL")
+	}
+
+	memory, err := a.memory()
+	if err != nil {
+		return err
+	}
+
+	// Learn uploads into the store's own namespace
+	if pc, ok := memory.(*botMaker.Pinecone); ok {
+		copied := *pc
+		copied.UUID = *namespace
+		memory = &copied
+	}
+
+	l := a.cfg.NewLearn(a.client, memory)
+	l.Model = a.bot.Settings.Model
+	l.ChunkSize = *chunkSize
+	l.Overlap = *overlap
+	// keyword entries are indexed under the namespace too, where query and chat search for them
+	l.Namespace = *namespace
+	l.Keywords = a.bot.Settings.Keywords
+
+	started := time.Now()
+	total, failed := 0, 0
+	for _, target := range flags.Args() {
+		n, f := a.learnTarget(l, target)
+		total += n
+		failed += f
+	}
+
+	// the keyword index is written once for the whole run, the documents that were learned are in it either way
+	if idx, ok := l.Keywords.(*botMaker.BM25Index); ok {
+		if err := idx.Save(); err != nil {
+			return fmt.Errorf("saving the keyword index: %w", err)
+		}
+	}
+
+	fmt.Printf("learned %d embeddings into %s in %v\n", total, *namespace, time.Since(started).Round(time.Millisecond))
+	if failed > 0 {
+		return fmt.Errorf("%d documents failed", failed)
+	}
+
+	return nil
+}
+
+// learnTarget learns a URL, a file or every supported file under a directory, it returns the number of embeddings
+// and of documents that failed
+func (a *app) learnTarget(l *botMaker.Learn, target string) (int, int) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return learnOne(target, l.FromURL)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", target, err)
+		return 0, 1
+	}
+
+	if !info.IsDir() {
+		return learnOne(target, l.FromFile)
+	}
+
+	total, failed := 0, 0
+	err = filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(d.Name(), ".") && path != target {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if _, ok := l.ExtensionSupported(path); !ok {
+			return nil
+		}
+
+		n, f := learnOne(path, l.FromFile)
+		total += n
+		failed += f
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", target, err)
+		failed++
+	}
+
+	return total, failed
+}
+
+func learnOne(target string, learn func(string) (int, error)) (int, int) {
+	n, err := learn(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", target, err)
+		return 0, 1
+	}
+
+	fmt.Printf("%s: %d embeddings\n", target, n)
+	return n, 0
+}
@@ -0,0 +1,185 @@
+// Command botmaker learns documents into a bot's memory, inspects what it retrieves and chats with it. Bots come
+// from a bot definition file (see botMaker.LoadBotDefinitions) and credentials from the environment (see
+// botMaker.LoadConfigFromEnv).
+//
+// Usage:
+//
+//	botmaker [-f bots.yaml] [-bot id] <command> [arguments]
+//
+// Commands:
+//
+//	learn      learn files, directories and URLs into the bot's memory
+//	query      show the contexts retrieved for a question, with their scores
+//	chat       chat with the bot, responses are streamed
+//	ns stats   show the vector count of every namespace
+//	ns delete  delete every vector in a namespace
+//	export     write a namespace's vectors as JSON lines
+//	import     upsert vectors from JSON lines into a namespace
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lonelycode/botMaker"
+)
+
+const usage = `Usage: botmaker [-f bots.yaml] [-bot id] <command> [arguments]
+
+Commands:
+  learn [-ns namespace] [-chunk-size n] [-overlap n] <file|dir|url>...
+  query [-top-k n] [-dropped] [-full] [-json] <question>
+  chat
+  ns stats
+  ns delete [-yes] [namespace]
+  export [-ns namespace] [-o file]
+  import [-ns namespace] <file>
+
+Global flags:
+`
+
+// app is what every command needs: the config, the selected bot and a client
+type app struct {
+	cfg    *botMaker.Config
+	bot    *botMaker.DefinedBot
+	client *botMaker.OAIClient
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "botmaker: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	defaultFile := os.Getenv("BOTMAKER_BOTS")
+	if defaultFile == "" {
+		defaultFile = "bots.yaml"
+	}
+
+	flags := flag.NewFlagSet("botmaker", flag.ContinueOnError)
+	file := flags.String("f", defaultFile, "bot definition file, defaults to $BOTMAKER_BOTS")
+	botID := flags.String("bot", "", "ID of the bot to use, may be left out when the file defines one bot")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command")
+	}
+
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	if cmd == "ns" {
+		if len(cmdArgs) == 0 {
+			return errors.New("ns needs a subcommand: stats or delete")
+		}
+		cmd, cmdArgs = "ns "+cmdArgs[0], cmdArgs[1:]
+	}
+
+	commands := map[string]func(*app, []string) error{
+		"learn":     (*app).learn,
+		"query":     (*app).query,
+		"chat":      (*app).chat,
+		"ns stats":  (*app).nsStats,
+		"ns delete": (*app).nsDelete,
+		"export":    (*app).export,
+		"import":    (*app).importVectors,
+	}
+
+	command, ok := commands[cmd]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	a, err := newApp(*file, *botID)
+	if err != nil {
+		return err
+	}
+
+	return command(a, cmdArgs)
+}
+
+func newApp(file, botID string) (*app, error) {
+	cfg, err := botMaker.LoadConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = botMaker.NewStdLogger(botMaker.LevelWarn)
+	}
+
+	bots, err := botMaker.LoadBotDefinitions(file, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	bot, err := selectBot(bots, botID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &app{cfg: cfg, bot: bot, client: cfg.NewOAIClient()}, nil
+}
+
+func selectBot(bots []*botMaker.DefinedBot, id string) (*botMaker.DefinedBot, error) {
+	ids := make([]string, len(bots))
+	for i, b := range bots {
+		if b.ID == id {
+			return b, nil
+		}
+		ids[i] = b.ID
+	}
+
+	if id == "" && len(bots) == 1 {
+		return bots[0], nil
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("the file defines several bots, pick one with -bot: %s", strings.Join(ids, ", "))
+	}
+
+	return nil, fmt.Errorf("no bot %q, the file defines: %s", id, strings.Join(ids, ", "))
+}
+
+// namespace returns the namespace the bot learns into
+func (a *app) namespace() string {
+	if m := a.bot.Definition.Memory; m != nil && m.Namespace != "" {
+		return m.Namespace
+	}
+
+	return a.bot.ID
+}
+
+func (a *app) memory() (botMaker.Storage, error) {
+	if a.bot.Memory == nil {
+		return nil, fmt.Errorf("bot %s has no memory", a.bot.ID)
+	}
+
+	return a.bot.Memory, nil
+}
+
+func (a *app) namespaceStore() (botMaker.NamespaceStore, error) {
+	memory, err := a.memory()
+	if err != nil {
+		return nil, err
+	}
+
+	ns, ok := memory.(botMaker.NamespaceStore)
+	if !ok {
+		return nil, fmt.Errorf("the memory of bot %s cannot manage namespaces", a.bot.ID)
+	}
+
+	return ns, nil
+}
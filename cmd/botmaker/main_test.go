@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func TestMain(m *testing.M) {
+	botmakertest.UseOfflineTokenizer()
+	os.Exit(m.Run())
+}
+
+const testBots = `
+bots:
+  - id: capitals
+    model: gpt-3.5-turbo
+    instructions: Name capitals.
+    memory:
+      backend: pinecone
+      url: {{URL}}/pinecone
+      api_key: test
+    retrieval:
+      min_score: 0.1
+`
+
+// newTestApp returns an app for a "capitals" bot whose client and memory are served by a mock server
+func newTestApp(t *testing.T, responses ...string) (*app, *botmakertest.Server) {
+	t.Helper()
+
+	srv := botmakertest.NewServer(botmakertest.NewClient(responses...), nil)
+	t.Cleanup(srv.Close)
+
+	path := filepath.Join(t.TempDir(), "bots.yaml")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(testBots, "{{URL}}", srv.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &botMaker.Config{LLMAPIKey: "test", Logger: botMaker.NopLogger{}}
+	bots, err := botMaker.LoadBotDefinitions(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := srv.NewOAIClient()
+	client.Logger = cfg.Logger
+	return &app{cfg: cfg, bot: bots[0], client: client}, srv
+}
+
+// captureStdout returns what fn printed to stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+
+	err = fn()
+	w.Close()
+	return <-out, err
+}
+
+// withStdin runs fn with input on stdin
+func withStdin(t *testing.T, input string, fn func() error) error {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	return fn()
+}
+
+func TestRunRejectsUnknownCommands(t *testing.T) {
+	for _, args := range [][]string{nil, {"teach"}, {"ns"}} {
+		if err := run(append([]string{"-f", "missing.yaml"}, args...)); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestSelectBot(t *testing.T) {
+	bots := []*botMaker.DefinedBot{{ID: "a"}, {ID: "b"}}
+
+	if b, err := selectBot(bots, "b"); err != nil || b.ID != "b" {
+		t.Errorf("got %v and %v", b, err)
+	}
+
+	for _, id := range []string{"", "c"} {
+		if _, err := selectBot(bots, id); err == nil || !strings.Contains(err.Error(), "a, b") {
+			t.Errorf("%q: got %v", id, err)
+		}
+	}
+
+	if b, err := selectBot(bots[:1], ""); err != nil || b.ID != "a" {
+		t.Errorf("got %v and %v for a single bot", b, err)
+	}
+}
+
+func TestLearnAndQuery(t *testing.T) {
+	a, srv := newTestApp(t)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "germany.txt"), []byte("Berlin is the capital of Germany."), 0644)
+	os.WriteFile(filepath.Join(dir, "france.md"), []byte("# France\n\nParis is the capital of France."), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.csv"), []byte("skipped"), 0644)
+	os.Mkdir(filepath.Join(dir, ".hidden"), 0755)
+	os.WriteFile(filepath.Join(dir, ".hidden", "secret.txt"), []byte("Skipped as well."), 0644)
+
+	out, err := captureStdout(t, func() error { return a.learn([]string{dir}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "learned 2 embeddings into capitals") {
+		t.Errorf("got %q", out)
+	}
+
+	titles := map[string]bool{}
+	for _, v := range srv.Storage.Vectors("capitals") {
+		titles[v.Metadata["title"]] = true
+	}
+	if len(titles) != 2 {
+		t.Errorf("learned %v", titles)
+	}
+
+	out, err = captureStdout(t, func() error {
+		return a.query([]string{"-json", "-top-k", "1", "What is the capital of Germany?"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Contexts []botMaker.Context `json:"contexts"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	if len(result.Contexts) != 1 || !strings.Contains(result.Contexts[0].Text, "Berlin") {
+		t.Errorf("got %+v", result.Contexts)
+	}
+}
+
+func TestLearnReportsFailedDocuments(t *testing.T) {
+	a, _ := newTestApp(t)
+
+	pages := httptest.NewServer(http.NotFoundHandler())
+	defer pages.Close()
+
+	_, err := captureStdout(t, func() error {
+		return a.learn([]string{pages.URL + "/missing.txt", filepath.Join(t.TempDir(), "missing.txt")})
+	})
+	if err == nil || !strings.Contains(err.Error(), "2 documents failed") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	a, srv := newTestApp(t)
+	srv.Storage.Upsert("capitals", []botMaker.PineconeVector{{ID: "1", Values: []float32{1}}})
+	srv.Storage.Upsert("other", []botMaker.PineconeVector{{ID: "2", Values: []float32{1}}})
+
+	out, err := captureStdout(t, func() error { return a.nsStats(nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "capitals *") || !strings.Contains(out, "other") {
+		t.Errorf("got %q", out)
+	}
+
+	deleteOther := func(answer string) error {
+		return withStdin(t, answer, func() error {
+			_, err := captureStdout(t, func() error { return a.nsDelete([]string{"other"}) })
+			return err
+		})
+	}
+
+	if err := deleteOther("no\n"); err == nil {
+		t.Error("deleted without confirmation")
+	}
+
+	if err := deleteOther("other\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.Storage.Vectors("other")) != 0 || len(srv.Storage.Vectors("capitals")) != 1 {
+		t.Errorf("deleted the wrong namespace")
+	}
+}
+
+func TestChat(t *testing.T) {
+	a, srv := newTestApp(t, "Berlin.", "Paris.")
+
+	out, err := captureStdout(t, func() error {
+		return withStdin(t, "Germany?\n\nFrance?\n/quit\n", func() error { return a.chat(nil) })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "Berlin.") || !strings.Contains(out, "Paris.") {
+		t.Errorf("got %q", out)
+	}
+
+	// the second question is asked with the first turn as history
+	if call, _ := srv.Client.LastCall(); !strings.Contains(call.Prompt, "Berlin.") {
+		t.Errorf("got prompt %q", call.Prompt)
+	}
+}
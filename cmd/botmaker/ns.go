@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lonelycode/botMaker"
+)
+
+func (a *app) nsStats(args []string) error {
+	flags := flag.NewFlagSet("ns stats", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := a.namespaceStore()
+	if err != nil {
+		return err
+	}
+
+	counts, err := store.Namespaces()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(counts))
+	for ns := range counts {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tVECTORS")
+	for _, ns := range names {
+		marker := ""
+		if ns == a.namespace() {
+			marker = " *"
+		}
+		fmt.Fprintf(w, "%s%s\t%d\n", ns, marker, counts[ns])
+	}
+
+	return w.Flush()
+}
+
+func (a *app) nsDelete(args []string) error {
+	flags := flag.NewFlagSet("ns delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	namespace := flags.Arg(0)
+	if namespace == "" {
+		namespace = a.namespace()
+	}
+
+	store, err := a.namespaceStore()
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		fmt.Printf("Delete every vector in namespace %q? Type the namespace to confirm: ", namespace)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != namespace {
+			return fmt.Errorf("not confirmed, nothing was deleted")
+		}
+	}
+
+	if err := store.DeleteNamespace(namespace); err != nil {
+		return err
+	}
+
+	fmt.Printf("deleted namespace %s\n", namespace)
+	return nil
+}
+
+// export writes one JSON vector (id, values and metadata) per line
+func (a *app) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	namespace := flags.String("ns", a.namespace(), "namespace to export")
+	output := flags.String("o", "-", "file to write, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := a.namespaceStore()
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = store.ExportNamespace(*namespace, func(v botMaker.PineconeVector) error {
+		n++
+		return enc.Encode(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d vectors from %s\n", n, *namespace)
+	return nil
+}
+
+// importVectors upserts the vectors written by export, in batches
+func (a *app) importVectors(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	namespace := flags.String("ns", a.namespace(), "namespace to import into")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("import needs a file, - for stdin")
+	}
+
+	store, err := a.namespaceStore()
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(in))
+	batch := make([]botMaker.PineconeVector, 0, 100)
+	n := 0
+	for {
+		var v botMaker.PineconeVector
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", n+1, err)
+		}
+
+		batch = append(batch, v)
+		n++
+		if len(batch) == cap(batch) {
+			if err := store.Upsert(*namespace, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := store.Upsert(*namespace, batch); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("imported %d vectors into %s\n", n, *namespace)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lonelycode/botMaker"
+)
+
+func (a *app) query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	topK := flags.Int("top-k", 0, "contexts to keep, defaults to the bot's retrieval.top_k")
+	dropped := flags.Bool("dropped", false, "also show the candidates that were left out and why")
+	full := flags.Bool("full", false, "show the whole text of every context")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	question := strings.Join(flags.Args(), " ")
+	if question == "" {
+		return fmt.Errorf("query needs a question")
+	}
+
+	memory, err := a.memory()
+	if err != nil {
+		return err
+	}
+
+	settings := *a.bot.Settings
+	if *topK > 0 {
+		settings.Retrieval = settings.RetrievalConfig()
+		settings.Retrieval.TopK = *topK
+	}
+
+	prompt, err := a.bot.NewPrompt(a.client)
+	if err != nil {
+		return err
+	}
+	prompt.Body = question
+
+	if _, err := botMaker.GetContexts(prompt, &settings, memory, a.client); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"query":    question,
+			"queries":  prompt.RetrievalQueries,
+			"contexts": prompt.IncludedContexts,
+			"dropped":  prompt.DroppedContexts,
+		})
+	}
+
+	if len(prompt.RetrievalQueries) > 1 {
+		fmt.Println("Queries:")
+		for _, q := range prompt.RetrievalQueries {
+			fmt.Printf("  %s\n", q)
+		}
+		fmt.Println()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tSCORE\tTOKENS\tSOURCE\tTITLE\tTEXT")
+	for i, c := range prompt.IncludedContexts {
+		fmt.Fprintf(w, "%d\t%.4f\t%d\t%s\t%s\t%s\n", i+1, c.Score, c.Tokens, c.Source, c.Title, snippet(c.Text, *full))
+	}
+
+	if *dropped {
+		for _, c := range prompt.DroppedContexts {
+			fmt.Fprintf(w, "-\t%.4f\t%d\t%s\t%s\t[%s] %s\n", c.Score, c.Tokens, c.Source, c.Title, c.Reason,
+				snippet(c.Text, *full))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(prompt.IncludedContexts) == 0 {
+		fmt.Printf("no contexts retrieved, %d candidates were dropped\n", len(prompt.DroppedContexts))
+	}
+
+	return nil
+}
+
+// snippet returns text on one line, cut to 80 characters unless full is set
+func snippet(text string, full bool) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if full || len(runes) <= 80 {
+		return text
+	}
+
+	return string(runes[:77]) + "..."
+}
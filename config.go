@@ -1,7 +1,7 @@
 package botMaker
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/caarlos0/env/v8"
 )
@@ -11,17 +11,64 @@ type Config struct {
 
 	PineconeKey      string `env:"PINECONE_KEY"`
 	PineconeEndpoint string `env:"PINECONE_URL"`
+
+	LogLevel string `env:"LOG_LEVEL"` // debug, info, warn, error or none, used when Logger is not set
+	Logger   Logger `env:"-"`         // Passed on to the clients and stores created from this config
 }
 
 func NewConfig() *Config {
 	return &Config{}
 }
 
-func NewConfigFromEnv() *Config {
+// LoadConfigFromEnv reads the config from environment variables, a missing or malformed variable wraps ErrConfig
+func LoadConfigFromEnv() (*Config, error) {
 	cfg := Config{}
 	if err := env.Parse(&cfg); err != nil {
-		log.Fatalf("%+v\n", err)
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+
+	if cfg.LogLevel != "" {
+		cfg.Logger = NewStdLogger(ParseLogLevel(cfg.LogLevel))
+	}
+
+	return &cfg, nil
+}
+
+// NewConfigFromEnv reads the config from environment variables, errors are logged and an empty config returned
+//
+// Deprecated: use LoadConfigFromEnv, which returns the error
+func NewConfigFromEnv() *Config {
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		DefaultLogger.Error("failed to load config from environment", "error", err)
+		return NewConfig()
 	}
 
-	return &cfg
+	return cfg
+}
+
+// NewOAIClient returns a client for the configured API key that logs to the configured Logger
+func (c *Config) NewOAIClient() *OAIClient {
+	cl := NewOAIClient(c.LLMAPIKey)
+	cl.Logger = c.Logger
+	return cl
+}
+
+// NewPinecone returns the configured Pinecone index, ingesting into namespace
+func (c *Config) NewPinecone(namespace string) *Pinecone {
+	return &Pinecone{
+		APIEndpoint: c.PineconeEndpoint,
+		APIKey:      c.PineconeKey,
+		UUID:        namespace,
+		Logger:      c.Logger,
+	}
+}
+
+// NewLearn returns a Learn that embeds with client, stores into memory and logs to the configured Logger
+func (c *Config) NewLearn(client LLMAPIClient, memory Storage) *Learn {
+	return &Learn{
+		Client: client,
+		Memory: memory,
+		Logger: c.Logger,
+	}
 }
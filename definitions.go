@@ -0,0 +1,514 @@
+package botMaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// BotFile is a bot definition file, it can define several bots. Files are YAML or TOML, e.g.:
+//
+//	bots:
+//	  - id: support
+//	    model: gpt-4
+//	    temperature: 0.3
+//	    instructions: You are a helpful support assistant.
+//	    template: prompts/support.tpl
+//	    memory:
+//	      backend: pinecone
+//	      url: ${PINECONE_URL}
+//	      api_key: ${PINECONE_KEY}
+//	    retrieval:
+//	      top_k: 5
+//	      min_score: 0.8
+type BotFile struct {
+	Bots []BotDefinition `yaml:"bots" toml:"bots"`
+}
+
+// BotDefinition declares a bot, optional values left out use the library defaults
+type BotDefinition struct {
+	ID               string   `yaml:"id" toml:"id"`
+	Model            string   `yaml:"model" toml:"model"`
+	Temperature      *float32 `yaml:"temperature" toml:"temperature"`
+	TopP             *float32 `yaml:"top_p" toml:"top_p"`
+	FrequencyPenalty *float32 `yaml:"frequency_penalty" toml:"frequency_penalty"`
+	PresencePenalty  *float32 `yaml:"presence_penalty" toml:"presence_penalty"`
+	MaxTokens        int      `yaml:"max_tokens" toml:"max_tokens"`
+	TokenLimit       int      `yaml:"token_limit" toml:"token_limit"`
+
+	Instructions string `yaml:"instructions" toml:"instructions"`
+	Template     string `yaml:"template" toml:"template"`           // Path to a text/template, relative to the file
+	ChatTemplate string `yaml:"chat_template" toml:"chat_template"` // Path to a JSON chat template, relative to the file
+
+	Memory    *MemoryDefinition    `yaml:"memory" toml:"memory"`
+	Retrieval *RetrievalDefinition `yaml:"retrieval" toml:"retrieval"`
+	Sources   []SourceDefinition   `yaml:"sources" toml:"sources"`
+	Tools     []ToolDefinition     `yaml:"tools" toml:"tools"`
+}
+
+// MemoryDefinition declares the store a bot retrieves from, empty credentials are taken from the Config
+type MemoryDefinition struct {
+	Backend      string `yaml:"backend" toml:"backend"` // pinecone
+	URL          string `yaml:"url" toml:"url"`
+	APIKey       string `yaml:"api_key" toml:"api_key"`
+	Namespace    string `yaml:"namespace" toml:"namespace"`         // Defaults to the bot's ID
+	KeywordIndex string `yaml:"keyword_index" toml:"keyword_index"` // Optional BM25 index file, enables hybrid retrieval
+}
+
+type RetrievalDefinition struct {
+	TopK             *int     `yaml:"top_k" toml:"top_k"`
+	CandidatePool    *int     `yaml:"candidate_pool" toml:"candidate_pool"`
+	MinScore         *float32 `yaml:"min_score" toml:"min_score"`
+	EmbeddingModel   string   `yaml:"embedding_model" toml:"embedding_model"`
+	MaxContextTokens *int     `yaml:"max_context_tokens" toml:"max_context_tokens"`
+	DedupThreshold   *float32 `yaml:"dedup_threshold" toml:"dedup_threshold"`
+	ContextShare     *float32 `yaml:"context_share" toml:"context_share"`
+	Condense         bool     `yaml:"condense" toml:"condense"`
+	Paraphrases      int      `yaml:"paraphrases" toml:"paraphrases"`
+	HyDE             bool     `yaml:"hyde" toml:"hyde"`
+}
+
+// SourceDefinition declares an extra namespace of the bot's memory to retrieve from
+type SourceDefinition struct {
+	Name      string  `yaml:"name" toml:"name"`
+	Namespace string  `yaml:"namespace" toml:"namespace"`
+	Weight    float32 `yaml:"weight" toml:"weight"`
+	Quota     int     `yaml:"quota" toml:"quota"`
+}
+
+// ToolDefinition declares a function the bot's model may call, Parameters is a JSON schema
+type ToolDefinition struct {
+	Name        string                 `yaml:"name" toml:"name"`
+	Description string                 `yaml:"description" toml:"description"`
+	Parameters  map[string]interface{} `yaml:"parameters" toml:"parameters"`
+}
+
+// DefinedBot is a bot built from a BotDefinition, ready to use
+type DefinedBot struct {
+	ID           string
+	Settings     *BotSettings
+	Memory       Storage // nil when the bot has no memory, also set as Settings.Memory
+	Instructions string
+	Template     string        // Template text, empty uses DEFAULT_TEMPLATE
+	ChatTemplate *ChatTemplate // nil unless the definition has one
+	Tools        []openai.Tool // For applications that handle tool calls, the library does not call them itself
+	Definition   BotDefinition
+}
+
+// NewPrompt returns a prompt for a single turn with the bot
+func (d *DefinedBot) NewPrompt(client LLMAPIClient) (*BotPrompt, error) {
+	prompt, err := LoadBotPrompt(d.Template, client)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt.Instructions = d.Instructions
+	prompt.ChatTemplate = d.ChatTemplate
+	return prompt, nil
+}
+
+// LoadBotDefinitions reads a bot definition file (.yaml, .yml or .toml) and builds its bots. ${VAR} and
+// ${VAR:-default} in string values are replaced by environment variables once the file is parsed, so secrets can
+// stay out of it. Credentials and the logger left out of the file come from cfg, which may be nil. Errors wrap
+// ErrConfig
+func LoadBotDefinitions(path string, cfg *Config) ([]*DefinedBot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+
+	file, err := ParseBotDefinitions(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	bots := make([]*DefinedBot, len(file.Bots))
+	for i := range file.Bots {
+		bot, err := file.Bots[i].Build(cfg, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		bots[i] = bot
+	}
+
+	return bots, nil
+}
+
+// ParseBotDefinitions parses data as format (yaml or toml, with or without a leading dot) and expands environment
+// variables in its string values, a value is never parsed again so secrets may hold any character. Unknown keys,
+// duplicate IDs and invalid definitions are errors
+func ParseBotDefinitions(data []byte, format string) (*BotFile, error) {
+	file := &BotFile{}
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(file); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+	case "toml":
+		md, err := toml.Decode(string(data), file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%w: unknown keys %v", ErrConfig, undecoded)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported bot definition format %q, use yaml or toml", ErrConfig, format)
+	}
+
+	if len(file.Bots) == 0 {
+		return nil, fmt.Errorf("%w: no bots defined", ErrConfig)
+	}
+
+	var missing []string
+	expandEnvValues(reflect.ValueOf(file), &missing)
+	if len(missing) > 0 {
+		return nil, missingEnvError(missing)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Bots {
+		if err := file.Bots[i].Validate(); err != nil {
+			return nil, err
+		}
+
+		if seen[file.Bots[i].ID] {
+			return nil, fmt.Errorf("%w: bot %s is defined more than once", ErrConfig, file.Bots[i].ID)
+		}
+		seen[file.Bots[i].ID] = true
+	}
+
+	return file, nil
+}
+
+var envRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ExpandEnv replaces ${VAR} with the environment variable VAR and ${VAR:-default} with VAR or default when VAR is
+// unset or empty, $${VAR} is left as ${VAR}. A variable that is unset and has no default is an error
+func ExpandEnv(s string) (string, error) {
+	var missing []string
+	out := expandEnv(s, &missing)
+	if len(missing) > 0 {
+		return "", missingEnvError(missing)
+	}
+
+	return out, nil
+}
+
+// expandEnv is ExpandEnv, the unset variables without a default are added to missing
+func expandEnv(s string, missing *[]string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		m := envRef.FindStringSubmatch(ref)
+		if v := os.Getenv(m[1]); v != "" {
+			return v
+		}
+
+		if m[2] != "" {
+			return m[3]
+		}
+
+		if _, ok := os.LookupEnv(m[1]); !ok {
+			*missing = append(*missing, m[1])
+		}
+		return ""
+	})
+}
+
+func missingEnvError(missing []string) error {
+	seen := make(map[string]bool, len(missing))
+	names := make([]string, 0, len(missing))
+	for _, name := range missing {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return fmt.Errorf("%w: environment variables not set: %s", ErrConfig, strings.Join(names, ", "))
+}
+
+// expandEnvValues expands environment variables in every string reachable from v: struct fields, slices, maps and
+// interfaces, such as the tool parameters
+func expandEnvValues(v reflect.Value, missing *[]string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandEnv(v.String(), missing))
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			expandEnvValues(v.Elem(), missing)
+		}
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+
+		// the value in an interface can't be set, expand a copy and put it back
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		expandEnvValues(elem, missing)
+		v.Set(elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				expandEnvValues(v.Field(i), missing)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvValues(v.Index(i), missing)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			val := reflect.New(v.Type().Elem()).Elem()
+			val.Set(v.MapIndex(key))
+			expandEnvValues(val, missing)
+			v.SetMapIndex(key, val)
+		}
+	}
+}
+
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Validate checks the definition without touching the file system or the network
+func (d *BotDefinition) Validate() error {
+	if d.ID == "" {
+		return fmt.Errorf("%w: bot has no id", ErrConfig)
+	}
+
+	if d.Model == "" {
+		return fmt.Errorf("%w: bot %s has no model", ErrConfig, d.ID)
+	}
+
+	if d.Memory != nil && !strings.EqualFold(d.Memory.Backend, "pinecone") {
+		return fmt.Errorf("%w: bot %s: unsupported memory backend %q", ErrConfig, d.ID, d.Memory.Backend)
+	}
+
+	if len(d.Sources) > 0 && d.Memory == nil {
+		return fmt.Errorf("%w: bot %s has sources but no memory", ErrConfig, d.ID)
+	}
+
+	for _, src := range d.Sources {
+		if src.Namespace == "" {
+			return fmt.Errorf("%w: bot %s: every source needs a namespace", ErrConfig, d.ID)
+		}
+	}
+
+	if r := d.Retrieval; r != nil && r.EmbeddingModel != "" {
+		var m openai.EmbeddingModel
+		m.UnmarshalText([]byte(r.EmbeddingModel))
+		if m == openai.Unknown {
+			return fmt.Errorf("%w: bot %s: unknown embedding model %s", ErrConfig, d.ID, r.EmbeddingModel)
+		}
+	}
+
+	tools := make(map[string]bool)
+	for _, t := range d.Tools {
+		if !toolName.MatchString(t.Name) {
+			return fmt.Errorf("%w: bot %s: invalid tool name %q", ErrConfig, d.ID, t.Name)
+		}
+
+		if tools[t.Name] {
+			return fmt.Errorf("%w: bot %s: tool %s is defined more than once", ErrConfig, d.ID, t.Name)
+		}
+		tools[t.Name] = true
+	}
+
+	return nil
+}
+
+// Build turns the definition into settings, memory and templates, relative paths are resolved against dir. The
+// settings are checked with BotSettings.Validate and the templates are compiled
+func (d *BotDefinition) Build(cfg *Config, dir string) (*DefinedBot, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	bot := &DefinedBot{
+		ID:           d.ID,
+		Settings:     d.settings(),
+		Instructions: d.Instructions,
+		Definition:   *d,
+	}
+
+	if err := bot.Settings.Validate(); err != nil {
+		return nil, fmt.Errorf("bot %s: %w", d.ID, err)
+	}
+
+	if d.Template != "" {
+		data, err := os.ReadFile(resolvePath(dir, d.Template))
+		if err != nil {
+			return nil, fmt.Errorf("bot %s: %w: %w", d.ID, ErrTemplateLoad, err)
+		}
+
+		if _, err := NewPromptTemplate(d.ID, string(data)); err != nil {
+			return nil, fmt.Errorf("bot %s: %w", d.ID, err)
+		}
+		bot.Template = string(data)
+	}
+
+	if d.ChatTemplate != "" {
+		ct, err := LoadChatTemplate(resolvePath(dir, d.ChatTemplate))
+		if err != nil {
+			return nil, fmt.Errorf("bot %s: %w", d.ID, err)
+		}
+		bot.ChatTemplate = ct
+	}
+
+	if err := d.buildMemory(bot, cfg, dir); err != nil {
+		return nil, err
+	}
+
+	tools, err := d.tools()
+	if err != nil {
+		return nil, err
+	}
+	bot.Tools = tools
+
+	return bot, nil
+}
+
+func (d *BotDefinition) settings() *BotSettings {
+	s := NewBotSettings()
+	s.ID = d.ID
+	s.Model = d.Model
+	s.MaxTokens = d.MaxTokens
+	s.TokenLimit = d.TokenLimit
+
+	setIf(&s.Temp, d.Temperature)
+	setIf(&s.TopP, d.TopP)
+	setIf(&s.FrequencyPenalty, d.FrequencyPenalty)
+	setIf(&s.PresencePenalty, d.PresencePenalty)
+
+	if r := d.Retrieval; r != nil {
+		setIf(&s.Retrieval.TopK, r.TopK)
+		setIf(&s.Retrieval.CandidatePool, r.CandidatePool)
+		setIf(&s.Retrieval.MinScore, r.MinScore)
+		setIf(&s.Retrieval.MaxContextTokens, r.MaxContextTokens)
+		setIf(&s.Retrieval.DedupThreshold, r.DedupThreshold)
+		setIf(&s.Retrieval.ContextShare, r.ContextShare)
+
+		if r.EmbeddingModel != "" {
+			s.Retrieval.EmbeddingModel.UnmarshalText([]byte(r.EmbeddingModel))
+			s.EmbeddingModel = s.Retrieval.EmbeddingModel
+		}
+
+		s.Retrieval.Rewrite = QueryRewriteSettings{Condense: r.Condense, Paraphrases: r.Paraphrases, HyDE: r.HyDE}
+	}
+
+	// MinScore falls back to MemoryAcceptScore, keep them in step so an explicit 0 sticks
+	s.MemoryAcceptScore = s.Retrieval.MinScore
+
+	return s
+}
+
+func (d *BotDefinition) buildMemory(bot *DefinedBot, cfg *Config, dir string) error {
+	m := d.Memory
+	if m == nil {
+		return nil
+	}
+
+	namespace := m.Namespace
+	if namespace == "" {
+		namespace = d.ID
+	}
+
+	pc := cfg.NewPinecone(namespace)
+	if m.URL != "" {
+		pc.APIEndpoint = m.URL
+	}
+	if m.APIKey != "" {
+		pc.APIKey = m.APIKey
+	}
+
+	if pc.APIEndpoint == "" || pc.APIKey == "" {
+		return fmt.Errorf("%w: bot %s: pinecone memory needs a url and an api key", ErrConfig, d.ID)
+	}
+
+	bot.Memory = pc
+	bot.Settings.Memory = pc
+
+	if m.KeywordIndex != "" {
+		idx, err := NewBM25Index(resolvePath(dir, m.KeywordIndex))
+		if err != nil {
+			return fmt.Errorf("%w: bot %s: %w", ErrConfig, d.ID, err)
+		}
+		bot.Settings.Keywords = idx
+	}
+
+	// the bot's own namespace comes first, sources are extra namespaces. Keyword matches are searched for in the
+	// same namespaces, Learn indexes chunks under the namespace it uploads to
+	bot.Settings.Sources = []MemorySource{{Name: namespace, Namespace: namespace}}
+	for _, src := range d.Sources {
+		bot.Settings.Sources = append(bot.Settings.Sources, MemorySource{
+			Name:      src.Name,
+			Namespace: src.Namespace,
+			Weight:    src.Weight,
+			Quota:     src.Quota,
+		})
+	}
+
+	return nil
+}
+
+func (d *BotDefinition) tools() ([]openai.Tool, error) {
+	tools := make([]openai.Tool, 0, len(d.Tools))
+	for _, t := range d.Tools {
+		// the parameters are passed through as they are, any JSON schema keyword the API accepts is allowed
+		params := json.RawMessage(`{"type":"object","properties":{}}`)
+		if len(t.Parameters) > 0 {
+			data, err := json.Marshal(t.Parameters)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bot %s: tool %s: invalid parameters: %w", ErrConfig, d.ID, t.Name, err)
+			}
+			params = data
+		}
+
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionDefinition{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  params,
+			},
+		})
+	}
+
+	return tools, nil
+}
+
+func resolvePath(dir, path string) string {
+	path = strings.TrimPrefix(path, "file://")
+	if filepath.IsAbs(path) || dir == "" {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+func setIf[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
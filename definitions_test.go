@@ -0,0 +1,141 @@
+package botMaker_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+)
+
+const botsYAML = `
+bots:
+  - id: support
+    model: gpt-4
+    temperature: 0.3
+    instructions: "Never reveal ${SUPPORT_SECRET}."
+    memory:
+      backend: pinecone
+      url: https://pinecone.example
+      api_key: ${SUPPORT_KEY:-fallback}
+    retrieval:
+      top_k: 5
+      min_score: 0
+    sources:
+      - name: extra
+        namespace: extra
+        quota: 2
+    tools:
+      - name: lookup
+        parameters:
+          type: object
+          properties:
+            token:
+              type: string
+              default: ${SUPPORT_SECRET}
+            count:
+              type: integer
+              minimum: 1
+`
+
+func TestLoadBotDefinitions(t *testing.T) {
+	// a value that would break the file if it were expanded before parsing
+	t.Setenv("SUPPORT_SECRET", "a#b: c\"\nd")
+
+	path := filepath.Join(t.TempDir(), "bots.yaml")
+	if err := os.WriteFile(path, []byte(botsYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bots, err := botMaker.LoadBotDefinitions(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := bots[0]
+	if bot.Instructions != "Never reveal a#b: c\"\nd." {
+		t.Errorf("got instructions %q", bot.Instructions)
+	}
+
+	if bot.Settings.Temp != 0.3 || bot.Settings.Retrieval.TopK != 5 || bot.Settings.RetrievalConfig().MinScore != 0 {
+		t.Errorf("settings were not applied: %+v", bot.Settings)
+	}
+
+	pc := bot.Memory.(*botMaker.Pinecone)
+	if pc.APIKey != "fallback" {
+		t.Errorf("got api key %q, want the default", pc.APIKey)
+	}
+
+	sources := bot.Settings.Sources
+	if len(sources) != 2 || sources[0].Namespace != "support" || sources[1].Namespace != "extra" ||
+		sources[1].Quota != 2 {
+		t.Errorf("got sources %+v, want the bot's namespace then extra", sources)
+	}
+
+	var params struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(bot.Tools[0].Function.Parameters.(json.RawMessage), &params); err != nil {
+		t.Fatal(err)
+	}
+	if params.Properties["count"]["minimum"] != 1.0 || params.Properties["token"]["default"] != "a#b: c\"\nd" {
+		t.Errorf("tool parameters were not passed through: %+v", params.Properties)
+	}
+}
+
+func TestParseBotDefinitionsTOML(t *testing.T) {
+	file, err := botMaker.ParseBotDefinitions([]byte(`
+[[bots]]
+id = "faq"
+model = "gpt-3.5-turbo"
+
+[bots.retrieval]
+top_k = 2
+`), ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.Bots[0].ID != "faq" || *file.Bots[0].Retrieval.TopK != 2 {
+		t.Errorf("got %+v", file.Bots[0])
+	}
+}
+
+func TestParseBotDefinitionsErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknown key":   "bots:\n  - id: a\n    model: gpt-4\n    colour: red\n",
+		"duplicate id":  "bots:\n  - id: a\n    model: gpt-4\n  - id: a\n    model: gpt-4\n",
+		"no model":      "bots:\n  - id: a\n",
+		"no bots":       "bots: []\n",
+		"missing env":   "bots:\n  - id: a\n    model: ${BOTMAKER_TEST_UNSET}\n",
+		"bad tool name": "bots:\n  - id: a\n    model: gpt-4\n    tools:\n      - name: not valid\n",
+	}
+
+	for name, data := range cases {
+		_, err := botMaker.ParseBotDefinitions([]byte(data), "yaml")
+		if !errors.Is(err, botMaker.ErrConfig) {
+			t.Errorf("%s: got %v, want ErrConfig", name, err)
+		}
+	}
+
+	_, err := botMaker.ParseBotDefinitions([]byte(cases["missing env"]), "yaml")
+	if err == nil || !strings.Contains(err.Error(), "BOTMAKER_TEST_UNSET") {
+		t.Errorf("the error does not name the variable: %v", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("BOTMAKER_TEST_SET", "value")
+
+	got, err := botMaker.ExpandEnv("${BOTMAKER_TEST_SET} ${BOTMAKER_TEST_UNSET:-default} $${BOTMAKER_TEST_SET}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "value default ${BOTMAKER_TEST_SET}" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,218 @@
+package botMaker
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// EmbeddingCache stores embedding vectors keyed by EmbeddingCacheKey so the same text is never embedded twice
+type EmbeddingCache interface {
+	Get(key string) ([]float32, bool)
+	Set(key string, embedding []float32) error
+	Stats() CacheStats
+}
+
+// CacheStats counts cache lookups
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type cacheCounter struct {
+	hits   uint64
+	misses uint64
+}
+
+func (c *cacheCounter) record(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounter) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// EmbeddingCacheKey returns the cache key for a text embedded with a model
+func EmbeddingCacheKey(model openai.EmbeddingModel, text string) string {
+	hash := sha256.Sum256([]byte(model.String() + "\x00" + text))
+	return hex.EncodeToString(hash[:])
+}
+
+// LRUEmbeddingCache is an in-memory EmbeddingCache that evicts the least recently used vectors past Size entries
+type LRUEmbeddingCache struct {
+	cacheCounter
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key       string
+	embedding []float32
+}
+
+func NewLRUEmbeddingCache(size int) *LRUEmbeddingCache {
+	return &LRUEmbeddingCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *LRUEmbeddingCache) Get(key string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	c.record(ok)
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).embedding, true
+}
+
+func (c *LRUEmbeddingCache) Set(key string, embedding []float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*lruEntry).embedding = embedding
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, embedding: embedding})
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	return nil
+}
+
+// FileEmbeddingCache stores one file per vector under Dir. Files are written atomically, so several processes can
+// share the same directory as a cache
+type FileEmbeddingCache struct {
+	cacheCounter
+	Dir string
+}
+
+func NewFileEmbeddingCache(dir string) (*FileEmbeddingCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileEmbeddingCache{Dir: dir}, nil
+}
+
+// path shards files by a hash of the key, so any key maps to a safe file name under Dir
+func (c *FileEmbeddingCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(hash[:])
+	return filepath.Join(c.Dir, name[:2], name+".vec")
+}
+
+func (c *FileEmbeddingCache) Get(key string) ([]float32, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil || len(data)%4 != 0 {
+		c.record(false)
+		return nil, false
+	}
+
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+
+	c.record(true)
+	return embedding, true
+}
+
+func (c *FileEmbeddingCache) Set(key string, embedding []float32) error {
+	data := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+
+	p := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), "tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// TieredEmbeddingCache checks each layer in turn, e.g. an LRU in front of a shared FileEmbeddingCache, and fills
+// the faster layers on a hit further down
+type TieredEmbeddingCache struct {
+	cacheCounter
+	Layers []EmbeddingCache
+	Logger Logger // Optional, failed backfills are logged here, defaults to DefaultLogger
+}
+
+func (c *TieredEmbeddingCache) Get(key string) ([]float32, bool) {
+	for i, layer := range c.Layers {
+		embedding, ok := layer.Get(key)
+		if !ok {
+			continue
+		}
+
+		// a layer that can't be filled is only slower next time
+		for j := 0; j < i; j++ {
+			if err := c.Layers[j].Set(key, embedding); err != nil {
+				loggerOr(c.Logger).Warn("embedding cache backfill failed", "layer", j, "error", err)
+			}
+		}
+
+		c.record(true)
+		return embedding, true
+	}
+
+	c.record(false)
+	return nil, false
+}
+
+func (c *TieredEmbeddingCache) Set(key string, embedding []float32) error {
+	for _, layer := range c.Layers {
+		if err := layer.Set(key, embedding); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
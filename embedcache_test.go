@@ -0,0 +1,144 @@
+package botMaker_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestLRUEmbeddingCacheEvicts(t *testing.T) {
+	cache := botMaker.NewLRUEmbeddingCache(2)
+	cache.Set("a", []float32{1})
+	cache.Set("b", []float32{2})
+	cache.Get("a")
+	cache.Set("c", []float32{3})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestFileEmbeddingCache(t *testing.T) {
+	dir := t.TempDir()
+	key := botMaker.EmbeddingCacheKey(openai.AdaEmbeddingV2, "hello")
+
+	cache, err := botMaker.NewFileEmbeddingCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(key, []float32{0.25, -1, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second cache on the same directory, as another process would have
+	other, _ := botMaker.NewFileEmbeddingCache(dir)
+	got, ok := other.Get(key)
+	if !ok || !reflect.DeepEqual(got, []float32{0.25, -1, 3}) {
+		t.Errorf("got %v, %v", got, ok)
+	}
+
+	if _, ok := other.Get(botMaker.EmbeddingCacheKey(openai.AdaEmbeddingV2, "other")); ok {
+		t.Error("found a key that was never set")
+	}
+
+	// keys are not trusted as file names
+	for _, key := range []string{"", "a", "../../escape"} {
+		if err := cache.Set(key, []float32{1}); err != nil {
+			t.Fatalf("%q: %v", key, err)
+		}
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%q was not cached", key)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "..", "escape.vec")); !os.IsNotExist(err) {
+		t.Errorf("a key escaped the cache directory: %v", err)
+	}
+}
+
+type failingCache struct {
+	*botMaker.LRUEmbeddingCache
+}
+
+func (failingCache) Set(string, []float32) error {
+	return errors.New("disk full")
+}
+
+type recordingLogger struct {
+	warnings []string
+}
+
+func (l *recordingLogger) Debug(string, ...any)      {}
+func (l *recordingLogger) Info(string, ...any)       {}
+func (l *recordingLogger) Error(string, ...any)      {}
+func (l *recordingLogger) Warn(msg string, _ ...any) { l.warnings = append(l.warnings, msg) }
+
+func TestTieredEmbeddingCacheLogsFailedBackfills(t *testing.T) {
+	lower := botMaker.NewLRUEmbeddingCache(10)
+	lower.Set("key", []float32{1})
+	logger := &recordingLogger{}
+	upper := failingCache{botMaker.NewLRUEmbeddingCache(1)}
+	cache := &botMaker.TieredEmbeddingCache{Layers: []botMaker.EmbeddingCache{upper, lower}, Logger: logger}
+
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("missed a key in the lower layer")
+	}
+
+	if len(logger.warnings) != 1 {
+		t.Errorf("got warnings %q", logger.warnings)
+	}
+}
+
+func TestTieredEmbeddingCacheFillsUpperLayers(t *testing.T) {
+	lru := botMaker.NewLRUEmbeddingCache(10)
+	files, _ := botMaker.NewFileEmbeddingCache(t.TempDir())
+	files.Set("key", []float32{1, 2})
+	cache := &botMaker.TieredEmbeddingCache{Layers: []botMaker.EmbeddingCache{lru, files}}
+
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("missed a key in the lower layer")
+	}
+
+	if got, ok := lru.Get("key"); !ok || !reflect.DeepEqual(got, []float32{1, 2}) {
+		t.Errorf("the upper layer was not filled: %v", got)
+	}
+}
+
+func TestGetEmbeddingsForDataUsesTheCache(t *testing.T) {
+	srv := botmakertest.NewServer(botmakertest.NewClient(), nil)
+	defer srv.Close()
+
+	cl := srv.NewOAIClient()
+	cl.EmbeddingCache = botMaker.NewLRUEmbeddingCache(10)
+	chunks := []botMaker.Chunk{{Text: "one"}, {Text: "two"}}
+
+	first, err := cl.GetEmbeddingsForData(chunks, 10, openai.AdaEmbeddingV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := cl.GetEmbeddingsForData(chunks, 10, openai.AdaEmbeddingV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(first, second) || len(srv.Requests()) != 1 {
+		t.Errorf("got %d requests, want the second batch from the cache", len(srv.Requests()))
+	}
+
+	if stats := cl.EmbeddingCacheStats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("got %+v", stats)
+	}
+}
@@ -0,0 +1,53 @@
+package botMaker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned (wrapped) by the library, check them with errors.Is
+var (
+	ErrTemplateLoad = errors.New("failed to load template")
+	ErrConfig       = errors.New("invalid configuration")
+	ErrTokenLimit   = errors.New("token limit exceeded")
+	ErrStorage      = errors.New("storage error")
+)
+
+// TokenLimitError is returned when a prompt does not fit the model, it matches ErrTokenLimit
+type TokenLimitError struct {
+	Model  string
+	Tokens int // Tokens the prompt uses, 0 when it was not counted
+	Limit  int
+}
+
+func (e *TokenLimitError) Error() string {
+	if e.Tokens == 0 {
+		return fmt.Sprintf("prompt is longer than the %d token limit of %s, please shorten your prompt", e.Limit, e.Model)
+	}
+
+	return fmt.Sprintf("prompt uses %d tokens, more than the %d token limit of %s, please shorten your prompt",
+		e.Tokens, e.Limit, e.Model)
+}
+
+func (e *TokenLimitError) Is(target error) bool {
+	return target == ErrTokenLimit
+}
+
+// StorageError is returned when a memory store fails, it matches ErrStorage and unwraps to the cause
+type StorageError struct {
+	Op        string // upsert, query, ...
+	Namespace string
+	Err       error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("storage %s in namespace %q failed: %v", e.Op, e.Namespace, e.Err)
+}
+
+func (e *StorageError) Is(target error) bool {
+	return target == ErrStorage
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
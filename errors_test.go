@@ -0,0 +1,68 @@
+package botMaker_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func TestLoadConfigFromEnvRequiresAnAPIKey(t *testing.T) {
+	t.Setenv("LLM_API_KEY", "")
+	os.Unsetenv("LLM_API_KEY")
+
+	if _, err := botMaker.LoadConfigFromEnv(); !errors.Is(err, botMaker.ErrConfig) {
+		t.Errorf("got %v, want ErrConfig", err)
+	}
+}
+
+func TestLoadBotPromptFromAMissingFile(t *testing.T) {
+	_, err := botMaker.LoadBotPrompt("file://"+filepath.Join(t.TempDir(), "missing.tmpl"), botmakertest.NewClient())
+	if !errors.Is(err, botMaker.ErrTemplateLoad) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want ErrTemplateLoad wrapping os.ErrNotExist", err)
+	}
+}
+
+func TestTokenLimitError(t *testing.T) {
+	settings := newSettings()
+	settings.TokenLimit = 10
+
+	prompt, _ := botMaker.LoadBotPrompt("", botmakertest.NewClient("unused"))
+	prompt.Body = strings.Repeat("far too long ", 10)
+
+	_, _, err := prompt.OAIClient.CallCompletionAPI(settings, prompt)
+	var limitErr *botMaker.TokenLimitError
+	if !errors.Is(err, botMaker.ErrTokenLimit) || !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want a TokenLimitError", err)
+	}
+
+	if limitErr.Model != settings.Model || limitErr.Limit != 10 {
+		t.Errorf("got %+v", limitErr)
+	}
+}
+
+func TestStorageError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "index not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	pc := &botMaker.Pinecone{APIEndpoint: srv.URL, UUID: "capitals", Logger: botMaker.NopLogger{}}
+	_, err := pc.Retrieve([]float32{1}, 1, "capitals")
+
+	var storageErr *botMaker.StorageError
+	if !errors.Is(err, botMaker.ErrStorage) || !errors.As(err, &storageErr) {
+		t.Fatalf("got %v, want a StorageError", err)
+	}
+
+	if storageErr.Op != "query" || storageErr.Namespace != "capitals" ||
+		!strings.Contains(storageErr.Err.Error(), "index not found") {
+		t.Errorf("got %+v", storageErr)
+	}
+}
@@ -0,0 +1,330 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+)
+
+// Criteria answers are graded on
+const (
+	Faithfulness = "faithfulness" // Claims are backed by the retrieved contexts
+	Relevance    = "relevance"    // The answer addresses the question
+	Correctness  = "correctness"  // The answer agrees with the reference answer, skipped without one
+)
+
+var FAITHFULNESS_PROMPT = `You are grading an answer given by an assistant that must only use the provided context.
+List every claim in the answer that the context does not support, then score how faithful the answer is to the
+context from 0 (mostly unsupported) to 1 (fully supported). An answer that says it does not know is faithful.
+
+Context:
+{{ range $i, $ctx := .Contexts }}[{{$i}}] {{$ctx}}
+{{ else }}(no context was retrieved)
+{{ end }}
+Question: {{.Question}}
+
+Answer: {{.Answer}}`
+
+var RELEVANCE_PROMPT = `You are grading an answer given by an assistant. Score how well the answer addresses the
+question from 0 (off topic or evasive) to 1 (directly and completely answers it). Do not judge whether it is true.
+
+Question: {{.Question}}
+
+Answer: {{.Answer}}`
+
+var CORRECTNESS_PROMPT = `You are grading an answer given by an assistant against a reference answer. Score how well
+the answer agrees with the reference from 0 (contradicts it or misses the point) to 1 (same facts, wording may
+differ). Extra detail that does not contradict the reference is fine.
+
+Question: {{.Question}}
+
+Reference answer: {{.Reference}}
+
+Answer: {{.Answer}}`
+
+// JudgeInput is the data judge prompts are rendered with
+type JudgeInput struct {
+	Question  string
+	Answer    string
+	Reference string
+	Contexts  []string
+}
+
+// Grade is a judge's verdict on one criterion
+type Grade struct {
+	Score             float64  `json:"score" description:"from 0 to 1"`
+	Reason            string   `json:"reason" description:"one or two sentences"`
+	UnsupportedClaims []string `json:"unsupported_claims,omitempty" description:"claims the context does not support"`
+}
+
+// AnswerConfig is a bot under evaluation and the judge that grades it
+type AnswerConfig struct {
+	Name     string
+	Settings *botMaker.BotSettings
+	// NewPrompt returns the bot's prompt for a question, e.g. DefinedBot.NewPrompt, defaults to DEFAULT_TEMPLATE
+	NewPrompt func(botMaker.LLMAPIClient) (*botMaker.BotPrompt, error)
+
+	Judge       *botMaker.BotSettings // Model and sampling for the judge, a strong model at a low temperature
+	JudgeClient botMaker.LLMAPIClient // Optional, defaults to the bot's client
+	Prompts     map[string]string     // Judge prompt per criterion, text/templates of JudgeInput
+	Criteria    []string              // Defaults to faithfulness, relevance and correctness
+	PassScore   float64               // Lowest score that passes a criterion, defaults to 0.7
+	Retries     int                   // Retries when the judge's reply is not a valid grade
+	Params      map[string]string     // Optional labels reported with the results
+}
+
+// AnswerResult is the evaluation of the answer to a single question
+type AnswerResult struct {
+	ID            string           `json:"id"`
+	Question      string           `json:"question"`
+	Answer        string           `json:"answer"`
+	Reference     string           `json:"reference,omitempty"`
+	Contexts      []string         `json:"contexts"`
+	Grades        map[string]Grade `json:"grades"`
+	Hallucination bool             `json:"hallucination"` // The judge found claims the contexts do not support
+	Passed        bool             `json:"passed"`
+	Tokens        int              `json:"tokens"`
+	Latency       time.Duration    `json:"latency_ns"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// AnswerReport is the evaluation of a bot's answers to a dataset
+type AnswerReport struct {
+	Dataset        string             `json:"dataset"`
+	Config         string             `json:"config"`
+	Params         map[string]string  `json:"params"`
+	Started        time.Time          `json:"started"`
+	Duration       time.Duration      `json:"duration_ns"`
+	PassScore      float64            `json:"pass_score"`
+	Questions      int                `json:"questions"`
+	Passed         int                `json:"passed"`
+	Hallucinations int                `json:"hallucinations"`
+	Errors         int                `json:"errors"`
+	Scores         map[string]float64 `json:"scores"` // Mean score per criterion
+	Results        []AnswerResult     `json:"results"`
+}
+
+// RunAnswers asks the bot every question of the dataset and has the judge grade the answers. Failing to answer or
+// grade a question is recorded in its result, which then does not pass
+func RunAnswers(client botMaker.LLMAPIClient, ds *Dataset, cfg AnswerConfig) (*AnswerReport, error) {
+	if cfg.Settings == nil || cfg.Judge == nil {
+		return nil, fmt.Errorf("config %s needs the bot's and the judge's settings", cfg.Name)
+	}
+
+	// answers are graded without relevance labels, only the questions need checking
+	if err := ds.validateQuestions(); err != nil {
+		return nil, err
+	}
+
+	if cfg.JudgeClient == nil {
+		cfg.JudgeClient = client
+	}
+
+	if cfg.PassScore == 0 {
+		cfg.PassScore = 0.7
+	}
+
+	if len(cfg.Criteria) == 0 {
+		cfg.Criteria = []string{Faithfulness, Relevance, Correctness}
+	}
+
+	prompts, err := judgePrompts(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AnswerReport{
+		Dataset:   ds.Name,
+		Config:    cfg.Name,
+		Params:    answerParams(cfg),
+		Started:   time.Now(),
+		PassScore: cfg.PassScore,
+		Results:   make([]AnswerResult, len(ds.Questions)),
+	}
+
+	for i, q := range ds.Questions {
+		report.Results[i] = answer(client, cfg, prompts, q)
+	}
+
+	report.Duration = time.Since(report.Started)
+	report.summarize()
+	return report, nil
+}
+
+func judgePrompts(cfg AnswerConfig) (map[string]*template.Template, error) {
+	defaults := map[string]string{
+		Faithfulness: FAITHFULNESS_PROMPT,
+		Relevance:    RELEVANCE_PROMPT,
+		Correctness:  CORRECTNESS_PROMPT,
+	}
+
+	prompts := make(map[string]*template.Template, len(cfg.Criteria))
+	for _, criterion := range cfg.Criteria {
+		text, ok := cfg.Prompts[criterion]
+		if !ok {
+			text, ok = defaults[criterion]
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("criterion %s has no judge prompt", criterion)
+		}
+
+		tpl, err := template.New(criterion).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("judge prompt for %s: %w", criterion, err)
+		}
+		prompts[criterion] = tpl
+	}
+
+	return prompts, nil
+}
+
+func answer(client botMaker.LLMAPIClient, cfg AnswerConfig, prompts map[string]*template.Template,
+	q Question) AnswerResult {
+	result := AnswerResult{ID: q.ID, Question: q.Question, Reference: q.Answer, Grades: make(map[string]Grade)}
+
+	var prompt *botMaker.BotPrompt
+	var err error
+	if cfg.NewPrompt != nil {
+		prompt, err = cfg.NewPrompt(client)
+	} else {
+		prompt, err = botMaker.LoadBotPrompt("", client)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	prompt.Body = q.Question
+
+	// a cached answer skips retrieval and would be graded against no contexts at all
+	started := time.Now()
+	result.Answer, result.Tokens, err = client.CallCompletionAPI(cfg.Settings.WithoutResponseCache(), prompt)
+	result.Latency = time.Since(started)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Contexts = prompt.ContextToRender
+
+	input := JudgeInput{Question: q.Question, Answer: result.Answer, Reference: q.Answer, Contexts: prompt.ContextToRender}
+	result.Passed = true
+	for _, criterion := range cfg.Criteria {
+		if criterion == Correctness && q.Answer == "" {
+			continue
+		}
+
+		grade, err := gradeAnswer(cfg, prompts[criterion], input)
+		if err != nil {
+			result.Error = fmt.Sprintf("%s: %v", criterion, err)
+			result.Passed = false
+			return result
+		}
+
+		result.Grades[criterion] = grade
+		if grade.Score < cfg.PassScore {
+			result.Passed = false
+		}
+
+		if criterion == Faithfulness && len(grade.UnsupportedClaims) > 0 {
+			result.Hallucination = true
+			result.Passed = false
+		}
+	}
+
+	return result
+}
+
+// gradeAnswer asks the judge model to grade an answer on one criterion
+func gradeAnswer(cfg AnswerConfig, tpl *template.Template, input JudgeInput) (Grade, error) {
+	var body bytes.Buffer
+	if err := tpl.Execute(&body, input); err != nil {
+		return Grade{}, err
+	}
+
+	prompt, err := botMaker.LoadBotPrompt("", cfg.JudgeClient)
+	if err != nil {
+		return Grade{}, err
+	}
+	prompt.Instructions = "You are a strict and impartial grader."
+	prompt.Body = strings.TrimSpace(body.String())
+
+	// the judge must not retrieve, rewrite or rerank of its own
+	var grade Grade
+	if err := botMaker.GetStructured(cfg.JudgeClient, cfg.Judge.HelperSettings(), prompt, &grade, nil, cfg.Retries); err != nil {
+		return Grade{}, err
+	}
+
+	if grade.Score < 0 || grade.Score > 1 {
+		return Grade{}, fmt.Errorf("score %v is not between 0 and 1", grade.Score)
+	}
+
+	return grade, nil
+}
+
+func (r *AnswerReport) summarize() {
+	r.Questions = len(r.Results)
+	r.Passed, r.Hallucinations, r.Errors = 0, 0, 0
+	r.Scores = make(map[string]float64)
+
+	counts := make(map[string]int)
+	for _, res := range r.Results {
+		if res.Passed {
+			r.Passed++
+		}
+		if res.Hallucination {
+			r.Hallucinations++
+		}
+		if res.Error != "" {
+			r.Errors++
+		}
+
+		for criterion, g := range res.Grades {
+			r.Scores[criterion] += g.Score
+			counts[criterion]++
+		}
+	}
+
+	for criterion, n := range counts {
+		r.Scores[criterion] /= float64(n)
+	}
+}
+
+func answerParams(cfg AnswerConfig) map[string]string {
+	p := map[string]string{
+		"model":       cfg.Settings.Model,
+		"judge_model": cfg.Judge.Model,
+		"temperature": fmt.Sprint(cfg.Settings.Temp),
+		"top_k":       fmt.Sprint(cfg.Settings.RetrievalConfig().TopK),
+	}
+
+	for k, v := range cfg.Params {
+		p[k] = v
+	}
+
+	return p
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *AnswerReport) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// SaveJSON writes the report to a JSON file
+func (r *AnswerReport) SaveJSON(path string) error {
+	return saveJSON(path, r)
+}
+
+// LoadAnswerReport reads a report written by SaveJSON, e.g. a baseline to check for regressions
+func LoadAnswerReport(path string) (*AnswerReport, error) {
+	r := &AnswerReport{}
+	if err := loadJSON(path, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,333 @@
+// Package eval measures how well a bot retrieves contexts. A Dataset lists the documents to learn and questions
+// labelled with the documents (or chunks) that answer them, Run learns the documents with a configuration, asks
+// every question through botMaker.GetContexts and reports recall@k, MRR and nDCG. Reports from different
+// configurations (chunk sizes, splitters, retrieval settings) can be compared side by side and saved as JSON.
+//
+// RunAnswers evaluates what the bot answers: a judge model grades every answer for faithfulness to the retrieved
+// contexts, relevance to the question and correctness against the reference answer, and flags claims the contexts
+// don't support. CheckRegressions compares an answer report with a saved baseline so that CI can fail on regressions.
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+)
+
+// Question is a question and the documents or chunks that answer it
+type Question struct {
+	ID        string   `json:"id,omitempty"`
+	Question  string   `json:"question"`
+	Documents []string `json:"documents,omitempty"` // Titles of relevant documents, file names for files and URLs for pages
+	Chunks    []string `json:"chunks,omitempty"`    // IDs of relevant chunks, these change with the chunking settings
+	Answer    string   `json:"answer,omitempty"`    // Reference answer, not used by retrieval metrics
+}
+
+// Dataset is a corpus to learn and the questions to ask of it
+type Dataset struct {
+	Name      string     `json:"name"`
+	Documents []string   `json:"documents"` // Files, directories or URLs to learn, relative to the dataset file
+	Questions []Question `json:"questions"`
+}
+
+// LoadDataset reads a JSON dataset, document paths are resolved against the dataset's directory
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &Dataset{}
+	if err := json.Unmarshal(data, ds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if ds.Name == "" {
+		ds.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	dir := filepath.Dir(path)
+	for i, doc := range ds.Documents {
+		if !isURL(doc) && !filepath.IsAbs(doc) {
+			ds.Documents[i] = filepath.Join(dir, doc)
+		}
+	}
+
+	return ds, ds.Validate()
+}
+
+// Validate checks that every question has a text and at least one relevant document or chunk
+func (d *Dataset) Validate() error {
+	if err := d.validateQuestions(); err != nil {
+		return err
+	}
+
+	for _, q := range d.Questions {
+		if len(q.Documents) == 0 && len(q.Chunks) == 0 {
+			return fmt.Errorf("question %s has no relevant documents or chunks", q.ID)
+		}
+	}
+
+	return nil
+}
+
+// validateQuestions checks that every question has a text and assigns missing IDs
+func (d *Dataset) validateQuestions() error {
+	if len(d.Questions) == 0 {
+		return fmt.Errorf("dataset %s has no questions", d.Name)
+	}
+
+	for i := range d.Questions {
+		q := &d.Questions[i]
+		if q.ID == "" {
+			q.ID = fmt.Sprintf("q%d", i+1)
+		}
+
+		if q.Question == "" {
+			return fmt.Errorf("question %s has no text", q.ID)
+		}
+	}
+
+	return nil
+}
+
+// Config is a configuration under evaluation. Give every configuration its own namespace (Settings.ID and
+// Learn's memory) so that their chunks don't mix
+type Config struct {
+	Name     string
+	Settings *botMaker.BotSettings
+	Learn    *botMaker.Learn   // Optional, the dataset's documents are learned with it first
+	Ks       []int             // Cut-offs for recall@k and nDCG@k, defaults to 1, 3, 5 and 10
+	Params   map[string]string // Optional labels reported with the results, e.g. the splitter used
+}
+
+var DefaultKs = []int{1, 3, 5, 10}
+
+// Run learns the dataset with cfg.Learn, if set, and evaluates retrieval for every question. Failing to learn a
+// document stops the run, failing to retrieve for a question is recorded in its result
+func Run(client botMaker.LLMAPIClient, ds *Dataset, cfg Config) (*Report, error) {
+	if cfg.Settings == nil {
+		return nil, fmt.Errorf("config %s has no settings", cfg.Name)
+	}
+
+	if err := ds.Validate(); err != nil {
+		return nil, err
+	}
+
+	ks := cfg.Ks
+	if len(ks) == 0 {
+		ks = DefaultKs
+	}
+
+	// retrieve deep enough for the largest cut-off
+	settings := *cfg.Settings
+	settings.Retrieval = settings.RetrievalConfig()
+	if maxK := maxInt(ks); settings.Retrieval.TopK < maxK {
+		settings.Retrieval.TopK = maxK
+	}
+
+	report := &Report{
+		Dataset: ds.Name,
+		Config:  cfg.Name,
+		Params:  params(&settings, cfg),
+		Ks:      ks,
+		Started: time.Now(),
+	}
+
+	if cfg.Learn != nil {
+		started := time.Now()
+		for _, doc := range ds.Documents {
+			n, err := learnDocument(cfg.Learn, doc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to learn %s: %w", doc, err)
+			}
+			report.Embeddings += n
+		}
+		report.LearnDuration = time.Since(started)
+	}
+
+	started := time.Now()
+	report.Results = make([]QuestionResult, len(ds.Questions))
+	for i, q := range ds.Questions {
+		report.Results[i] = evaluate(client, &settings, q, ks)
+	}
+	report.RetrieveDuration = time.Since(started)
+
+	report.summarize()
+	return report, nil
+}
+
+func learnDocument(l *botMaker.Learn, doc string) (int, error) {
+	if isURL(doc) {
+		return l.FromURL(doc)
+	}
+
+	info, err := os.Stat(doc)
+	if err != nil {
+		return 0, err
+	}
+
+	if !info.IsDir() {
+		return l.FromFile(doc)
+	}
+
+	total := 0
+	err = filepath.Walk(doc, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if _, ok := l.ExtensionSupported(path); !ok {
+			return nil
+		}
+
+		n, err := l.FromFile(path)
+		total += n
+		return err
+	})
+
+	return total, err
+}
+
+func evaluate(client botMaker.LLMAPIClient, settings *botMaker.BotSettings, q Question, ks []int) QuestionResult {
+	result := QuestionResult{ID: q.ID, Question: q.Question, Relevant: len(q.Documents) + len(q.Chunks)}
+
+	prompt, err := botMaker.LoadBotPrompt("", client)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	prompt.Body = q.Question
+
+	started := time.Now()
+	_, err = botMaker.GetContexts(prompt, settings, settings.Memory, client)
+	result.Latency = time.Since(started)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Retrieved = candidates(prompt)
+	result.Metrics = rank(judge(q, result.Retrieved), result.Relevant, ks)
+
+	packed := make([]Retrieved, 0, len(prompt.IncludedContexts))
+	for _, r := range result.Retrieved {
+		if r.Dropped == "" {
+			packed = append(packed, r)
+		}
+	}
+	result.PackedMetrics = rank(judge(q, packed), result.Relevant, ks)
+
+	return result
+}
+
+// candidates returns the contexts retrieved for the prompt before packing, the packed ones and those PackContexts
+// dropped in score order. Matches below MinScore were never candidates
+func candidates(prompt *botMaker.BotPrompt) []Retrieved {
+	retrieved := make([]Retrieved, 0, len(prompt.IncludedContexts)+len(prompt.DroppedContexts))
+	for _, c := range prompt.IncludedContexts {
+		retrieved = append(retrieved, Retrieved{ID: c.ID, Title: c.Title, Source: c.Source, Score: c.Score})
+	}
+
+	for _, c := range prompt.DroppedContexts {
+		if c.Reason == botMaker.DroppedBelowMinScore {
+			continue
+		}
+
+		retrieved = append(retrieved, Retrieved{ID: c.ID, Title: c.Title, Source: c.Source, Score: c.Score,
+			Dropped: c.Reason})
+	}
+
+	sort.SliceStable(retrieved, func(i, j int) bool { return retrieved[i].Score > retrieved[j].Score })
+	return retrieved
+}
+
+// judge marks which retrieved contexts are relevant. A document or chunk only counts once, so five chunks of the
+// same relevant document don't make a perfect recall of five relevant documents
+func judge(q Question, retrieved []Retrieved) []bool {
+	found := make(map[string]bool)
+	gains := make([]bool, len(retrieved))
+
+	for i := range retrieved {
+		r := &retrieved[i]
+		key := ""
+		for _, id := range q.Chunks {
+			if r.ID == id {
+				key = "chunk:" + id
+			}
+		}
+
+		for _, doc := range q.Documents {
+			if sameDocument(r.Title, doc) {
+				key = "doc:" + doc
+			}
+		}
+
+		r.Relevant = key != ""
+		if r.Relevant && !found[key] {
+			found[key] = true
+			gains[i] = true
+		}
+	}
+
+	return gains
+}
+
+// sameDocument matches a context title with a labelled document, files are learned under their base name
+func sameDocument(title, doc string) bool {
+	if title == doc {
+		return true
+	}
+
+	return !isURL(doc) && title == filepath.Base(doc)
+}
+
+// params describes the configuration, settings are the ones retrieval runs with
+func params(settings *botMaker.BotSettings, cfg Config) map[string]string {
+	r := settings.RetrievalConfig()
+	p := map[string]string{
+		"model":           settings.Model,
+		"embedding_model": r.EmbeddingModel.String(),
+		"top_k":           fmt.Sprint(r.TopK),
+		"candidate_pool":  fmt.Sprint(r.CandidatePool),
+		"min_score":       fmt.Sprint(r.MinScore),
+		"reranker":        fmt.Sprint(settings.Reranker != nil),
+		"hybrid":          fmt.Sprint(settings.Keywords != nil),
+	}
+
+	if cfg.Learn != nil {
+		p["chunk_size"] = fmt.Sprint(cfg.Learn.ChunkSize)
+		p["overlap"] = fmt.Sprint(cfg.Learn.Overlap)
+		p["splitter"] = "sentences"
+		if cfg.Learn.ContentSplitter != nil {
+			p["splitter"] = "custom"
+		}
+	}
+
+	for k, v := range cfg.Params {
+		p[k] = v
+	}
+
+	return p
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func maxInt(values []int) int {
+	m := 0
+	for _, v := range values {
+		if v > m {
+			m = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,210 @@
+package eval_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/lonelycode/botMaker/eval"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMain(m *testing.M) {
+	botmakertest.UseOfflineTokenizer()
+	os.Exit(m.Run())
+}
+
+// capitals returns a dataset of a document per country, with a question about Germany
+func capitals(t *testing.T) *eval.Dataset {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"germany.txt": "Berlin is the capital of Germany.",
+		"france.txt":  "Paris is the capital of France.",
+		"italy.txt":   "Rome is the capital of Italy.",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &eval.Dataset{
+		Name:      "capitals",
+		Documents: []string{dir},
+		Questions: []eval.Question{{ID: "germany", Question: "What is the capital of Germany?",
+			Documents: []string{"germany.txt"}, Answer: "Berlin"}},
+	}
+}
+
+// evalConfig returns a config that learns into an in-memory store
+func evalConfig(client botMaker.LLMAPIClient) eval.Config {
+	store := botmakertest.NewStorage("capitals")
+
+	settings := botMaker.NewBotSettings()
+	settings.ID = "capitals"
+	settings.Model = openai.GPT3Dot5Turbo
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+
+	return eval.Config{
+		Name:     "capitals",
+		Settings: settings,
+		Learn: &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store, Client: client,
+			Logger: botMaker.NopLogger{}},
+		Ks: []int{1, 3},
+	}
+}
+
+func TestRun(t *testing.T) {
+	client := botmakertest.NewClient()
+	report, err := eval.Run(client, capitals(t), evalConfig(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Embeddings != 3 || report.Questions != 1 || report.Errors != 0 {
+		t.Fatalf("got %+v", report)
+	}
+
+	if report.Metrics.RecallAtK[3] != 1 || report.Metrics.MRR == 0 {
+		t.Errorf("got metrics %+v", report.Metrics)
+	}
+
+	if report.PackedMetrics.RecallAtK[3] != report.Metrics.RecallAtK[3] {
+		t.Errorf("got packed metrics %+v when every candidate was packed", report.PackedMetrics)
+	}
+}
+
+func TestRunRanksCandidatesBeforePacking(t *testing.T) {
+	client := botmakertest.NewClient()
+	cfg := evalConfig(client)
+	// no context fits, so nothing reaches the prompt
+	cfg.Settings.Retrieval.MaxContextTokens = 5
+
+	report, err := eval.Run(client, capitals(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := report.Results[0]
+	if len(res.Retrieved) == 0 {
+		t.Fatal("no candidates were reported")
+	}
+
+	for i, r := range res.Retrieved {
+		if r.Dropped != botMaker.DroppedTokenBudget {
+			t.Errorf("candidate %d: dropped %q", i, r.Dropped)
+		}
+		if i > 0 && r.Score > res.Retrieved[i-1].Score {
+			t.Errorf("candidate %d is out of score order", i)
+		}
+	}
+
+	if res.Metrics.RecallAtK[3] != 1 || res.PackedMetrics.RecallAtK[3] != 0 || res.PackedMetrics.MRR != 0 {
+		t.Errorf("got metrics %+v and packed metrics %+v", res.Metrics, res.PackedMetrics)
+	}
+}
+
+// judge returns a judge client that grades by criterion
+func judge(faithfulness, relevance, correctness string) *botmakertest.Client {
+	return botmakertest.NewClient().
+		When("must only use the provided context", faithfulness).
+		When("addresses the", relevance).
+		When("reference answer", correctness)
+}
+
+func answerConfig(t *testing.T, client *botmakertest.Client, judgeClient botMaker.LLMAPIClient) eval.AnswerConfig {
+	t.Helper()
+
+	cfg := evalConfig(client)
+	if _, err := eval.Run(client, capitals(t), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	judgeSettings := botMaker.NewBotSettings()
+	judgeSettings.Model = openai.GPT4
+
+	return eval.AnswerConfig{Name: "capitals", Settings: cfg.Settings, Judge: judgeSettings,
+		JudgeClient: judgeClient}
+}
+
+func TestRunAnswers(t *testing.T) {
+	client := &botmakertest.Client{Default: "Berlin."}
+	judgeClient := judge(`{"score": 1, "reason": "supported"}`, `{"score": 0.9, "reason": "direct"}`,
+		`{"score": 0.8, "reason": "same city"}`)
+
+	report, err := eval.RunAnswers(client, capitals(t), answerConfig(t, client, judgeClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := report.Results[0]
+	if res.Answer != "Berlin." || res.Error != "" || !res.Passed || res.Hallucination {
+		t.Fatalf("got %+v", res)
+	}
+
+	if len(res.Contexts) == 0 {
+		t.Error("the answer was graded without contexts")
+	}
+
+	want := map[string]float64{eval.Faithfulness: 1, eval.Relevance: 0.9, eval.Correctness: 0.8}
+	for criterion, score := range want {
+		if report.Scores[criterion] != score {
+			t.Errorf("%s: got %v, want %v", criterion, report.Scores[criterion], score)
+		}
+	}
+
+	if report.Questions != 1 || report.Passed != 1 || report.PassScore != 0.7 {
+		t.Errorf("got %+v", report)
+	}
+
+	// the faithfulness judge sees the retrieved contexts
+	for _, call := range judgeClient.Calls() {
+		if strings.Contains(call.Prompt, "provided context") &&
+			!strings.Contains(call.Prompt, "Berlin is the capital") {
+			t.Errorf("the judge did not get the contexts: %q", call.Prompt)
+		}
+	}
+}
+
+func TestRunAnswersFlagsHallucinations(t *testing.T) {
+	client := &botmakertest.Client{Default: "Berlin, a city of 40 million people."}
+	judgeClient := judge(`{"score": 0.9, "reason": "mostly", "unsupported_claims": ["40 million people"]}`,
+		`{"score": 1, "reason": "direct"}`, `{"score": 1, "reason": "same city"}`)
+
+	ds := capitals(t)
+	cfg := answerConfig(t, client, judgeClient)
+	ds.Questions[0].Answer = ""
+
+	report, err := eval.RunAnswers(client, ds, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := report.Results[0]
+	if !res.Hallucination || res.Passed || report.Hallucinations != 1 {
+		t.Errorf("got %+v", res)
+	}
+
+	if _, ok := res.Grades[eval.Correctness]; ok {
+		t.Error("graded correctness without a reference answer")
+	}
+}
+
+func TestRunAnswersRecordsJudgeErrors(t *testing.T) {
+	client := &botmakertest.Client{Default: "Berlin."}
+	judgeClient := judge("not a grade", `{"score": 1, "reason": "direct"}`, `{"score": 2, "reason": "too high"}`)
+
+	report, err := eval.RunAnswers(client, capitals(t), answerConfig(t, client, judgeClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := report.Results[0]; res.Error == "" || res.Passed || report.Errors != 1 {
+		t.Errorf("got %+v", res)
+	}
+}
@@ -0,0 +1,97 @@
+package eval
+
+import "math"
+
+// Metrics are retrieval metrics with binary relevance, for a question or averaged over a dataset
+type Metrics struct {
+	RecallAtK map[int]float64 `json:"recall_at_k"` // Share of the relevant items in the first k contexts
+	MRR       float64         `json:"mrr"`         // Reciprocal of the rank of the first relevant context
+	NDCGAtK   map[int]float64 `json:"ndcg_at_k"`   // Discounted gain of the first k contexts against the ideal ranking
+}
+
+// recallAtK returns the share of relevant items found in the first k results, gains marks results that are a
+// relevant item seen for the first time
+func recallAtK(gains []bool, relevant, k int) float64 {
+	if relevant == 0 {
+		return 0
+	}
+
+	found := 0
+	for i := 0; i < k && i < len(gains); i++ {
+		if gains[i] {
+			found++
+		}
+	}
+
+	return float64(found) / float64(relevant)
+}
+
+func reciprocalRank(gains []bool) float64 {
+	for i, g := range gains {
+		if g {
+			return 1 / float64(i+1)
+		}
+	}
+
+	return 0
+}
+
+func ndcgAtK(gains []bool, relevant, k int) float64 {
+	dcg := 0.0
+	for i := 0; i < k && i < len(gains); i++ {
+		if gains[i] {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	ideal := 0.0
+	for i := 0; i < k && i < relevant; i++ {
+		ideal += 1 / math.Log2(float64(i+2))
+	}
+
+	if ideal == 0 {
+		return 0
+	}
+
+	return dcg / ideal
+}
+
+// mean averages the given metrics
+func mean(metrics []Metrics, ks []int) Metrics {
+	m := Metrics{RecallAtK: make(map[int]float64, len(ks)), NDCGAtK: make(map[int]float64, len(ks))}
+	if len(metrics) == 0 {
+		return m
+	}
+
+	for _, r := range metrics {
+		m.MRR += r.MRR
+		for _, k := range ks {
+			m.RecallAtK[k] += r.RecallAtK[k]
+			m.NDCGAtK[k] += r.NDCGAtK[k]
+		}
+	}
+
+	n := float64(len(metrics))
+	m.MRR /= n
+	for _, k := range ks {
+		m.RecallAtK[k] /= n
+		m.NDCGAtK[k] /= n
+	}
+
+	return m
+}
+
+// rank computes the metrics of a ranking, gains marks results that are a relevant item seen for the first time
+func rank(gains []bool, relevant int, ks []int) Metrics {
+	m := Metrics{
+		RecallAtK: make(map[int]float64, len(ks)),
+		NDCGAtK:   make(map[int]float64, len(ks)),
+		MRR:       reciprocalRank(gains),
+	}
+	for _, k := range ks {
+		m.RecallAtK[k] = recallAtK(gains, relevant, k)
+		m.NDCGAtK[k] = ndcgAtK(gains, relevant, k)
+	}
+
+	return m
+}
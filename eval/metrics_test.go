@@ -0,0 +1,65 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestRecallAtK(t *testing.T) {
+	gains := []bool{false, true, false, true}
+
+	for k, want := range map[int]float64{1: 0, 2: 0.5, 4: 1, 10: 1} {
+		if got := recallAtK(gains, 2, k); !near(got, want) {
+			t.Errorf("recall@%d = %v, want %v", k, got, want)
+		}
+	}
+
+	if got := recallAtK(gains, 0, 4); got != 0 {
+		t.Errorf("got %v without relevant items", got)
+	}
+}
+
+func TestReciprocalRank(t *testing.T) {
+	if got := reciprocalRank([]bool{false, false, true}); !near(got, 1.0/3) {
+		t.Errorf("got %v", got)
+	}
+
+	if got := reciprocalRank([]bool{false}); got != 0 {
+		t.Errorf("got %v without a relevant result", got)
+	}
+}
+
+func TestNDCGAtK(t *testing.T) {
+	if got := ndcgAtK([]bool{true, true, false}, 2, 3); !near(got, 1) {
+		t.Errorf("an ideal ranking scored %v", got)
+	}
+
+	// one relevant item in second place: (1/log2(3)) / (1/log2(2))
+	if got := ndcgAtK([]bool{false, true}, 1, 2); !near(got, 1/math.Log2(3)) {
+		t.Errorf("got %v", got)
+	}
+
+	if got := ndcgAtK([]bool{false, true}, 1, 1); got != 0 {
+		t.Errorf("got %v for a relevant item past k", got)
+	}
+}
+
+func TestMean(t *testing.T) {
+	metrics := []Metrics{
+		{MRR: 1, RecallAtK: map[int]float64{1: 1}, NDCGAtK: map[int]float64{1: 1}},
+		{MRR: 0.5, RecallAtK: map[int]float64{1: 0}, NDCGAtK: map[int]float64{1: 0}},
+	}
+
+	m := mean(metrics, []int{1})
+	if !near(m.MRR, 0.75) || !near(m.RecallAtK[1], 0.5) || !near(m.NDCGAtK[1], 0.5) {
+		t.Errorf("got %+v", m)
+	}
+
+	if m := mean(nil, []int{1}); m.MRR != 0 || m.RecallAtK == nil {
+		t.Errorf("got %+v for no results", m)
+	}
+}
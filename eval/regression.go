@@ -0,0 +1,208 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Tolerance is how much worse a new answer report may be than its baseline before it is a regression. Judges are
+// noisy, the zero value allows no change at all
+type Tolerance struct {
+	Score           float64 // Largest drop of a criterion's mean score
+	PassRate        float64 // Largest drop of the share of questions that pass
+	Hallucinations  int     // Additional answers with unsupported claims
+	FailedQuestions int     // Questions that passed in the baseline and fail now
+}
+
+// Regression is a way in which a new answer report is worse than its baseline
+type Regression struct {
+	Metric   string  `json:"metric"` // A criterion, "pass_rate", "hallucinations" or "failed_questions"
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+func (r Regression) String() string {
+	format := "%s: %.3f -> %.3f"
+	if r.Metric == "hallucinations" || r.Metric == "failed_questions" {
+		format = "%s: %.0f -> %.0f"
+	}
+
+	s := fmt.Sprintf(format, r.Metric, r.Baseline, r.Current)
+	if r.Detail != "" {
+		s += " (" + r.Detail + ")"
+	}
+
+	return s
+}
+
+// CheckRegressions compares a new answer report with a baseline from the same dataset, an empty result means the
+// new report is within tolerance
+func CheckRegressions(baseline, current *AnswerReport, tol Tolerance) ([]Regression, error) {
+	if baseline.Dataset != current.Dataset {
+		return nil, fmt.Errorf("reports are not comparable, baseline is from %s and current from %s",
+			baseline.Dataset, current.Dataset)
+	}
+
+	regressions := make([]Regression, 0)
+	for _, criterion := range criteria(baseline, current) {
+		before, ok := baseline.Scores[criterion]
+		if !ok {
+			continue
+		}
+
+		after := current.Scores[criterion]
+		if before-after > tol.Score {
+			regressions = append(regressions, Regression{Metric: criterion, Baseline: before, Current: after})
+		}
+	}
+
+	if before, after := baseline.PassRate(), current.PassRate(); before-after > tol.PassRate {
+		regressions = append(regressions, Regression{Metric: "pass_rate", Baseline: before, Current: after})
+	}
+
+	if current.Hallucinations-baseline.Hallucinations > tol.Hallucinations {
+		regressions = append(regressions, Regression{
+			Metric:   "hallucinations",
+			Baseline: float64(baseline.Hallucinations),
+			Current:  float64(current.Hallucinations),
+		})
+	}
+
+	if failed := NewlyFailed(baseline, current); len(failed) > tol.FailedQuestions {
+		regressions = append(regressions, Regression{
+			Metric:  "failed_questions",
+			Current: float64(len(failed)),
+			Detail:  strings.Join(failed, ", "),
+		})
+	}
+
+	return regressions, nil
+}
+
+// PassRate is the share of questions whose answer passed every criterion
+func (r *AnswerReport) PassRate() float64 {
+	if r.Questions == 0 {
+		return 0
+	}
+
+	return float64(r.Passed) / float64(r.Questions)
+}
+
+// NewlyFailed returns the IDs of the questions that passed in the baseline and fail in the current report
+func NewlyFailed(baseline, current *AnswerReport) []string {
+	passed := make(map[string]bool, len(baseline.Results))
+	for _, res := range baseline.Results {
+		passed[res.ID] = res.Passed
+	}
+
+	failed := make([]string, 0)
+	for _, res := range current.Results {
+		if passed[res.ID] && !res.Passed {
+			failed = append(failed, res.ID)
+		}
+	}
+
+	return failed
+}
+
+// WriteSummary writes a Markdown summary of a new answer report against its baseline, e.g. for a CI job or a pull
+// request comment. The baseline may be nil
+func WriteSummary(w io.Writer, baseline, current *AnswerReport, regressions []Regression) error {
+	fmt.Fprintf(w, "## Answer quality: %s on %s\n\n", current.Config, current.Dataset)
+
+	if baseline == nil {
+		fmt.Fprintln(w, "| Metric | Current |")
+		fmt.Fprintln(w, "|---|---|")
+		for _, criterion := range criteria(current) {
+			fmt.Fprintf(w, "| %s | %.3f |\n", criterion, current.Scores[criterion])
+		}
+		fmt.Fprintf(w, "| pass rate | %.3f |\n", current.PassRate())
+		fmt.Fprintf(w, "| hallucinations | %d |\n", current.Hallucinations)
+		fmt.Fprintf(w, "| errors | %d |\n", current.Errors)
+	} else {
+		fmt.Fprintln(w, "| Metric | Baseline | Current | Change |")
+		fmt.Fprintln(w, "|---|---|---|---|")
+		for _, criterion := range criteria(baseline, current) {
+			before, after := baseline.Scores[criterion], current.Scores[criterion]
+			fmt.Fprintf(w, "| %s | %.3f | %.3f | %+.3f |\n", criterion, before, after, after-before)
+		}
+		before, after := baseline.PassRate(), current.PassRate()
+		fmt.Fprintf(w, "| pass rate | %.3f | %.3f | %+.3f |\n", before, after, after-before)
+		fmt.Fprintf(w, "| hallucinations | %d | %d | %+d |\n", baseline.Hallucinations, current.Hallucinations,
+			current.Hallucinations-baseline.Hallucinations)
+		fmt.Fprintf(w, "| errors | %d | %d | %+d |\n", baseline.Errors, current.Errors, current.Errors-baseline.Errors)
+	}
+
+	if len(regressions) > 0 {
+		fmt.Fprintf(w, "\n**%d regression(s)**\n\n", len(regressions))
+		for _, r := range regressions {
+			fmt.Fprintf(w, "- %s\n", r)
+		}
+	} else if baseline != nil {
+		fmt.Fprintln(w, "\nNo regressions.")
+	}
+
+	failing := make([]AnswerResult, 0)
+	for _, res := range current.Results {
+		if !res.Passed {
+			failing = append(failing, res)
+		}
+	}
+
+	if len(failing) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n### Failing questions\n\n")
+	for _, res := range failing {
+		fmt.Fprintf(w, "- **%s** %s: %s\n", res.ID, res.Question, failure(res))
+	}
+
+	return nil
+}
+
+// failure explains in a line why an answer did not pass
+func failure(res AnswerResult) string {
+	if res.Error != "" {
+		return "error: " + res.Error
+	}
+
+	reasons := make([]string, 0)
+	if res.Hallucination {
+		reasons = append(reasons, "unsupported claims: "+strings.Join(res.Grades[Faithfulness].UnsupportedClaims, "; "))
+	}
+
+	names := make([]string, 0, len(res.Grades))
+	for criterion := range res.Grades {
+		names = append(names, criterion)
+	}
+	sort.Strings(names)
+
+	for _, criterion := range names {
+		g := res.Grades[criterion]
+		reasons = append(reasons, fmt.Sprintf("%s %.2f, %s", criterion, g.Score, g.Reason))
+	}
+
+	return strings.Join(reasons, "; ")
+}
+
+// criteria returns the sorted criteria scored in any of the reports
+func criteria(reports ...*AnswerReport) []string {
+	names := make(map[string]bool)
+	for _, r := range reports {
+		for criterion := range r.Scores {
+			names[criterion] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
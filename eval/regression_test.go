@@ -0,0 +1,111 @@
+package eval_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker/eval"
+)
+
+func report(scores map[string]float64, hallucinations int, passed ...bool) *eval.AnswerReport {
+	r := &eval.AnswerReport{Dataset: "faq", Questions: len(passed), Hallucinations: hallucinations, Scores: scores}
+	for i, p := range passed {
+		if p {
+			r.Passed++
+		}
+		r.Results = append(r.Results, eval.AnswerResult{ID: string(rune('a' + i)), Passed: p})
+	}
+
+	return r
+}
+
+func metrics(regressions []eval.Regression) []string {
+	names := make([]string, len(regressions))
+	for i, r := range regressions {
+		names[i] = r.Metric
+	}
+
+	return names
+}
+
+func TestCheckRegressions(t *testing.T) {
+	baseline := report(map[string]float64{eval.Faithfulness: 0.9, eval.Relevance: 0.8}, 0, true, true, false, true)
+	current := report(map[string]float64{eval.Faithfulness: 0.7, eval.Relevance: 0.8}, 2, true, false, true, false)
+
+	regressions, err := eval.CheckRegressions(baseline, current, eval.Tolerance{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{eval.Faithfulness, "pass_rate", "hallucinations", "failed_questions"}
+	if !reflect.DeepEqual(metrics(regressions), want) {
+		t.Fatalf("got %v, want %v", metrics(regressions), want)
+	}
+
+	if failed := regressions[3]; failed.Current != 2 || failed.Detail != "b, d" {
+		t.Errorf("got %+v", failed)
+	}
+
+	if s := regressions[2].String(); s != "hallucinations: 0 -> 2" {
+		t.Errorf("got %q", s)
+	}
+
+	tol := eval.Tolerance{Score: 0.25, PassRate: 0.25, Hallucinations: 2, FailedQuestions: 2}
+	if regressions, _ := eval.CheckRegressions(baseline, current, tol); len(regressions) != 0 {
+		t.Errorf("got %v within tolerance", regressions)
+	}
+}
+
+func TestCheckRegressionsImprovement(t *testing.T) {
+	baseline := report(map[string]float64{eval.Relevance: 0.5}, 1, false, true)
+	current := report(map[string]float64{eval.Relevance: 0.9, eval.Correctness: 0.2}, 0, true, true)
+
+	// a criterion the baseline did not grade is not a regression
+	if regressions, _ := eval.CheckRegressions(baseline, current, eval.Tolerance{}); len(regressions) != 0 {
+		t.Errorf("got %v", regressions)
+	}
+}
+
+func TestCheckRegressionsOtherDataset(t *testing.T) {
+	baseline := report(nil, 0, true)
+	current := report(nil, 0, true)
+	current.Dataset = "other"
+
+	if _, err := eval.CheckRegressions(baseline, current, eval.Tolerance{}); err == nil {
+		t.Error("reports of different datasets were compared")
+	}
+}
+
+func TestPassRateAndNewlyFailed(t *testing.T) {
+	baseline := report(nil, 0, true, false, true)
+	current := report(nil, 0, false, true, true)
+
+	if got := current.PassRate(); got != 2.0/3 {
+		t.Errorf("got pass rate %v", got)
+	}
+
+	if got := (&eval.AnswerReport{}).PassRate(); got != 0 {
+		t.Errorf("got %v for an empty report", got)
+	}
+
+	if got := eval.NewlyFailed(baseline, current); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	baseline := report(map[string]float64{eval.Relevance: 0.9}, 0, true)
+	current := report(map[string]float64{eval.Relevance: 0.5}, 0, false)
+	regressions, _ := eval.CheckRegressions(baseline, current, eval.Tolerance{})
+
+	var buf bytes.Buffer
+	if err := eval.WriteSummary(&buf, baseline, current, regressions); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), eval.Relevance) {
+		t.Errorf("the summary does not mention the regressed criterion:\n%s", buf.String())
+	}
+}
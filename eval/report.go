@@ -0,0 +1,206 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Retrieved is a candidate context retrieved for a question
+type Retrieved struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Source   string  `json:"source,omitempty"`
+	Score    float32 `json:"score"`
+	Relevant bool    `json:"relevant"`
+	Dropped  string  `json:"dropped,omitempty"` // Why the candidate was left out of the prompt, empty when it was packed
+}
+
+// QuestionResult is the evaluation of a single question
+type QuestionResult struct {
+	ID            string        `json:"id"`
+	Question      string        `json:"question"`
+	Relevant      int           `json:"relevant"`       // Number of labelled documents and chunks
+	Retrieved     []Retrieved   `json:"retrieved"`      // Candidates above MinScore in score order, before packing
+	Metrics       Metrics       `json:"metrics"`        // Of the retrieved candidates
+	PackedMetrics Metrics       `json:"packed_metrics"` // Of the contexts packed into the prompt
+	Latency       time.Duration `json:"latency_ns"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// Report is the evaluation of a configuration against a dataset
+type Report struct {
+	Dataset          string            `json:"dataset"`
+	Config           string            `json:"config"`
+	Params           map[string]string `json:"params"`
+	Ks               []int             `json:"ks"`
+	Started          time.Time         `json:"started"`
+	Embeddings       int               `json:"embeddings"` // Created while learning the dataset
+	LearnDuration    time.Duration     `json:"learn_duration_ns"`
+	RetrieveDuration time.Duration     `json:"retrieve_duration_ns"`
+	Questions        int               `json:"questions"`
+	Errors           int               `json:"errors"`         // Questions that failed, they score 0
+	Metrics          Metrics           `json:"metrics"`        // Mean of the questions' retrieval metrics
+	PackedMetrics    Metrics           `json:"packed_metrics"` // Mean of the questions' packed metrics
+	Results          []QuestionResult  `json:"results"`
+}
+
+func (r *Report) summarize() {
+	r.Questions = len(r.Results)
+	r.Errors = 0
+	for _, res := range r.Results {
+		if res.Error != "" {
+			r.Errors++
+		}
+	}
+
+	metrics := make([]Metrics, len(r.Results))
+	packed := make([]Metrics, len(r.Results))
+	for i, res := range r.Results {
+		metrics[i] = res.Metrics
+		packed[i] = res.PackedMetrics
+	}
+
+	r.Metrics = mean(metrics, r.Ks)
+	r.PackedMetrics = mean(packed, r.Ks)
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// SaveJSON writes the report to a JSON file
+func (r *Report) SaveJSON(path string) error {
+	return saveJSON(path, r)
+}
+
+// LoadReport reads a report written by SaveJSON, e.g. to compare with a new run
+func LoadReport(path string) (*Report, error) {
+	r := &Report{}
+	if err := loadJSON(path, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Misses returns the questions for which no relevant context was retrieved
+func (r *Report) Misses() []QuestionResult {
+	misses := make([]QuestionResult, 0)
+	for _, res := range r.Results {
+		if res.Metrics.MRR == 0 {
+			misses = append(misses, res)
+		}
+	}
+
+	return misses
+}
+
+// Compare writes a table with a row per report, the parameters that differ between them and their metrics
+func Compare(w io.Writer, reports ...*Report) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	ks := reports[0].Ks
+	for _, r := range reports[1:] {
+		if fmt.Sprint(r.Ks) != fmt.Sprint(ks) || r.Dataset != reports[0].Dataset {
+			return fmt.Errorf("reports are not comparable, they need the same dataset and cut-offs")
+		}
+	}
+
+	params := differingParams(reports)
+
+	header := []string{"CONFIG"}
+	header = append(header, params...)
+	for _, k := range ks {
+		header = append(header, fmt.Sprintf("R@%d", k))
+	}
+	header = append(header, "MRR")
+	for _, k := range ks {
+		header = append(header, fmt.Sprintf("NDCG@%d", k))
+	}
+	header = append(header, "ERRORS")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range reports {
+		row := []string{r.Config}
+		for _, p := range params {
+			row = append(row, r.Params[p])
+		}
+		for _, k := range ks {
+			row = append(row, fmt.Sprintf("%.3f", r.Metrics.RecallAtK[k]))
+		}
+		row = append(row, fmt.Sprintf("%.3f", r.Metrics.MRR))
+		for _, k := range ks {
+			row = append(row, fmt.Sprintf("%.3f", r.Metrics.NDCGAtK[k]))
+		}
+		row = append(row, fmt.Sprint(r.Errors))
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func saveJSON(path string, v interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSON(f, v); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func loadJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// differingParams returns the sorted names of the parameters whose values are not the same in every report
+func differingParams(reports []*Report) []string {
+	names := make(map[string]bool)
+	for _, r := range reports {
+		for k := range r.Params {
+			names[k] = true
+		}
+	}
+
+	differing := make([]string, 0)
+	for name := range names {
+		for _, r := range reports[1:] {
+			if r.Params[name] != reports[0].Params[name] {
+				differing = append(differing, name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(differing)
+	return differing
+}
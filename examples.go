@@ -0,0 +1,175 @@
+package botMaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Example is a question and a good answer to it, used for few-shot prompting
+type Example struct {
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// ExampleStore holds a bot's library of examples, each is embedded once when it is added, and picks the ones most
+// similar to a question for every prompt
+type ExampleStore struct {
+	Client         LLMAPIClient
+	EmbeddingModel openai.EmbeddingModel
+	K              int // Most examples to add to a prompt
+	MaxTokens      int // Token budget for the selected examples, 0 is unlimited
+
+	mu       sync.RWMutex
+	examples []Example
+}
+
+func NewExampleStore(client LLMAPIClient) *ExampleStore {
+	return &ExampleStore{
+		Client:         client,
+		EmbeddingModel: openai.AdaEmbeddingV2,
+		K:              3,
+		MaxTokens:      1000,
+	}
+}
+
+// Add embeds and stores examples, examples that already carry an embedding are not embedded again
+func (e *ExampleStore) Add(examples ...Example) error {
+	toEmbed := make([]Chunk, 0)
+	idx := make([]int, 0)
+	for i := range examples {
+		if len(examples[i].Embedding) == 0 {
+			toEmbed = append(toEmbed, Chunk{Title: "example", Text: examples[i].Question})
+			idx = append(idx, i)
+		}
+	}
+
+	if len(toEmbed) > 0 {
+		embeddings, err := e.Client.GetEmbeddingsForData(toEmbed, 100, e.EmbeddingModel)
+		if err != nil {
+			return err
+		}
+
+		if len(embeddings) != len(toEmbed) {
+			return fmt.Errorf("embedded %d of %d examples", len(embeddings), len(toEmbed))
+		}
+
+		for j := range embeddings {
+			examples[idx[j]].Embedding = embeddings[j]
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range examples {
+		if len(examples[i].Embedding) > 0 {
+			e.examples = append(e.examples, examples[i])
+		}
+	}
+
+	return nil
+}
+
+// Examples returns all stored examples
+func (e *ExampleStore) Examples() []Example {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	out := make([]Example, len(e.examples))
+	copy(out, e.examples)
+	return out
+}
+
+// Select returns up to K examples most similar to the question that fit in MaxTokens, most similar first
+func (e *ExampleStore) Select(question, model string) ([]Example, error) {
+	return e.selectExamples(context.Background(), question, model)
+}
+
+func (e *ExampleStore) selectExamples(ctx context.Context, question, model string) ([]Example, error) {
+	e.mu.RLock()
+	candidates := make([]Example, len(e.examples))
+	copy(candidates, e.examples)
+	e.mu.RUnlock()
+
+	if len(candidates) == 0 || e.K <= 0 {
+		return nil, nil
+	}
+
+	embedding, err := embedPrompt(ctx, e.Client, question, e.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float32, len(candidates))
+	order := make([]int, len(candidates))
+	for i := range candidates {
+		scores[i] = CosineSimilarity(embedding, candidates[i].Embedding)
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	selected := make([]Example, 0, e.K)
+	used := 0
+	for _, i := range order {
+		if len(selected) >= e.K {
+			break
+		}
+
+		q, _ := CountTokens(candidates[i].Question, model)
+		a, _ := CountTokens(candidates[i].Answer, model)
+		if e.MaxTokens > 0 && used+q+a > e.MaxTokens {
+			continue
+		}
+
+		used += q + a
+		selected = append(selected, candidates[i])
+	}
+
+	return selected, nil
+}
+
+// Save writes the examples and their embeddings to a JSON file so they don't need embedding again
+func (e *ExampleStore) Save(path string) error {
+	data, err := json.Marshal(e.Examples())
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load adds the examples in a JSON file written by Save (or a hand written list of questions and answers)
+func (e *ExampleStore) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	examples := make([]Example, 0)
+	if err := json.Unmarshal(data, &examples); err != nil {
+		return err
+	}
+
+	return e.Add(examples...)
+}
+
+// exampleMessages returns examples as alternating user and assistant chat messages
+func exampleMessages(examples []Example) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(examples)*2)
+	for _, ex := range examples {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: ex.Question},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: ex.Answer})
+	}
+
+	return messages
+}
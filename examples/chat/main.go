@@ -27,7 +27,7 @@ func main() {
 	cfg := botMaker.NewConfigFromEnv()
 
 	// Set up the OAI API client
-	oai := botMaker.NewOAIClient(cfg.OpenAPIKey)
+	oai := botMaker.NewOAIClient(cfg.LLMAPIKey)
 
 	// Get the tuning for the bot, we'll use some defaults
 	settings := botMaker.NewBotSettings()
@@ -40,7 +40,6 @@ func main() {
 	settings.Model = openai.GPT4
 	settings.Temp = 0.9
 	settings.TopP = 0.9
-	settings.MaxTokens = 4096 // need to set this for 3.5 turbo
 
 	// If adding context (additional data outside of GPTs training data), y
 	// you can attach a memory store to query
@@ -73,7 +72,7 @@ func main() {
 
 		// make the OpenAI query, the prompt object will render the query
 		// according to its template with the context embeddings pulled from Pinecone
-		resp, _, err := oai.CallUnifiedCompletionAPI(settings, prompt)
+		resp, _, err := oai.CallCompletionAPI(settings, prompt)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -26,14 +26,13 @@ func main() {
 	cfg := botMaker.NewConfigFromEnv()
 
 	// Set up the OAI API client
-	oai := botMaker.NewOAIClient(cfg.OpenAPIKey)
+	oai := botMaker.NewOAIClient(cfg.LLMAPIKey)
 
 	// Get the tuning for the bot, we'll use specialist code one and up the temp to make answers stricter
 	settings := botMaker.NewBotSettings()
 	settings.Model = openai.GPT3Dot5Turbo
 	settings.Temp = 0.9
 	settings.TopP = 0.9
-	settings.MaxTokens = 3500 // need to set this for 3.5 turbo
 
 	// the Prompt holds all the information and logic needed to make a query to OpenAI,
 	// to change the way the prompt is presented to the AI, provide a text/template
@@ -59,7 +58,7 @@ func main() {
 
 		// make the OpenAI query, the prompt object will render the query
 		// according to its template with the context embeddings pulled from Pinecone
-		resp, _, err := oai.CallUnifiedCompletionAPI(settings, prompt)
+		resp, _, err := oai.CallCompletionAPI(settings, prompt)
 		if err != nil {
 			fmt.Println(err)
 		}
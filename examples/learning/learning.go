@@ -66,7 +66,7 @@ func learnFile(ns, filename string) error {
 	cfg := botMaker.NewConfigFromEnv()
 
 	// Client
-	cl := botMaker.NewOAIClient(cfg.OpenAPIKey)
+	cl := botMaker.NewOAIClient(cfg.LLMAPIKey)
 
 	// Create some storage
 	pc := &botMaker.Pinecone{
@@ -85,7 +85,7 @@ func learnFile(ns, filename string) error {
 		Client:    cl,
 	}
 
-	count, err := l.FromFile(filename, true)
+	count, err := l.FromFile(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,81 @@
+package botMaker_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+var capitals = []botMaker.Example{
+	{Question: "What is the capital of France?", Answer: "Paris."},
+	{Question: "How do penguins keep warm?", Answer: "Feathers and fat."},
+	{Question: "What is the capital of Spain?", Answer: "Madrid."},
+}
+
+func TestExampleStoreSelectsSimilarExamples(t *testing.T) {
+	client := botmakertest.NewClient()
+	store := botMaker.NewExampleStore(client)
+	store.K = 2
+	if err := store.Add(append([]botMaker.Example(nil), capitals...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	selected, err := store.Select("What is the capital of Germany?", newSettings().Model)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(selected) != 2 || selected[0].Answer == "Feathers and fat." || selected[1].Answer == "Feathers and fat." {
+		t.Errorf("got %+v, want the two capitals", selected)
+	}
+
+	store.MaxTokens = 40
+	if selected, _ := store.Select("What is the capital of Germany?", newSettings().Model); len(selected) != 1 {
+		t.Errorf("got %d examples within the token budget", len(selected))
+	}
+}
+
+func TestExampleStoreSaveAndLoad(t *testing.T) {
+	client := botmakertest.NewClient()
+	store := botMaker.NewExampleStore(client)
+	if err := store.Add(append([]botMaker.Example(nil), capitals...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "examples.json")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Reset()
+	loaded := botMaker.NewExampleStore(client)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Examples()) != 3 || len(client.EmbeddingCalls()) != 0 {
+		t.Errorf("got %d examples after %d embedding calls", len(loaded.Examples()), len(client.EmbeddingCalls()))
+	}
+}
+
+func TestExamplesAreAddedToPrompts(t *testing.T) {
+	client := botmakertest.NewClient("Berlin.")
+	settings := newSettings()
+	settings.Examples = botMaker.NewExampleStore(client)
+	settings.Examples.K = 1
+	if err := settings.Examples.Add(append([]botMaker.Example(nil), capitals...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	_, prompt := ask(t, client, settings, "What is the capital of Germany?")
+	if len(prompt.Examples) != 1 {
+		t.Fatalf("got %+v", prompt.Examples)
+	}
+
+	call, _ := client.LastCall()
+	if len(call.Messages) < 3 || call.Messages[len(call.Messages)-2].Role != "assistant" {
+		t.Errorf("the example is not a user/assistant pair: %+v", call.Messages)
+	}
+}
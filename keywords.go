@@ -0,0 +1,328 @@
+package botMaker
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// KeywordStore is a lexical index that is built during Learn and queried alongside vector memory, it catches
+// exact identifiers (error codes, config keys) that embeddings tend to miss
+type KeywordStore interface {
+	Search(query string, topK int, uuid string) ([]QueryMatch, error)
+	IndexChunks(namespace string, chunks []Chunk) error
+}
+
+// BM25Index is an in-memory KeywordStore that scores chunks with Okapi BM25, it can be persisted to a JSON file
+// so that it can live next to the vectors it was built with
+type BM25Index struct {
+	Path string  `json:"-"`  // If set, the index is loaded from and saved to this file
+	K1   float64 `json:"k1"` // Term frequency saturation
+	B    float64 `json:"b"`  // Length normalisation
+
+	Namespaces map[string]*BM25Namespace `json:"namespaces"`
+
+	mu sync.RWMutex
+}
+
+type BM25Namespace struct {
+	Docs     map[string]*BM25Doc `json:"docs"`
+	DocFreq  map[string]int      `json:"doc_freq"`
+	TotalLen int                 `json:"total_len"`
+}
+
+type BM25Doc struct {
+	Metadata map[string]string `json:"metadata"`
+	Terms    map[string]int    `json:"terms"`
+	Length   int               `json:"length"`
+}
+
+// NewBM25Index returns an index with the usual BM25 defaults, if path is set and exists the index is loaded from it
+func NewBM25Index(path string) (*BM25Index, error) {
+	idx := &BM25Index{
+		Path:       path,
+		K1:         1.2,
+		B:          0.75,
+		Namespaces: make(map[string]*BM25Namespace),
+	}
+
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// Save writes the index to Path. IndexChunks does not save, call Save once a batch of documents has been indexed
+func (idx *BM25Index) Save() error {
+	if idx.Path == "" {
+		return nil
+	}
+
+	idx.mu.RLock()
+	data, err := json.Marshal(idx)
+	idx.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(idx.Path, data, 0644)
+}
+
+// IndexChunks adds chunks to the index under namespace, chunks are keyed with the same IDs as vector uploads so
+// re-learning a file replaces its previous entries. The index is only written to Path by Save
+func (idx *BM25Index) IndexChunks(namespace string, chunks []Chunk) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.Namespaces == nil {
+		idx.Namespaces = make(map[string]*BM25Namespace)
+	}
+
+	ns, ok := idx.Namespaces[namespace]
+	if !ok {
+		ns = &BM25Namespace{
+			Docs:    make(map[string]*BM25Doc),
+			DocFreq: make(map[string]int),
+		}
+		idx.Namespaces[namespace] = ns
+	}
+
+	for i := range chunks {
+		id := ChunkID(chunks[i].Title, i)
+		ns.remove(id)
+
+		terms := make(map[string]int)
+		tokens := Tokenize(chunks[i].Text)
+		for _, t := range tokens {
+			terms[t]++
+		}
+
+		for t := range terms {
+			ns.DocFreq[t]++
+		}
+
+		ns.Docs[id] = &BM25Doc{
+			Metadata: ChunkMetadata(chunks[i]),
+			Terms:    terms,
+			Length:   len(tokens),
+		}
+		ns.TotalLen += len(tokens)
+	}
+
+	return nil
+}
+
+func (ns *BM25Namespace) remove(id string) {
+	old, ok := ns.Docs[id]
+	if !ok {
+		return
+	}
+
+	for t := range old.Terms {
+		ns.DocFreq[t]--
+		if ns.DocFreq[t] <= 0 {
+			delete(ns.DocFreq, t)
+		}
+	}
+
+	ns.TotalLen -= old.Length
+	delete(ns.Docs, id)
+}
+
+// Search returns the topK chunks in namespace uuid ranked by their BM25 score against the query
+func (idx *BM25Index) Search(query string, topK int, uuid string) ([]QueryMatch, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ns, ok := idx.Namespaces[uuid]
+	if !ok || len(ns.Docs) == 0 {
+		return nil, nil
+	}
+
+	n := float64(len(ns.Docs))
+	avgLen := float64(ns.TotalLen) / n
+
+	queryTerms := make(map[string]bool)
+	for _, t := range Tokenize(query) {
+		queryTerms[t] = true
+	}
+
+	matches := make([]QueryMatch, 0)
+	for id, doc := range ns.Docs {
+		var score float64
+		for t := range queryTerms {
+			tf := float64(doc.Terms[t])
+			if tf == 0 {
+				continue
+			}
+
+			df := float64(ns.DocFreq[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - idx.B + idx.B*float64(doc.Length)/avgLen
+			score += idf * tf * (idx.K1 + 1) / (tf + idx.K1*norm)
+		}
+
+		if score > 0 {
+			matches = append(matches, QueryMatch{
+				ID:       id,
+				Score:    float32(score),
+				Metadata: doc.Metadata,
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].ID < matches[j].ID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+
+	return matches, nil
+}
+
+// Tokenize lower-cases text and splits it into terms, identifiers such as "ERR_CONN-42" or "max.conns" are kept
+// whole and are also indexed by their parts
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.')
+	})
+
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.Trim(w, "_-.")
+		if w == "" {
+			continue
+		}
+		tokens = append(tokens, w)
+
+		parts := strings.FieldsFunc(w, func(r rune) bool {
+			return r == '_' || r == '-' || r == '.'
+		})
+		if len(parts) > 1 {
+			tokens = append(tokens, parts...)
+		}
+	}
+
+	return tokens
+}
+
+// HybridRetriever merges vector matches from a Storage with keyword matches from a KeywordStore using
+// weighted reciprocal rank fusion
+type HybridRetriever struct {
+	Vectors       Storage
+	Keywords      KeywordStore
+	VectorWeight  float32 // 0 means 1
+	KeywordWeight float32 // 0 means 1
+	RRFK          int     // Rank constant, defaults to 60
+}
+
+// Retrieve queries both stores for cfg.CandidatePool matches each and returns the fused candidates. Vector matches
+// below cfg.MinScore are dropped before fusion, as the fused scores are ranks and not similarities, and are returned
+// separately so callers can report them
+func (h *HybridRetriever) Retrieve(query string, questionEmbedding []float32, cfg RetrievalSettings,
+	uuid string) ([]QueryMatch, []QueryMatch, error) {
+	vectorMatches, err := h.Vectors.Retrieve(questionEmbedding, cfg.CandidatePool, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accepted := make([]QueryMatch, 0, len(vectorMatches))
+	rejected := make([]QueryMatch, 0)
+	for i := range vectorMatches {
+		if vectorMatches[i].Score >= cfg.MinScore {
+			accepted = append(accepted, vectorMatches[i])
+		} else {
+			rejected = append(rejected, vectorMatches[i])
+		}
+	}
+
+	if h.Keywords == nil {
+		return accepted, rejected, nil
+	}
+
+	keywordMatches, err := h.Keywords.Search(query, cfg.CandidatePool, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fused := FuseRRF(h.RRFK,
+		[][]QueryMatch{accepted, keywordMatches},
+		[]float32{weightOr1(h.VectorWeight), weightOr1(h.KeywordWeight)})
+
+	if len(fused) > cfg.CandidatePool {
+		fused = fused[:cfg.CandidatePool]
+	}
+
+	return fused, rejected, nil
+}
+
+func weightOr1(w float32) float32 {
+	if w == 0 {
+		return 1
+	}
+
+	return w
+}
+
+// FuseRRF merges ranked lists of matches with reciprocal rank fusion, each list contributes
+// weight / (k + rank) to a match's score. Matches are identified by source and ID
+func FuseRRF(k int, lists [][]QueryMatch, weights []float32) []QueryMatch {
+	if k <= 0 {
+		k = 60
+	}
+
+	scores := make(map[string]float32)
+	byID := make(map[string]QueryMatch)
+	order := make([]string, 0)
+
+	for li, list := range lists {
+		w := float32(1)
+		if li < len(weights) {
+			w = weights[li]
+		}
+
+		for rank, m := range list {
+			key := m.Source + "/" + m.ID
+			if _, seen := byID[key]; !seen {
+				byID[key] = m
+				order = append(order, key)
+			}
+			scores[key] += w / float32(k+rank+1)
+		}
+	}
+
+	fused := make([]QueryMatch, 0, len(order))
+	for _, key := range order {
+		m := byID[key]
+		m.Score = scores[key]
+		fused = append(fused, m)
+	}
+
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].Score > fused[j].Score
+	})
+
+	return fused
+}
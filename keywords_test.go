@@ -0,0 +1,172 @@
+package botMaker_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func indexed(t *testing.T, path string, texts ...string) *botMaker.BM25Index {
+	t.Helper()
+
+	idx, err := botMaker.NewBM25Index(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := make([]botMaker.Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = botMaker.Chunk{Title: "doc", Text: text}
+	}
+
+	if err := idx.IndexChunks("docs", chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	return idx
+}
+
+func TestTokenizeKeepsIdentifiers(t *testing.T) {
+	got := botMaker.Tokenize("Set max.conns, then see ERR_CONN-42.")
+	want := []string{"set", "max.conns", "max", "conns", "then", "see", "err_conn-42", "err", "conn", "42"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBM25SearchRanksExactIdentifiers(t *testing.T) {
+	idx := indexed(t, "",
+		"Connections fail with a timeout when the pool is exhausted.",
+		"ERR_CONN-42 means the pool is exhausted, raise max.conns.",
+		"The pool is configured in the settings file.")
+
+	matches, err := idx.Search("what does ERR_CONN-42 mean", 2, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) == 0 || matches[0].ID != botMaker.ChunkID("doc", 1) {
+		t.Fatalf("expected the chunk with the identifier first, got %+v", matches)
+	}
+
+	if matches[0].Metadata["text"] == "" {
+		t.Error("matches carry no metadata")
+	}
+
+	if other, _ := idx.Search("ERR_CONN-42", 2, "other"); len(other) != 0 {
+		t.Errorf("namespaces leaked: %+v", other)
+	}
+}
+
+func TestBM25ReindexReplacesChunks(t *testing.T) {
+	idx := indexed(t, "", "apples and pears")
+	if err := idx.IndexChunks("docs", []botMaker.Chunk{{Title: "doc", Text: "plums"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if matches, _ := idx.Search("apples", 3, "docs"); len(matches) != 0 {
+		t.Errorf("the old chunk is still indexed: %+v", matches)
+	}
+
+	if matches, _ := idx.Search("plums", 3, "docs"); len(matches) != 1 {
+		t.Errorf("the new chunk is not indexed: %+v", matches)
+	}
+}
+
+func TestBM25IndexRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	idx := indexed(t, path, "apples and pears", "plums")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("IndexChunks wrote the file: %v", err)
+	}
+
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := botMaker.NewBM25Index(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := loaded.Search("plums", 3, "docs")
+	if len(matches) != 1 || matches[0].ID != botMaker.ChunkID("doc", 1) {
+		t.Errorf("got %+v after loading", matches)
+	}
+}
+
+func TestFuseRRF(t *testing.T) {
+	vectors := []botMaker.QueryMatch{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	keywords := []botMaker.QueryMatch{{ID: "c"}, {ID: "b"}}
+
+	fused := botMaker.FuseRRF(0, [][]botMaker.QueryMatch{vectors, keywords}, []float32{1, 1})
+	ids := make([]string, len(fused))
+	for i := range fused {
+		ids[i] = fused[i].ID
+	}
+
+	// a first place counts for more than two second places
+	if !reflect.DeepEqual(ids, []string{"c", "b", "a"}) {
+		t.Errorf("got %v", ids)
+	}
+
+	if want := float32(1)/63 + float32(1)/61; fused[0].Score != want {
+		t.Errorf("got score %v, want %v with k defaulting to 60", fused[0].Score, want)
+	}
+
+	weighted := botMaker.FuseRRF(60, [][]botMaker.QueryMatch{vectors, keywords}, []float32{1, 0})
+	if weighted[0].ID != "a" {
+		t.Errorf("keyword weight ignored, got %s first", weighted[0].ID)
+	}
+}
+
+func TestFuseRRFKeysOnSource(t *testing.T) {
+	a := []botMaker.QueryMatch{{ID: "x", Source: "one"}}
+	b := []botMaker.QueryMatch{{ID: "x", Source: "two"}}
+
+	if fused := botMaker.FuseRRF(60, [][]botMaker.QueryMatch{a, b}, nil); len(fused) != 2 {
+		t.Errorf("matches from different sources were merged: %+v", fused)
+	}
+}
+
+func TestHybridRetriever(t *testing.T) {
+	store := botmakertest.NewStorage("docs")
+	err := store.Upsert("docs", []botMaker.PineconeVector{
+		{ID: "close", Values: []float32{1, 0}, Metadata: map[string]string{"text": "close"}},
+		{ID: "far", Values: []float32{0, 1}, Metadata: map[string]string{"text": "far"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keywords := indexed(t, "", "ERR_CONN-42 is a pool error")
+	h := &botMaker.HybridRetriever{Vectors: store, Keywords: keywords}
+	cfg := botMaker.RetrievalSettings{TopK: 3, CandidatePool: 5, MinScore: 0.5}
+
+	fused, rejected, err := h.Retrieve("ERR_CONN-42", []float32{1, 0}, cfg, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rejected) != 1 || rejected[0].ID != "far" {
+		t.Errorf("expected far to be below MinScore, got %+v", rejected)
+	}
+
+	ids := map[string]bool{}
+	for _, m := range fused {
+		ids[m.ID] = true
+	}
+	if len(fused) != 2 || !ids["close"] || !ids[botMaker.ChunkID("doc", 0)] {
+		t.Errorf("expected the vector and the keyword match, got %+v", fused)
+	}
+
+	// zero weights count as 1
+	if fused[0].Score != fused[1].Score || fused[0].Score == 0 {
+		t.Errorf("got scores %v and %v", fused[0].Score, fused[1].Score)
+	}
+}
@@ -2,15 +2,21 @@ package botMaker
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"code.sajari.com/docconv"
 	"github.com/jdkato/prose/v2"
 	stripmd "github.com/writeas/go-strip-markdown"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type Chunk struct {
@@ -22,15 +28,49 @@ type Chunk struct {
 
 type Learn struct {
 	Model           string
-	TokenLimit      int
+	TokenLimit      int // Max tokens in a chunk, 0 uses the embedding model's context window
 	ChunkSize       int
 	Overlap         int
 	Memory          Storage
+	Keywords        KeywordStore   // Optional, chunks are also indexed here for hybrid retrieval
+	Namespace       string         // Namespace being learned into, defaults to a Pinecone Memory's UUID
+	ResponseCache   *ResponseCache // Optional, responses answered from Namespace are dropped after learning
 	Client          LLMAPIClient
 	GetTitle        TitleGetter
 	PreProcessBody  PreProcessor
 	PreProcessChunk PreProcessor
 	ContentSplitter ContentSplitter
+	Logger          Logger       // Optional, defaults to DefaultLogger
+	HTTPClient      *http.Client // Fetches FromURL's documents, defaults to a client with DefaultFetchTimeout
+}
+
+// DefaultFetchTimeout limits how long FromURL waits for a document when Learn has no HTTPClient
+var DefaultFetchTimeout = 30 * time.Second
+
+// namespace returns Namespace or, when it is not set, the namespace a Pinecone Memory uploads into
+func (l *Learn) namespace() string {
+	if l.Namespace != "" {
+		return l.Namespace
+	}
+
+	if pc, ok := l.Memory.(*Pinecone); ok {
+		return pc.UUID
+	}
+
+	return ""
+}
+
+// tokenLimit returns TokenLimit or, when it is 0, the context window of the embedding model chunks are sent to
+func (l *Learn) tokenLimit() int {
+	if l.TokenLimit > 0 {
+		return l.TokenLimit
+	}
+
+	if m, ok := LookupModel(l.Client.GetEmbeddingModel().String()); ok {
+		return m.ContextWindow
+	}
+
+	return 8191
 }
 
 // ExtensionSupported checks if the extension for a given file path is supported by the library, it returns the
@@ -161,6 +201,32 @@ func (l *Learn) ProcessPDFFile(path string) (string, string, error) {
 }
 
 func (l *Learn) Learn(contents, title string) (int, error) {
+	attrs := []attribute.KeyValue{AttrNamespace.String(l.namespace())}
+	ctx, span := startSpan(context.Background(), "Learn", append(attrs, attribute.String("botmaker.title", title))...)
+	started := time.Now()
+
+	n, err := l.learn(ctx, contents, title)
+
+	span.SetAttributes(AttrChunks.Int(n))
+	meters().chunks.Add(ctx, int64(n), metric.WithAttributes(attrs...))
+	endSpan(ctx, span, "learn", started, err, attrs...)
+
+	return n, err
+}
+
+// learnStage runs one stage of learning in its own span
+func (l *Learn) learnStage(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	attrs := []attribute.KeyValue{AttrNamespace.String(l.namespace())}
+	stageCtx, span := startSpan(ctx, "Learn."+name, attrs...)
+	started := time.Now()
+
+	err := fn(stageCtx)
+	endSpan(ctx, span, "learn_"+name, started, err, attrs...)
+
+	return err
+}
+
+func (l *Learn) learn(ctx context.Context, contents, title string) (int, error) {
 	var chunks []Chunk
 
 	if l.PreProcessBody != nil {
@@ -172,30 +238,58 @@ func (l *Learn) Learn(contents, title string) (int, error) {
 		contents = preProcessed
 	}
 
-	if l.ContentSplitter == nil {
-		l.ContentSplitter = l.CreateChunks // default to sentence-based
-	}
+	// Create chunks for upload, default to sentence-based
+	err := l.learnStage(ctx, "chunk", func(context.Context) error {
+		if l.ContentSplitter != nil {
+			chunks = l.ContentSplitter(contents, title)
+			return nil
+		}
 
-	// Create chunks for upload
-	chunks = l.ContentSplitter(contents, title)
+		var err error
+		chunks, err = l.SplitSentences(contents, title)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	embeddings, err := l.Client.GetEmbeddingsForData(chunks, 100, l.Client.GetEmbeddingModel())
+	var embeddings [][]float32
+	err = l.learnStage(ctx, "embed", func(ctx context.Context) error {
+		var err error
+		embeddings, err = embedData(ctx, l.Client, chunks, 100, l.Client.GetEmbeddingModel())
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("error getting embeddings: %v", err)
+		return 0, fmt.Errorf("error getting embeddings: %w", err)
 	}
 
-	log.Printf("[learn] title: %s", title)
-	log.Printf("[learn] total chunks: %d", len(chunks))
-	log.Printf("[learn] total embeddings: %d", len(embeddings))
+	logger := loggerOr(l.Logger)
+	logger.Info("learning", "title", title, "namespace", l.namespace(), "chunks", len(chunks),
+		"embeddings", len(embeddings))
 	if len(embeddings) == 0 {
-		log.Println("no embeddings in this data, skipping")
+		logger.Warn("no embeddings in this data, skipping", "title", title, "namespace", l.namespace())
 		return 0, nil
 	}
 
 	// Send the embeddings to memory
-	err = l.Memory.UploadEmbeddings(embeddings, chunks)
+	err = l.learnStage(ctx, "upload", func(context.Context) error {
+		return l.Memory.UploadEmbeddings(embeddings, chunks)
+	})
 	if err != nil {
-		return 0, fmt.Errorf("error upserting embeddings to memory: %v", err)
+		return 0, fmt.Errorf("error upserting embeddings to memory: %w", err)
+	}
+
+	if l.Keywords != nil {
+		err = l.learnStage(ctx, "index", func(context.Context) error {
+			return l.Keywords.IndexChunks(l.namespace(), chunks)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error indexing keywords: %w", err)
+		}
+	}
+
+	if l.ResponseCache != nil {
+		l.ResponseCache.InvalidateNamespace(l.namespace())
 	}
 
 	return len(embeddings), nil
@@ -204,9 +298,19 @@ func (l *Learn) Learn(contents, title string) (int, error) {
 // FromFile processes a file to learn into an OpenAI memory store, returns number of embeddings
 // created and an error if failed
 func (l *Learn) FromFile(path string) (int, error) {
+	title, contents, err := l.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.Learn(contents, title)
+}
+
+// ReadFile extracts the title and text of a text, markdown or PDF file, as FromFile does before learning it
+func (l *Learn) ReadFile(path string) (string, string, error) {
 	ext, supported := l.ExtensionSupported(path)
 	if !supported {
-		return 0, fmt.Errorf("file format is not supported")
+		return "", "", fmt.Errorf("file format is not supported")
 	}
 
 	var contents, title string
@@ -221,15 +325,56 @@ func (l *Learn) FromFile(path string) (int, error) {
 		title, contents, err = l.ProcessPDFFile(path)
 	}
 
+	return title, contents, err
+}
+
+// FromURL downloads a web page, PDF, markdown or text document and learns it with the URL as its title, returns
+// number of embeddings created
+func (l *Learn) FromURL(url string) (int, error) {
+	client := l.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultFetchTimeout}
+	}
+
+	resp, err := client.Get(url)
 	if err != nil {
 		return 0, err
 	}
+	defer resp.Body.Close()
 
-	return l.Learn(contents, title)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	var contents string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		contents, _, err = docconv.ConvertHTML(resp.Body, true)
+	case mediaType == "application/pdf":
+		contents, _, err = docconv.ConvertPDF(resp.Body)
+	case mediaType == "text/markdown" || strings.HasSuffix(url, ".md"):
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		contents = stripmd.Strip(string(body))
+	case strings.HasPrefix(mediaType, "text/"):
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		contents = string(body)
+	default:
+		return 0, fmt.Errorf("%s: content type %q is not supported", url, mediaType)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return l.Learn(strings.TrimSpace(contents), url)
 }
 
 func (l *Learn) CreateChunksCharacterBased(fileContent, title string) []Chunk {
-	log.Println("starting character-based chunk generator")
+	loggerOr(l.Logger).Debug("starting character-based chunk generator", "title", title)
 	newData := make([]Chunk, 0)
 
 	c := 0
@@ -247,7 +392,7 @@ func (l *Learn) CreateChunksCharacterBased(fileContent, title string) []Chunk {
 
 		if c == l.ChunkSize || (c < l.ChunkSize && si == len(fileContent)-1) {
 			//TODO: Should the chunks be a forced size of the max limit?
-			if l.Client.CheckTokenLimit(text, l.Model, l.TokenLimit) {
+			if l.Client.CheckTokenLimit(text, l.Model, l.tokenLimit()) {
 				// only write chunks that are ok
 				newData = append(newData, Chunk{
 					Start: start,
@@ -267,7 +412,7 @@ func (l *Learn) CreateChunksCharacterBased(fileContent, title string) []Chunk {
 					}
 				}
 			} else {
-				log.Println("chunk size too large, skipping chunk")
+				loggerOr(l.Logger).Warn("chunk size too large, skipping chunk", "title", title, "start", start)
 			}
 
 			text = ""
@@ -281,12 +426,26 @@ func (l *Learn) CreateChunksCharacterBased(fileContent, title string) []Chunk {
 	return newData
 }
 
-// CreateChunks generates uploadable chunks to send to a memory store
+// CreateChunks generates uploadable chunks to send to a memory store, content that cannot be split into sentences
+// is logged and yields no chunks
+//
+// Deprecated: use SplitSentences, which returns the error
 func (l *Learn) CreateChunks(fileContent, title string) []Chunk {
-	log.Println("starting sentence-based chunk generator")
+	chunks, err := l.SplitSentences(fileContent, title)
+	if err != nil {
+		loggerOr(l.Logger).Error("failed to split content into sentences", "title", title, "error", err)
+		return make([]Chunk, 0)
+	}
+
+	return chunks
+}
+
+// SplitSentences generates uploadable chunks of ChunkSize sentences to send to a memory store
+func (l *Learn) SplitSentences(fileContent, title string) ([]Chunk, error) {
+	loggerOr(l.Logger).Debug("starting sentence-based chunk generator", "title", title)
 	doc, err := prose.NewDocument(fileContent)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to split %s into sentences: %w", title, err)
 	}
 
 	sentences := doc.Sentences()
@@ -308,13 +467,13 @@ func (l *Learn) CreateChunks(fileContent, title string) []Chunk {
 			if l.PreProcessChunk != nil {
 				preProcessed, err := l.PreProcessChunk(toWrite)
 				if err != nil {
-					log.Printf("[createChunks] failed to preprocess: %v", err)
+					loggerOr(l.Logger).Warn("failed to preprocess chunk", "title", title, "error", err)
 					preProcessed = toWrite
 				}
 
 				toWrite = preProcessed
 			}
-			if l.Client.CheckTokenLimit(toWrite, l.Model, l.TokenLimit) {
+			if l.Client.CheckTokenLimit(toWrite, l.Model, l.tokenLimit()) {
 				// only write chunks that are ok
 				newData = append(newData, Chunk{
 					Start: start,
@@ -336,7 +495,7 @@ func (l *Learn) CreateChunks(fileContent, title string) []Chunk {
 					//tailTxt = "++++BEGIN++++" + tailTxt + "++++END++++"
 				}
 			} else {
-				log.Println("chunk size too large, skipping chunk")
+				loggerOr(l.Logger).Warn("chunk size too large, skipping chunk", "title", title, "start", start)
 			}
 
 			text = ""
@@ -347,5 +506,5 @@ func (l *Learn) CreateChunks(fileContent, title string) []Chunk {
 		start = end + 1
 	}
 
-	return newData
+	return newData, nil
 }
@@ -0,0 +1,76 @@
+package botMaker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func newLearn() (*botMaker.Learn, *botmakertest.Storage) {
+	store := botmakertest.NewStorage("capitals")
+	return &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store,
+		Client: botmakertest.NewClient(), Logger: botMaker.NopLogger{}}, store
+}
+
+func TestFromURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/capitals.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("Berlin is the capital of Germany. Paris is the capital of France."))
+		case "/capitals.md":
+			w.Header().Set("Content-Type", "text/markdown")
+			w.Write([]byte("# Italy\n\n**Rome** is the capital of Italy."))
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	learn, store := newLearn()
+	for _, path := range []string{"/capitals.txt", "/capitals.md"} {
+		if n, err := learn.FromURL(srv.URL + path); err != nil || n == 0 {
+			t.Errorf("%s: got %d embeddings and %v", path, n, err)
+		}
+	}
+
+	var texts []string
+	for _, v := range store.Vectors("capitals") {
+		if v.Metadata["title"] == srv.URL+"/capitals.md" {
+			texts = append(texts, v.Metadata["text"])
+		}
+	}
+	if len(texts) != 1 || !strings.Contains(texts[0], "Rome") || strings.Contains(texts[0], "**") {
+		t.Errorf("got %q from the markdown", texts)
+	}
+
+	for _, path := range []string{"/missing.txt", "/image.png"} {
+		if _, err := learn.FromURL(srv.URL + path); err == nil {
+			t.Errorf("%s: learned", path)
+		}
+	}
+}
+
+func TestFromURLTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	learn, _ := newLearn()
+	learn.HTTPClient = &http.Client{Timeout: 10 * time.Millisecond}
+
+	if _, err := learn.FromURL(srv.URL + "/slow.txt"); err == nil {
+		t.Error("no error from a server that never answers")
+	}
+}
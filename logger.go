@@ -0,0 +1,104 @@
+package botMaker
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger is the structured logger the library writes to, args are alternating keys and values. A *slog.Logger
+// satisfies it, so does NewStdLogger
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelNone
+)
+
+// ParseLogLevel reads debug, info, warn, error or none, anything else is info
+func ParseLogLevel(level string) LogLevel {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LevelDebug
+	case "warn", "warning":
+		return LevelWarn
+	case "error":
+		return LevelError
+	case "none", "off":
+		return LevelNone
+	}
+
+	return LevelInfo
+}
+
+// DefaultLogger is used by clients, stores and learners that have no Logger set
+var DefaultLogger Logger = NewStdLogger(LevelInfo)
+
+// StdLogger writes "LEVEL msg key=value ..." lines to a standard library logger
+type StdLogger struct {
+	Level LogLevel
+	Out   *log.Logger
+}
+
+func NewStdLogger(level LogLevel) *StdLogger {
+	return &StdLogger{
+		Level: level,
+		Out:   log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+func (l *StdLogger) Debug(msg string, args ...any) { l.log(LevelDebug, "DEBUG", msg, args) }
+func (l *StdLogger) Info(msg string, args ...any)  { l.log(LevelInfo, "INFO", msg, args) }
+func (l *StdLogger) Warn(msg string, args ...any)  { l.log(LevelWarn, "WARN", msg, args) }
+func (l *StdLogger) Error(msg string, args ...any) { l.log(LevelError, "ERROR", msg, args) }
+
+func (l *StdLogger) log(level LogLevel, name, msg string, args []any) {
+	if level < l.Level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+		}
+	}
+
+	out := l.Out
+	if out == nil {
+		out = log.Default()
+	}
+	out.Print(b.String())
+}
+
+// NopLogger discards everything
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...any) {}
+func (NopLogger) Info(string, ...any)  {}
+func (NopLogger) Warn(string, ...any)  {}
+func (NopLogger) Error(string, ...any) {}
+
+func loggerOr(l Logger) Logger {
+	if l == nil {
+		return DefaultLogger
+	}
+
+	return l
+}
@@ -0,0 +1,86 @@
+package botMaker_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for level, want := range map[string]botMaker.LogLevel{
+		"debug":   botMaker.LevelDebug,
+		"INFO":    botMaker.LevelInfo,
+		"warning": botMaker.LevelWarn,
+		"error":   botMaker.LevelError,
+		"off":     botMaker.LevelNone,
+		"verbose": botMaker.LevelInfo,
+	} {
+		if got := botMaker.ParseLogLevel(level); got != want {
+			t.Errorf("%s: got %v, want %v", level, got, want)
+		}
+	}
+}
+
+func bufferedLogger(level botMaker.LogLevel) (*botMaker.StdLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := botMaker.NewStdLogger(level)
+	l.Out = log.New(&buf, "", 0)
+	return l, &buf
+}
+
+func TestStdLogger(t *testing.T) {
+	l, buf := bufferedLogger(botMaker.LevelWarn)
+	l.Debug("hidden")
+	l.Info("hidden")
+	l.Warn("slow", "latency", "2s", "dangling")
+	l.Error("failed", "error", "boom")
+
+	want := "WARN slow latency=2s !BADKEY=dangling\nERROR failed error=boom\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	none, buf := bufferedLogger(botMaker.LevelNone)
+	none.Error("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("LevelNone wrote %q", buf.String())
+	}
+}
+
+func TestOAIClientLogsToItsLogger(t *testing.T) {
+	srv := botmakertest.NewServer(botmakertest.NewClient("Berlin."), nil)
+	defer srv.Close()
+
+	l, buf := bufferedLogger(botMaker.LevelDebug)
+	cl := srv.NewOAIClient()
+	cl.Logger = l
+
+	ask(t, cl, newSettings(), "What is the capital of Germany?")
+
+	if !strings.Contains(buf.String(), "DEBUG completion bot=capitals") {
+		t.Errorf("the completion was not logged: %q", buf.String())
+	}
+}
+
+func TestLoadConfigFromEnvLogLevel(t *testing.T) {
+	t.Setenv("LLM_API_KEY", "key")
+	t.Setenv("LOG_LEVEL", "error")
+
+	cfg, err := botMaker.LoadConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, ok := cfg.Logger.(*botMaker.StdLogger)
+	if !ok || l.Level != botMaker.LevelError {
+		t.Fatalf("got logger %#v", cfg.Logger)
+	}
+
+	if got := cfg.NewOAIClient().Logger; got != cfg.Logger {
+		t.Errorf("the client logs to %#v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package botMaker_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMain(m *testing.M) {
+	botmakertest.UseOfflineTokenizer()
+	os.Exit(m.Run())
+}
+
+// newSettings returns settings for a bot without memory
+func newSettings() *botMaker.BotSettings {
+	settings := botMaker.NewBotSettings()
+	settings.ID = "capitals"
+	settings.Model = openai.GPT3Dot5Turbo
+	return settings
+}
+
+// ask runs a question through the default prompt
+func ask(t *testing.T, c botMaker.LLMAPIClient, settings *botMaker.BotSettings, body string) (string,
+	*botMaker.BotPrompt) {
+	t.Helper()
+
+	prompt, err := botMaker.LoadBotPrompt("", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt.Body = body
+
+	resp, _, err := c.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, prompt
+}
+
+// match returns a retrieved chunk with the given text
+func match(id, source, text string) botMaker.QueryMatch {
+	return botMaker.QueryMatch{ID: id, Source: source, Metadata: map[string]string{"text": text}}
+}
@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 )
 
 type Storage interface {
@@ -18,10 +20,21 @@ type Storage interface {
 	UploadEmbeddings(embeddings [][]float32, chunks []Chunk) error
 }
 
+// NamespaceStore is implemented by stores that can list, delete and copy whole namespaces
+type NamespaceStore interface {
+	Namespaces() (map[string]int, error) // Vector count per namespace
+	DeleteNamespace(namespace string) error
+	ExportNamespace(namespace string, fn func(PineconeVector) error) error
+	Upsert(namespace string, vectors []PineconeVector) error
+}
+
 type Pinecone struct {
 	APIEndpoint string
 	APIKey      string
 	UUID        string // Used when ingesting data
+
+	IncludeValues bool   // Return match vectors from queries, needed by rerankers such as MMR
+	Logger        Logger // Optional, defaults to DefaultLogger
 }
 
 type PineconeVector struct {
@@ -35,76 +48,41 @@ func HashFileName(filename string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func (p *Pinecone) UploadEmbeddings(embeddings [][]float32, chunks []Chunk) error {
-	// Prepare URL
-	url := p.APIEndpoint + "/vectors/upsert"
+// ChunkID returns the ID used to store the i-th chunk of an upload, it is shared by all stores so that
+// matches for the same chunk can be merged
+func ChunkID(title string, i int) string {
+	return fmt.Sprintf("id-%s-%d", HashFileName(title), i)
+}
+
+// ChunkMetadata returns the metadata stored alongside a chunk
+func ChunkMetadata(chunk Chunk) map[string]string {
+	return map[string]string{
+		"file_name": chunk.Title,
+		"start":     strconv.Itoa(chunk.Start),
+		"end":       strconv.Itoa(chunk.End),
+		"title":     chunk.Title,
+		"text":      chunk.Text,
+	}
+}
 
+func (p *Pinecone) UploadEmbeddings(embeddings [][]float32, chunks []Chunk) error {
 	// Prepare the vectors
 	vectors := make([]PineconeVector, len(embeddings))
 	for i, embedding := range embeddings {
-		vectorID := fmt.Sprintf("id-%s-%d", HashFileName(chunks[i].Title), i)
 		vectors[i] = PineconeVector{
-			ID:     vectorID,
-			Values: embedding,
-			Metadata: map[string]string{
-				"file_name": chunks[i].Title,
-				"start":     strconv.Itoa(chunks[i].Start),
-				"end":       strconv.Itoa(chunks[i].End),
-				"title":     chunks[i].Title,
-				"text":      chunks[i].Text,
-			},
-		}
-	}
-
-	maxVectorsPerRequest := 100
-
-	// Split vectors into smaller chunks and make multiple upsert requests
-	for i := 0; i < len(vectors); i += maxVectorsPerRequest {
-		end := i + maxVectorsPerRequest
-		if end > len(vectors) {
-			end = len(vectors)
-		}
-
-		requestBody, err := json.Marshal(struct {
-			Vectors   []PineconeVector `json:"vectors"`
-			Namespace string           `json:"namespace"`
-		}{
-			Vectors:   vectors[i:end],
-			Namespace: p.UUID,
-		})
-		if err != nil {
-			return err
-		}
-		log.Printf("[pinecone] created upsert with ns (%d -> %d) ns=%v", i, end, p.UUID)
-		// Create HTTP request
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-		if err != nil {
-			return err
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Api-Key", p.APIKey)
-
-		// Send HTTP request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := ioutil.ReadAll(resp.Body)
-			return errors.New(string(body))
+			ID:       ChunkID(chunks[i].Title, i),
+			Values:   embedding,
+			Metadata: ChunkMetadata(chunks[i]),
 		}
 	}
 
-	return nil
+	return p.Upsert(p.UUID, vectors)
 }
 
 type PineconeQueryRequest struct {
 	TopK            int                 `json:"topK"`
 	IncludeMetadata bool                `json:"includeMetadata"`
+	IncludeValues   bool                `json:"includeValues,omitempty"`
 	Namespace       string              `json:"namespace"`
 	Queries         []PineconeQueryItem `json:"queries"`
 }
@@ -117,6 +95,8 @@ type QueryMatch struct {
 	ID       string            `json:"id"`
 	Score    float32           `json:"score"` // Use "score" instead of "distance"
 	Metadata map[string]string `json:"metadata"`
+	Values   []float32         `json:"values,omitempty"`
+	Source   string            `json:"source,omitempty"` // Set when a bot retrieves from several sources
 }
 
 type PineconeQueryResponseResult struct {
@@ -132,6 +112,7 @@ func (p *Pinecone) Retrieve(questionEmbedding []float32, topK int, uuid string)
 	requestBody, _ := json.Marshal(PineconeQueryRequest{
 		TopK:            topK,
 		IncludeMetadata: true,
+		IncludeValues:   p.IncludeValues,
 		Namespace:       uuid,
 		Queries: []PineconeQueryItem{
 			{
@@ -140,8 +121,8 @@ func (p *Pinecone) Retrieve(questionEmbedding []float32, topK int, uuid string)
 		},
 	})
 
-	// log.Println("[retrieve] Querying pinecone namespace:", uuid)
 	// Send the Pinecone query request
+	started := time.Now()
 	pineconeIndexURL := p.APIEndpoint + "/query"
 	req, _ := http.NewRequest("POST", pineconeIndexURL, bytes.NewBuffer(requestBody))
 	req.Header.Set("Content-Type", "application/json")
@@ -150,19 +131,202 @@ func (p *Pinecone) Retrieve(questionEmbedding []float32, topK int, uuid string)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &StorageError{Op: "query", Namespace: uuid, Err: err}
 	}
 	defer resp.Body.Close()
 
 	// Parse the Pinecone query response
 	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StorageError{Op: "query", Namespace: uuid, Err: errors.New(string(body))}
+	}
+
 	var pineconeQueryResponse PineconeQueryResponse
-	json.Unmarshal(body, &pineconeQueryResponse)
+	if err := json.Unmarshal(body, &pineconeQueryResponse); err != nil {
+		return nil, &StorageError{Op: "query", Namespace: uuid, Err: err}
+	}
 
 	// Check if there are any results and return the matches
+	var matches []QueryMatch
 	if len(pineconeQueryResponse.Results) > 0 {
-		return pineconeQueryResponse.Results[0].Matches, nil
+		matches = pineconeQueryResponse.Results[0].Matches
+	}
+
+	loggerOr(p.Logger).Debug("pinecone query", "namespace", uuid, "top_k", topK, "matches", len(matches),
+		"latency", time.Since(started))
+
+	return matches, nil
+}
+
+// request sends a JSON request to the index and decodes the response into out, if it is not nil
+func (p *Pinecone) request(op, namespace, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, p.APIEndpoint+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", p.APIKey)
+
+	started := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &StorageError{Op: op, Namespace: namespace, Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &StorageError{Op: op, Namespace: namespace, Err: err}
 	}
 
-	return nil, nil
+	if resp.StatusCode != http.StatusOK {
+		return &StorageError{Op: op, Namespace: namespace, Err: errors.New(string(respBody))}
+	}
+
+	loggerOr(p.Logger).Debug("pinecone "+op, "namespace", namespace, "latency", time.Since(started))
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &StorageError{Op: op, Namespace: namespace, Err: err}
+	}
+
+	return nil
+}
+
+// Upsert writes vectors into a namespace, in batches of 100
+func (p *Pinecone) Upsert(namespace string, vectors []PineconeVector) error {
+	maxVectorsPerRequest := 100
+
+	for i := 0; i < len(vectors); i += maxVectorsPerRequest {
+		end := i + maxVectorsPerRequest
+		if end > len(vectors) {
+			end = len(vectors)
+		}
+
+		err := p.request("upsert", namespace, http.MethodPost, "/vectors/upsert", struct {
+			Vectors   []PineconeVector `json:"vectors"`
+			Namespace string           `json:"namespace"`
+		}{
+			Vectors:   vectors[i:end],
+			Namespace: namespace,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type PineconeIndexStats struct {
+	Dimension        int                               `json:"dimension"`
+	TotalVectorCount int                               `json:"totalVectorCount"`
+	Namespaces       map[string]PineconeNamespaceStats `json:"namespaces"`
+}
+
+type PineconeNamespaceStats struct {
+	VectorCount int `json:"vectorCount"`
+}
+
+// Stats returns the index's dimension and vector counts
+func (p *Pinecone) Stats() (*PineconeIndexStats, error) {
+	stats := &PineconeIndexStats{}
+	if err := p.request("stats", "", http.MethodPost, "/describe_index_stats", struct{}{}, stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Namespaces returns the number of vectors in each namespace of the index
+func (p *Pinecone) Namespaces() (map[string]int, error) {
+	stats, err := p.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(stats.Namespaces))
+	for ns, s := range stats.Namespaces {
+		counts[ns] = s.VectorCount
+	}
+
+	return counts, nil
+}
+
+// DeleteNamespace deletes every vector in a namespace
+func (p *Pinecone) DeleteNamespace(namespace string) error {
+	return p.request("delete", namespace, http.MethodPost, "/vectors/delete", map[string]interface{}{
+		"deleteAll": true,
+		"namespace": namespace,
+	}, nil)
+}
+
+type pineconeListResponse struct {
+	Vectors []struct {
+		ID string `json:"id"`
+	} `json:"vectors"`
+	Pagination *struct {
+		Next string `json:"next"`
+	} `json:"pagination"`
+}
+
+type pineconeFetchResponse struct {
+	Vectors map[string]PineconeVector `json:"vectors"`
+}
+
+// ExportNamespace calls fn with every vector in a namespace, values and metadata included. Listing vectors needs a
+// serverless index
+func (p *Pinecone) ExportNamespace(namespace string, fn func(PineconeVector) error) error {
+	token := ""
+	for {
+		q := url.Values{"namespace": {namespace}, "limit": {"100"}}
+		if token != "" {
+			q.Set("paginationToken", token)
+		}
+
+		var list pineconeListResponse
+		if err := p.request("list", namespace, http.MethodGet, "/vectors/list?"+q.Encode(), nil, &list); err != nil {
+			return err
+		}
+
+		if len(list.Vectors) > 0 {
+			fq := url.Values{"namespace": {namespace}}
+			for _, v := range list.Vectors {
+				fq.Add("ids", v.ID)
+			}
+
+			var fetched pineconeFetchResponse
+			if err := p.request("fetch", namespace, http.MethodGet, "/vectors/fetch?"+fq.Encode(), nil,
+				&fetched); err != nil {
+				return err
+			}
+
+			// keep the listing order, the fetch response is a map
+			for _, v := range list.Vectors {
+				if vec, ok := fetched.Vectors[v.ID]; ok {
+					if err := fn(vec); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if list.Pagination == nil || list.Pagination.Next == "" {
+			return nil
+		}
+		token = list.Pagination.Next
+	}
 }
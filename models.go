@@ -0,0 +1,256 @@
+package botMaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+)
+
+type ModelAPI int
+
+const (
+	ModelAPIChat       ModelAPI = iota // Served by the chat completion endpoint
+	ModelAPICompletion                 // Served by the legacy completion endpoint
+	ModelAPIEmbedding                  // Served by the embeddings endpoint
+)
+
+func (a ModelAPI) String() string {
+	switch a {
+	case ModelAPIChat:
+		return "chat"
+	case ModelAPICompletion:
+		return "completion"
+	case ModelAPIEmbedding:
+		return "embedding"
+	}
+
+	return fmt.Sprintf("ModelAPI(%d)", int(a))
+}
+
+// ModelInfo describes a model's limits, tokenizer, endpoint and prices. Prices are in USD per 1000 tokens
+type ModelInfo struct {
+	Name            string
+	API             ModelAPI
+	ContextWindow   int    // Tokens shared between the prompt and the completion
+	MaxOutput       int    // Most tokens the model will generate, 0 means the rest of the context window
+	Encoding        string // tiktoken encoding, e.g. cl100k_base
+	PromptPrice     float64
+	CompletionPrice float64
+	JSONMode        bool // Accepts response_format json_object
+}
+
+var (
+	modelsMu sync.RWMutex
+	models   = make(map[string]ModelInfo)
+)
+
+func init() {
+	for _, m := range []ModelInfo{
+		{Name: openai.GPT3Dot5Turbo, API: ModelAPIChat, ContextWindow: 4096, Encoding: "cl100k_base", PromptPrice: 0.0015, CompletionPrice: 0.002},
+		{Name: openai.GPT3Dot5Turbo0301, API: ModelAPIChat, ContextWindow: 4096, Encoding: "cl100k_base", PromptPrice: 0.0015, CompletionPrice: 0.002},
+		{Name: openai.GPT3Dot5Turbo0613, API: ModelAPIChat, ContextWindow: 4096, Encoding: "cl100k_base", PromptPrice: 0.0015, CompletionPrice: 0.002},
+		{Name: openai.GPT3Dot5Turbo16K, API: ModelAPIChat, ContextWindow: 16385, Encoding: "cl100k_base", PromptPrice: 0.003, CompletionPrice: 0.004},
+		{Name: openai.GPT3Dot5Turbo16K0613, API: ModelAPIChat, ContextWindow: 16385, Encoding: "cl100k_base", PromptPrice: 0.003, CompletionPrice: 0.004},
+		{Name: openai.GPT3Dot5Turbo1106, API: ModelAPIChat, ContextWindow: 16385, MaxOutput: 4096, Encoding: "cl100k_base", PromptPrice: 0.001, CompletionPrice: 0.002, JSONMode: true},
+		{Name: openai.GPT3Dot5TurboInstruct, API: ModelAPICompletion, ContextWindow: 4096, Encoding: "cl100k_base", PromptPrice: 0.0015, CompletionPrice: 0.002},
+		{Name: openai.GPT4, API: ModelAPIChat, ContextWindow: 8192, Encoding: "cl100k_base", PromptPrice: 0.03, CompletionPrice: 0.06},
+		{Name: openai.GPT40314, API: ModelAPIChat, ContextWindow: 8192, Encoding: "cl100k_base", PromptPrice: 0.03, CompletionPrice: 0.06},
+		{Name: openai.GPT40613, API: ModelAPIChat, ContextWindow: 8192, Encoding: "cl100k_base", PromptPrice: 0.03, CompletionPrice: 0.06},
+		{Name: openai.GPT432K, API: ModelAPIChat, ContextWindow: 32768, Encoding: "cl100k_base", PromptPrice: 0.06, CompletionPrice: 0.12},
+		{Name: openai.GPT432K0314, API: ModelAPIChat, ContextWindow: 32768, Encoding: "cl100k_base", PromptPrice: 0.06, CompletionPrice: 0.12},
+		{Name: openai.GPT432K0613, API: ModelAPIChat, ContextWindow: 32768, Encoding: "cl100k_base", PromptPrice: 0.06, CompletionPrice: 0.12},
+		{Name: openai.GPT4TurboPreview, API: ModelAPIChat, ContextWindow: 128000, MaxOutput: 4096, Encoding: "cl100k_base", PromptPrice: 0.01, CompletionPrice: 0.03, JSONMode: true},
+		{Name: openai.GPT4VisionPreview, API: ModelAPIChat, ContextWindow: 128000, MaxOutput: 4096, Encoding: "cl100k_base", PromptPrice: 0.01, CompletionPrice: 0.03},
+		{Name: openai.GPT3TextDavinci003, API: ModelAPICompletion, ContextWindow: 4097, Encoding: "p50k_base", PromptPrice: 0.02, CompletionPrice: 0.02},
+		{Name: openai.GPT3TextDavinci002, API: ModelAPICompletion, ContextWindow: 4097, Encoding: "p50k_base", PromptPrice: 0.02, CompletionPrice: 0.02},
+		{Name: openai.CodexCodeDavinci002, API: ModelAPICompletion, ContextWindow: 8001, Encoding: "p50k_base", PromptPrice: 0.02, CompletionPrice: 0.02},
+		{Name: openai.GPT3Davinci, API: ModelAPICompletion, ContextWindow: 2049, Encoding: "r50k_base", PromptPrice: 0.02, CompletionPrice: 0.02},
+		{Name: openai.GPT3Davinci002, API: ModelAPICompletion, ContextWindow: 16384, Encoding: "cl100k_base", PromptPrice: 0.002, CompletionPrice: 0.002},
+		{Name: openai.GPT3Babbage002, API: ModelAPICompletion, ContextWindow: 16384, Encoding: "cl100k_base", PromptPrice: 0.0004, CompletionPrice: 0.0004},
+		{Name: openai.AdaEmbeddingV2.String(), API: ModelAPIEmbedding, ContextWindow: 8191, Encoding: "cl100k_base", PromptPrice: 0.0001},
+	} {
+		models[m.Name] = m
+	}
+}
+
+// RegisterModel adds or replaces a model in the registry, use it for models the library doesn't know about yet
+// and for fine-tuned models with their own prices
+func RegisterModel(info ModelInfo) error {
+	if info.Name == "" {
+		return fmt.Errorf("model name is required")
+	}
+
+	if info.ContextWindow <= 0 {
+		return fmt.Errorf("model %s: context window must be positive", info.Name)
+	}
+
+	if info.MaxOutput < 0 || info.MaxOutput > info.ContextWindow {
+		return fmt.Errorf("model %s: max output must be between 0 and the context window", info.Name)
+	}
+
+	if info.Encoding == "" {
+		info.Encoding = "cl100k_base"
+	}
+
+	if _, err := tiktoken.GetEncoding(info.Encoding); err != nil {
+		return fmt.Errorf("model %s: %w", info.Name, err)
+	}
+
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	models[info.Name] = info
+
+	return nil
+}
+
+// LookupModel returns a model from the registry, fine-tuned models (ft:gpt-3.5-turbo-0613:org::id or
+// davinci:ft-org-date) fall back to their base model unless they were registered themselves
+func LookupModel(name string) (ModelInfo, bool) {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	if m, ok := models[name]; ok {
+		return m, true
+	}
+
+	base := strings.TrimPrefix(name, "ft:")
+	if i := strings.Index(base, ":"); i > 0 {
+		if m, ok := models[base[:i]]; ok {
+			m.Name = name
+			return m, true
+		}
+	}
+
+	return ModelInfo{}, false
+}
+
+// Models returns the names of every registered model
+func Models() []string {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ModelEncoding returns the tokenizer for a model, from the registry or, for unregistered models, tiktoken's own
+// model table and cl100k_base for models tiktoken doesn't know either
+func ModelEncoding(model string) (*tiktoken.Tiktoken, error) {
+	if m, ok := LookupModel(model); ok {
+		return tiktoken.GetEncoding(m.Encoding)
+	}
+
+	if tke, err := tiktoken.EncodingForModel(model); err == nil {
+		return tke, nil
+	}
+
+	return tiktoken.GetEncoding("cl100k_base")
+}
+
+// Cost returns the price in USD of a request to the model
+func (m ModelInfo) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*m.PromptPrice + float64(completionTokens)*m.CompletionPrice) / 1000
+}
+
+// ModelInfo returns the registry entry for the bot's model
+func (s *BotSettings) ModelInfo() (ModelInfo, error) {
+	m, ok := LookupModel(s.Model)
+	if !ok {
+		return ModelInfo{}, fmt.Errorf("%w: unknown model %q, add it with RegisterModel", ErrConfig, s.Model)
+	}
+
+	return m, nil
+}
+
+// Validate rejects settings the model cannot serve before any API is called. Unregistered models are let through
+// as chat models with a 4096 token context window and the cl100k_base tokenizer, so new models work before they
+// are registered, only their limits cannot be checked
+func (s *BotSettings) Validate() error {
+	if s.TokenLimit < 0 || s.MaxTokens < 0 {
+		return fmt.Errorf("%w: token limits cannot be negative", ErrConfig)
+	}
+
+	m, err := s.ModelInfo()
+	if err != nil {
+		return nil
+	}
+
+	if m.API == ModelAPIEmbedding {
+		return fmt.Errorf("%w: model %s is an embedding model and cannot complete prompts", ErrConfig, s.Model)
+	}
+
+	if s.TokenLimit > m.ContextWindow {
+		return fmt.Errorf("%w: token limit %d is larger than the %d token context window of %s", ErrConfig, s.TokenLimit,
+			m.ContextWindow, s.Model)
+	}
+
+	if s.MaxTokens > m.ContextWindow {
+		return fmt.Errorf("%w: max tokens %d is larger than the %d token context window of %s", ErrConfig, s.MaxTokens,
+			m.ContextWindow, s.Model)
+	}
+
+	return nil
+}
+
+// tokenLimit returns the most tokens a prompt may use, TokenLimit or the model's context window when it is 0
+func (s *BotSettings) tokenLimit() int {
+	if s.TokenLimit > 0 {
+		return s.TokenLimit
+	}
+
+	if m, ok := LookupModel(s.Model); ok {
+		return m.ContextWindow
+	}
+
+	return 4096
+}
+
+// completionTokens returns the tokens left for the completion after a prompt of promptTokens, MaxTokens (or the
+// context window when it is 0) minus the prompt, capped at the model's max output and MaxCompletionTokens
+func (s *BotSettings) completionTokens(promptTokens int) int {
+	m, known := LookupModel(s.Model)
+
+	total := s.MaxTokens
+	if total == 0 {
+		total = 4096
+		if known {
+			total = m.ContextWindow
+		}
+	}
+
+	tokens := total - promptTokens
+	if known && m.MaxOutput > 0 && tokens > m.MaxOutput {
+		tokens = m.MaxOutput
+	}
+
+	if s.MaxCompletionTokens > 0 && tokens > s.MaxCompletionTokens {
+		tokens = s.MaxCompletionTokens
+	}
+
+	return tokens
+}
+
+// IsChatModel returns true if the model is served by the chat completion API rather than the legacy completion API,
+// unregistered models are assumed to be chat models
+func IsChatModel(model string) bool {
+	if m, ok := LookupModel(model); ok {
+		return m.API == ModelAPIChat
+	}
+
+	return true
+}
+
+// SupportsJSONMode returns true if the model accepts response_format json_object
+func SupportsJSONMode(model string) bool {
+	m, ok := LookupModel(model)
+	return ok && m.JSONMode
+}
@@ -0,0 +1,105 @@
+package botMaker_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestRegisterModel(t *testing.T) {
+	for name, info := range map[string]botMaker.ModelInfo{
+		"no name":        {ContextWindow: 10},
+		"no window":      {Name: "m"},
+		"output":         {Name: "m", ContextWindow: 10, MaxOutput: 11},
+		"unknown tokens": {Name: "m", ContextWindow: 10, Encoding: "nope"},
+	} {
+		if err := botMaker.RegisterModel(info); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	info := botMaker.ModelInfo{Name: "test-local-model", ContextWindow: 2048, MaxOutput: 512, PromptPrice: 1,
+		CompletionPrice: 2}
+	if err := botMaker.RegisterModel(info); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := botMaker.LookupModel("test-local-model")
+	if !ok || got.Encoding != "cl100k_base" || !botMaker.IsChatModel("test-local-model") {
+		t.Errorf("got %+v", got)
+	}
+
+	if cost := got.Cost(1000, 500); math.Abs(cost-2) > 1e-9 {
+		t.Errorf("got cost %v", cost)
+	}
+}
+
+func TestLookupFineTunedModels(t *testing.T) {
+	for _, name := range []string{"ft:gpt-3.5-turbo-0613:acme::abc123", "davinci-002:ft-acme-2023"} {
+		m, ok := botMaker.LookupModel(name)
+		if !ok || m.Name != name {
+			t.Errorf("%s: got %+v, %v", name, m, ok)
+		}
+	}
+
+	if _, ok := botMaker.LookupModel("no-such-model"); ok {
+		t.Error("found an unregistered model")
+	}
+}
+
+func TestModelAPIs(t *testing.T) {
+	if !botMaker.IsChatModel(openai.GPT4) || botMaker.IsChatModel(openai.GPT3Dot5TurboInstruct) {
+		t.Error("chat and completion models were mixed up")
+	}
+
+	if !botMaker.IsChatModel("brand-new-model") {
+		t.Error("unregistered models should be chat models")
+	}
+
+	if !botMaker.SupportsJSONMode(openai.GPT3Dot5Turbo1106) || botMaker.SupportsJSONMode(openai.GPT4) {
+		t.Error("JSON mode support was mixed up")
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	for name, s := range map[string]*botMaker.BotSettings{
+		"negative":  {Model: openai.GPT4, MaxTokens: -1},
+		"embedding": {Model: openai.AdaEmbeddingV2.String()},
+		"limit":     {Model: openai.GPT4, TokenLimit: 100000},
+		"max":       {Model: openai.GPT4, MaxTokens: 100000},
+	} {
+		if err := s.Validate(); !errors.Is(err, botMaker.ErrConfig) {
+			t.Errorf("%s: got %v, want ErrConfig", name, err)
+		}
+	}
+
+	for _, s := range []*botMaker.BotSettings{{Model: openai.GPT4, TokenLimit: 8192}, {Model: "brand-new-model"}} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%s: %v", s.Model, err)
+		}
+	}
+}
+
+func TestCompletionTokensFollowTheRegistry(t *testing.T) {
+	prompt, _ := botMaker.LoadBotPrompt("", botmakertest.NewClient())
+	prompt.Body = "hello"
+
+	settings := newSettings()
+	settings.Model = openai.GPT3Dot5Turbo1106
+	req, err := prompt.AsChatCompletionRequest(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.MaxTokens != 4096 {
+		t.Errorf("got %d, want the model's max output", req.MaxTokens)
+	}
+
+	settings.MaxCompletionTokens = 100
+	if req, _ := prompt.AsChatCompletionRequest(settings); req.MaxTokens != 100 {
+		t.Errorf("got %d, want MaxCompletionTokens", req.MaxTokens)
+	}
+}
@@ -2,13 +2,12 @@ package botMaker
 
 import (
 	"context"
-	"log"
-	"strings"
+	"fmt"
 	"time"
 
-	"github.com/pkoukk/tiktoken-go"
-
 	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type OpenAIResponse struct {
@@ -26,81 +25,148 @@ type LLMAPIClient interface {
 }
 
 // GetContexts will use OpenAI to get vectors for the prompt, then use Memory to retrieve relevant
-// contexts to include in the query prompt
+// contexts to include in the query prompt. Contexts are rebuilt on every call, the ones that made it into
+// the prompt are recorded in b.IncludedContexts and the rest, with the reason, in b.DroppedContexts
 func GetContexts(b *BotPrompt, s *BotSettings, m Storage, c LLMAPIClient) ([]string, error) {
-	if b.ContextToRender == nil {
-		b.ContextToRender = make([]string, 0)
-	}
+	ctx, span := startSpan(b.context(), "GetContexts", AttrBotID.String(s.ID), AttrModel.String(s.Model),
+		AttrTopK.Int(s.RetrievalConfig().TopK))
+	started := time.Now()
+
+	contexts, err := getContexts(ctx, b, s, m, c)
+
+	span.SetAttributes(AttrQueries.Int(len(b.RetrievalQueries)), AttrContexts.Int(len(b.IncludedContexts)),
+		AttrDropped.Int(len(b.DroppedContexts)))
+	meters().contexts.Record(ctx, int64(len(b.IncludedContexts)), metric.WithAttributes(AttrBotID.String(s.ID)))
+	endSpan(ctx, span, "get_contexts", started, err, AttrBotID.String(s.ID))
+
+	return contexts, err
+}
+
+func getContexts(ctx context.Context, b *BotPrompt, s *BotSettings, m Storage, c LLMAPIClient) ([]string, error) {
+	// contexts belong to a single turn
+	b.ContextToRender = make([]string, 0)
+	b.ContextTitles = make([]string, 0)
+	b.IncludedContexts = make([]Context, 0)
+	b.DroppedContexts = make([]DroppedContext, 0)
 
 	promptNoContext, err := b.renderPrompt()
 	if err != nil {
 		return nil, err
 	}
 
-	questionEmbedding, err := c.GetEmbeddingsForPrompt(b.Body, openai.AdaEmbeddingV2)
-	if err != nil {
-		return nil, err
-	}
+	cfg := s.RetrievalConfig()
 
-	// step 2: Query Pinecone using questionEmbedding to get context matches
-	matches, err := m.Retrieve(questionEmbedding, 3, s.ID)
-	if err != nil {
-		//log.Println("[QuestionHandler ERR] Pinecone query error\n", err.Error())
-		return nil, err
+	// step 1: Work out what to search for, follow-ups may need condensing and extra queries improve recall
+	queries := []string{b.Body}
+	if cfg.Rewrite.Enabled() {
+		queries, err = rewriteQueries(ctx, b, s, c, cfg.Rewrite)
+		if err != nil {
+			return nil, err
+		}
 	}
+	b.RetrievalQueries = queries
+
+	// step 2: Query Pinecone (and the keyword index if there is one) in every source to get context
+	// matches for each query
+	sources := sourcesFor(s, m)
+
+	var questionEmbedding []float32
+	perQuery := make([][]QueryMatch, 0, len(queries))
+	rejectedIDs := make(map[string]bool)
+	for qi, query := range queries {
+		embedCtx, embedSpan := startSpan(ctx, "EmbedQuery", AttrModel.String(cfg.EmbeddingModel.String()))
+		started := time.Now()
+		embedding, err := embedPrompt(embedCtx, c, query, cfg.EmbeddingModel)
+		endSpan(ctx, embedSpan, "embed_query", started, err, AttrModel.String(cfg.EmbeddingModel.String()))
+		if err != nil {
+			return nil, err
+		}
 
-	//log.Println("[GetContexts] Got matches from Pinecone:", matches)
+		if qi == 0 {
+			questionEmbedding = embedding
+		}
 
-	// Extract context text and titles from the matches
-	contexts := make([]Context, len(matches))
-	for i, match := range matches {
-		if matches[i].Score < s.MemoryAcceptScore {
-			continue
+		matches, rejected, err := retrieveFederated(ctx, sources, s, query, embedding, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		perQuery = append(perQuery, matches)
+		for i := range rejected {
+			key := rejected[i].Source + "/" + rejected[i].ID
+			if rejectedIDs[key] {
+				continue
+			}
+			rejectedIDs[key] = true
+
+			b.DroppedContexts = append(b.DroppedContexts, DroppedContext{
+				Context: NewContextFromMatch(&rejected[i]),
+				Reason:  DroppedBelowMinScore,
+			})
 		}
-		contexts[i].Text = match.Metadata["text"]
-		contexts[i].Title = match.Metadata["title"]
 	}
-	//log.Println("[QuestionHandler] Retrieved context from Pinecone:\n", contexts)
 
-	// step 3: Structure the prompt with a context section + question,
-	//using top x results from pinecone as the context
-	contextTexts := make([]string, len(contexts))
-	contextTitles := make([]string, len(contexts))
-	for i, _ := range contexts {
-		contextTexts[i] = contexts[i].Text
-		contextTitles[i] = contexts[i].Title
+	matches := perQuery[0]
+	if len(perQuery) > 1 {
+		matches = FuseRRF(0, perQuery, nil)
+		if len(matches) > cfg.CandidatePool {
+			matches = matches[:cfg.CandidatePool]
+		}
 	}
 
-	// So we can reference them elsewhere
-	b.ContextTitles = contextTitles
+	// rerank the candidate pool down to the ones we will try to fit into the prompt
+	candidates := matches
+	if s.Reranker != nil {
+		rerankCtx, rerankSpan := startSpan(ctx, "Rerank", AttrCandidates.Int(len(matches)))
+		started := time.Now()
+		candidates, err = rerank(rerankCtx, s.Reranker, queries[0], questionEmbedding, matches, len(matches))
+		endSpan(ctx, rerankSpan, "rerank", started, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// step 3: Pack contexts in score order into the share of the token limit they are allowed
+	tke, err := ModelEncoding(s.Model)
+	if err != nil {
+		return nil, err
+	}
 
-	// Count tokens for the question without context
-	tke, err := tiktoken.EncodingForModel(s.Model)
-	questionTokens := tke.Encode(promptNoContext, nil, nil)
-	currentTokenCount := len(questionTokens)
+	questionTokens := len(tke.Encode(promptNoContext, nil, nil))
+	budget := cfg.ContextBudget(s.tokenLimit())
+	if remaining := s.tokenLimit() - questionTokens; remaining < budget {
+		budget = remaining
+	}
 
-	for i, _ := range contextTexts {
-		// Count tokens for the current context
-		contextTokens := tke.Encode(contextTexts[i], nil, nil)
-		currentTokenCount += len(contextTokens)
+	included, dropped := PackContexts(candidates, cfg, budget, sourceQuotas(sources), func(text string) int {
+		return len(tke.Encode(text, nil, nil))
+	})
 
-		if currentTokenCount >= (s.TokenLimit - len(questionTokens)) {
-			break
-		} else if i == len(contextTexts)-1 {
-			b.ContextToRender = append(b.ContextToRender,
-				strings.Trim(strings.ReplaceAll(
-					contextTexts[i], "\n", " "), " "))
-		}
+	b.DroppedContexts = append(b.DroppedContexts, dropped...)
+	b.IncludedContexts = included
+	for i := range included {
+		b.ContextToRender = append(b.ContextToRender, included[i].Text)
+		b.ContextTitles = append(b.ContextTitles, included[i].Title)
 	}
 
 	return b.ContextToRender, nil
 }
 
 type OAIClient struct {
-	Client *openai.Client
+	Client         *openai.Client
+	EmbeddingCache EmbeddingCache // Optional, embeddings are looked up here before calling the API
+	ResponseCache  *ResponseCache // Optional, completions are looked up here before calling the API
+	Usage          *UsageTracker  // Optional, records tokens and cost of every call and enforces budgets
+	Logger         Logger         // Optional, defaults to DefaultLogger
+
+	usageBot     string
+	usageSession string
+	skipBudget   bool // Set while looking up a cached response, see callCompletionAPI
 }
 
-func NewOAIClient(key string) LLMAPIClient {
+// NewOAIClient returns a client for the OpenAI API. It returns *OAIClient rather than LLMAPIClient so that caches,
+// usage tracking and the logger can be set on it, code that stored the result in an LLMAPIClient still compiles
+func NewOAIClient(key string) *OAIClient {
 	return &OAIClient{
 		Client: openai.NewClient(key),
 	}
@@ -108,16 +174,15 @@ func NewOAIClient(key string) LLMAPIClient {
 
 func (c *OAIClient) CheckTokenLimit(text, model string, tokenLimit int) bool {
 	// Get tiktoken encoding for the model
-	tke, err := tiktoken.EncodingForModel(model)
+	tke, err := ModelEncoding(model)
 	if err != nil {
 		return false
 	}
 
 	// Count tokens for the question
-	questionTokens := tke.Encode(text, nil, nil)
-	currentTokenCount := len(questionTokens)
+	currentTokenCount := len(tke.Encode(text, nil, nil))
 
-	log.Printf("[token count]: %d", len(questionTokens))
+	loggerOr(c.Logger).Debug("token count", "model", model, "tokens", currentTokenCount, "limit", tokenLimit)
 
 	if currentTokenCount >= tokenLimit {
 		return false
@@ -126,105 +191,326 @@ func (c *OAIClient) CheckTokenLimit(text, model string, tokenLimit int) bool {
 	return true
 }
 
+// ForSession returns a copy of the client that attributes the usage of its calls, embeddings included, to a bot ID
+// and session. CallCompletionAPI does this for every prompt using BotSettings.ID and BotPrompt.Session
+func (c *OAIClient) ForSession(botID, session string) *OAIClient {
+	scoped := *c
+	scoped.usageBot = botID
+	scoped.usageSession = session
+	return &scoped
+}
+
+func (c *OAIClient) recordUsage(model string, usage openai.Usage, embedding bool) {
+	if c.Usage == nil {
+		return
+	}
+
+	r := UsageRecord{
+		BotID:   c.usageBot,
+		Session: c.usageSession,
+		Model:   model,
+	}
+
+	if embedding {
+		r.EmbeddingTokens = usage.PromptTokens
+	} else {
+		r.PromptTokens = usage.PromptTokens
+		r.CompletionTokens = usage.CompletionTokens
+	}
+
+	c.Usage.Record(r)
+}
+
 func (c *OAIClient) GetEmbeddingModel() openai.EmbeddingModel {
 	return openai.AdaEmbeddingV2
 }
 
 func (c *OAIClient) CallCompletionAPI(settings *BotSettings, prompt *BotPrompt) (string, int, error) {
-	var assistantMessage string
-	var tokens int
-	var err error
+	attrs := []attribute.KeyValue{AttrBotID.String(settings.ID), AttrModel.String(settings.Model)}
+	ctx, span := startSpan(prompt.context(), "CallCompletionAPI", attrs...)
+	started := time.Now()
 
-	if settings.Model == openai.GPT3TextDavinci003 || settings.Model == openai.CodexCodeDavinci002 || settings.Model == openai.GPT3Davinci {
-		assistantMessage, tokens, err = c.useCompletionAPI(prompt, settings)
-	} else {
-		assistantMessage, tokens, err = c.useChatCompletionAPI(prompt, settings)
+	resp, usage, cacheHit, err := c.callCompletionAPI(ctx, settings, prompt)
+
+	span.SetAttributes(AttrCacheHit.Bool(cacheHit))
+	if cacheHit {
+		meters().cacheHits.Add(ctx, 1, metric.WithAttributes(attrs...))
 	}
+	if err == nil && !cacheHit {
+		recordTokens(ctx, span, settings.Model, "prompt", usage.PromptTokens)
+		recordTokens(ctx, span, settings.Model, "completion", usage.CompletionTokens)
+	}
+	endSpan(ctx, span, "completion", started, err, attrs...)
 
-	return assistantMessage, tokens, err
+	prompt.Usage = usage
+	if err != nil {
+		return "", 0, err
+	}
+
+	return resp, usage.TotalTokens, nil
 }
 
-func (c *OAIClient) useChatCompletionAPI(prompt *BotPrompt, s *BotSettings) (string, int, error) {
-	cp, err := prompt.AsChatCompletionRequest(s)
+// forPrompt validates the settings and returns the client to make the call with, scoped
+// to the bot and session when usage is tracked. restore undoes the scoping of the prompt's client
+func (c *OAIClient) forPrompt(settings *BotSettings, prompt *BotPrompt) (*OAIClient, func(), error) {
+	if err := settings.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if c.Usage == nil {
+		return c, func() {}, nil
+	}
+
+	// attribute the embeddings made while retrieving contexts to this bot and session as well
+	session := prompt.Session
+	if session == "" {
+		session = c.usageSession
+	}
+
+	scoped := c.ForSession(settings.ID, session)
+	if prompt.OAIClient != LLMAPIClient(c) {
+		return scoped, func() {}, nil
+	}
+
+	prompt.OAIClient = scoped
+	return scoped, func() { prompt.OAIClient = c }, nil
+}
+
+func (c *OAIClient) callCompletionAPI(ctx context.Context, settings *BotSettings,
+	prompt *BotPrompt) (string, openai.Usage, bool, error) {
+	c, restore, err := c.forPrompt(settings, prompt)
 	if err != nil {
-		return "", 0, err
+		return "", openai.Usage{}, false, err
+	}
+	defer restore()
+	defer prompt.withContext(ctx)()
+
+	cache := c.responseCache(settings)
+	scope := ResponseCacheScope(settings)
+
+	// answers already in the cache are served once the bot has spent its budget, so the budget is checked after a
+	// miss. The embeddings made while looking the answer up are recorded but not refused
+	if cache == nil {
+		if err := c.checkBudget(settings.ID); err != nil {
+			return "", openai.Usage{}, false, err
+		}
+	} else if c.Usage != nil {
+		c.skipBudget = true
+		defer func() { c.skipBudget = false }()
+	}
+
+	// semantic hits skip retrieval and rendering altogether, so ContextToRender stays empty. Only questions without
+	// history are looked up and cached, a follow-up such as "and the other one?" depends on the turns before it
+	var questionEmbedding []float32
+	if cache != nil && cache.Mode == ResponseCacheSemantic && len(prompt.History) == 0 {
+		var err error
+		questionEmbedding, err = c.getEmbeddingsForPrompt(ctx, prompt.Body, settings.RetrievalConfig().EmbeddingModel)
+		if err != nil {
+			return "", openai.Usage{}, false, err
+		}
+
+		if resp, ok := cache.GetSimilar(scope, questionEmbedding); ok {
+			loggerOr(c.Logger).Debug("semantic response cache hit", "bot", settings.ID, "model", settings.Model)
+			return resp, openai.Usage{}, true, nil
+		}
+	}
+
+	var request interface{}
+	if IsChatModel(settings.Model) {
+		request, err = prompt.AsChatCompletionRequest(settings)
+	} else {
+		request, err = prompt.AsCompletionRequest(settings)
 	}
 
+	if err != nil {
+		return "", openai.Usage{}, false, err
+	}
+
+	key := ""
+	if cache != nil && cache.Mode == ResponseCacheExact {
+		key, err = RequestCacheKey(request)
+		if err != nil {
+			return "", openai.Usage{}, false, err
+		}
+
+		if resp, ok := cache.Get(scope, key); ok {
+			loggerOr(c.Logger).Debug("response cache hit", "bot", settings.ID, "model", settings.Model)
+			return resp, openai.Usage{}, true, nil
+		}
+	}
+
+	if cache != nil {
+		if err := c.checkBudget(settings.ID); err != nil {
+			return "", openai.Usage{}, false, err
+		}
+	}
+
+	var assistantMessage string
+	var usage openai.Usage
+
+	requestCtx, requestSpan := startSpan(ctx, "CompletionRequest", AttrModel.String(settings.Model))
+	started := time.Now()
+	switch r := request.(type) {
+	case *openai.ChatCompletionRequest:
+		assistantMessage, usage, err = c.useChatCompletionAPI(requestCtx, r)
+	case *openai.CompletionRequest:
+		assistantMessage, usage, err = c.useCompletionAPI(requestCtx, r)
+	}
+	endSpan(ctx, requestSpan, "completion_request", started, err, AttrModel.String(settings.Model))
+
+	if err != nil {
+		loggerOr(c.Logger).Error("completion failed", "bot", settings.ID, "model", settings.Model, "error", err)
+		return "", openai.Usage{}, false, err
+	}
+
+	loggerOr(c.Logger).Debug("completion", "bot", settings.ID, "model", settings.Model,
+		"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens,
+		"latency", time.Since(started))
+	c.recordUsage(settings.Model, usage, false)
+
+	if cache != nil && (key != "" || questionEmbedding != nil) {
+		cache.Put(scope, key, questionEmbedding, assistantMessage, responseNamespaces(settings))
+	}
+
+	return assistantMessage, usage, false, nil
+}
+
+// checkBudget returns an error wrapping ErrBudgetExceeded if usage is tracked and the bot has spent its budget
+func (c *OAIClient) checkBudget(botID string) error {
+	if c.Usage == nil {
+		return nil
+	}
+
+	return c.Usage.CheckBudget(botID)
+}
+
+// responseCache returns the ResponseCache, or nil for helper settings whose completions must not be cached
+func (c *OAIClient) responseCache(settings *BotSettings) *ResponseCache {
+	if settings.noResponseCache {
+		return nil
+	}
+
+	return c.ResponseCache
+}
+
+func (c *OAIClient) useChatCompletionAPI(ctx context.Context, cp *openai.ChatCompletionRequest) (string,
+	openai.Usage, error) {
 	resp, err := c.Client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		*cp,
 	)
 
 	if err != nil {
-		return "", 0, err
+		return "", openai.Usage{}, err
 	}
 
-	return resp.Choices[0].Message.Content, resp.Usage.TotalTokens, nil
+	return resp.Choices[0].Message.Content, resp.Usage, nil
 }
 
-func (c *OAIClient) useCompletionAPI(prompt *BotPrompt, s *BotSettings) (string, int, error) {
-	comp, err := prompt.AsCompletionRequest(s)
-	if err != nil {
-		return "", 0, nil
-	}
+func (c *OAIClient) useCompletionAPI(ctx context.Context, comp *openai.CompletionRequest) (string, openai.Usage,
+	error) {
 	resp, err := c.Client.CreateCompletion(
-		context.Background(),
+		ctx,
 		*comp,
 	)
 
 	if err != nil {
-		return "", 0, err
+		return "", openai.Usage{}, err
 	}
 
 	// TODO: Check this
-	return resp.Choices[0].Text, resp.Usage.TotalTokens, nil
+	return resp.Choices[0].Text, resp.Usage, nil
 }
 
 func (c *OAIClient) CallEmbeddingAPIWithRetry(texts []string, embedModel openai.EmbeddingModel,
 	maxRetries int) (*openai.EmbeddingResponse, error) {
+	return c.callEmbeddingAPI(context.Background(), texts, embedModel, maxRetries)
+}
+
+func (c *OAIClient) callEmbeddingAPI(parent context.Context, texts []string, embedModel openai.EmbeddingModel,
+	maxRetries int) (*openai.EmbeddingResponse, error) {
+	if !c.skipBudget && c.usageBot != "" {
+		if err := c.checkBudget(c.usageBot); err != nil {
+			return nil, err
+		}
+	}
+
 	var err error
 	var res openai.EmbeddingResponse
 
+	attrs := []attribute.KeyValue{AttrModel.String(embedModel.String())}
 	for i := 0; i < maxRetries; i++ {
-		res, err = c.Client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+		ctx, span := startSpan(parent, "Embeddings", append(attrs, AttrTexts.Int(len(texts)))...)
+		started := time.Now()
+		res, err = c.Client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
 			Input: texts,
 			Model: embedModel,
 		})
 
 		if err == nil {
+			recordTokens(ctx, span, embedModel.String(), "embedding", res.Usage.PromptTokens)
+		}
+		endSpan(ctx, span, "embedding", started, err, attrs...)
+
+		if err == nil {
+			loggerOr(c.Logger).Debug("embeddings", "bot", c.usageBot, "model", embedModel.String(),
+				"texts", len(texts), "tokens", res.Usage.PromptTokens, "latency", time.Since(started))
+			c.recordUsage(embedModel.String(), res.Usage, true)
 			return &res, nil
 		}
 
+		loggerOr(c.Logger).Warn("embedding request failed", "model", embedModel.String(), "attempt", i+1,
+			"error", err)
 		time.Sleep(5 * time.Second)
 	}
 
 	return nil, err
 }
 
-// GetEmbeddingsForData gets embedding vectors for data to be ingested and used for context in queries
+// GetEmbeddingsForData gets embedding vectors for data to be ingested and used for context in queries, chunks that
+// are already in the EmbeddingCache are not sent to the API. The embeddings line up with chunks, a batch that fails
+// fails the call
 func (c *OAIClient) GetEmbeddingsForData(chunks []Chunk, batchSize int,
+	embedModel openai.EmbeddingModel) ([][]float32, error) {
+	return c.getEmbeddingsForData(context.Background(), chunks, batchSize, embedModel)
+}
+
+func (c *OAIClient) getEmbeddingsForData(ctx context.Context, chunks []Chunk, batchSize int,
 	embedModel openai.EmbeddingModel) ([][]float32, error) {
 	embeddings := make([][]float32, 0, len(chunks))
 
 	for i := 0; i < len(chunks); i += batchSize {
 		iEnd := min(len(chunks), i+batchSize)
 
+		embeds := make([][]float32, iEnd-i)
 		texts := make([]string, 0, iEnd-i)
-		for _, chunk := range chunks[i:iEnd] {
+		missing := make([]int, 0, iEnd-i)
+		for j, chunk := range chunks[i:iEnd] {
+			if c.EmbeddingCache != nil {
+				if cached, ok := c.EmbeddingCache.Get(EmbeddingCacheKey(embedModel, chunk.Text)); ok {
+					embeds[j] = cached
+					continue
+				}
+			}
+
 			texts = append(texts, chunk.Text)
+			missing = append(missing, j)
 		}
 
-		log.Printf("[oaiclient] getting embeddings for chunk %d -> %d (of %d)", i, iEnd, len(chunks))
+		if len(texts) > 0 {
+			loggerOr(c.Logger).Debug("getting embeddings", "model", embedModel.String(), "from", i, "to", iEnd,
+				"total", len(chunks))
 
-		res, err := c.CallEmbeddingAPIWithRetry(texts, embedModel, 3)
-		if err != nil {
-			log.Printf("[ERROR] failed to get embeddings ERR: %v, WFILE: %v - SKIPPING", err, chunks[i].Title)
-			continue
-		}
+			// a skipped batch would pair the remaining embeddings with the wrong chunks, so fail the whole upload
+			res, err := c.callEmbeddingAPI(ctx, texts, embedModel, 3)
+			if err != nil {
+				return nil, fmt.Errorf("embedding chunks %d to %d of %s: %w", i, iEnd, chunks[i].Title, err)
+			}
 
-		embeds := make([][]float32, len(res.Data))
-		for i, record := range res.Data {
-			embeds[i] = record.Embedding
+			for k, record := range res.Data {
+				embeds[missing[k]] = record.Embedding
+				c.cacheEmbedding(embedModel, texts[k], record.Embedding)
+			}
 		}
 
 		embeddings = append(embeddings, embeds...)
@@ -235,14 +521,65 @@ func (c *OAIClient) GetEmbeddingsForData(chunks []Chunk, batchSize int,
 
 // GetEmbeddingsForPrompt will return embedding vectors for the prompt
 func (c *OAIClient) GetEmbeddingsForPrompt(text string, embedModel openai.EmbeddingModel) ([]float32, error) {
-	res, err := c.CallEmbeddingAPIWithRetry([]string{text}, embedModel, 3)
+	return c.getEmbeddingsForPrompt(context.Background(), text, embedModel)
+}
+
+func (c *OAIClient) getEmbeddingsForPrompt(ctx context.Context, text string,
+	embedModel openai.EmbeddingModel) ([]float32, error) {
+	if c.EmbeddingCache != nil {
+		if cached, ok := c.EmbeddingCache.Get(EmbeddingCacheKey(embedModel, text)); ok {
+			return cached, nil
+		}
+	}
+
+	res, err := c.callEmbeddingAPI(ctx, []string{text}, embedModel, 3)
 	if err != nil {
 		return nil, err
 	}
 
+	c.cacheEmbedding(embedModel, text, res.Data[0].Embedding)
 	return res.Data[0].Embedding, nil
 }
 
+// embedPrompt is GetEmbeddingsForPrompt traced under ctx when the client is an OAIClient
+func embedPrompt(ctx context.Context, c LLMAPIClient, text string, embedModel openai.EmbeddingModel) ([]float32,
+	error) {
+	if oc, ok := c.(*OAIClient); ok {
+		return oc.getEmbeddingsForPrompt(ctx, text, embedModel)
+	}
+
+	return c.GetEmbeddingsForPrompt(text, embedModel)
+}
+
+// embedData is GetEmbeddingsForData traced under ctx when the client is an OAIClient
+func embedData(ctx context.Context, c LLMAPIClient, chunks []Chunk, batchSize int,
+	embedModel openai.EmbeddingModel) ([][]float32, error) {
+	if oc, ok := c.(*OAIClient); ok {
+		return oc.getEmbeddingsForData(ctx, chunks, batchSize, embedModel)
+	}
+
+	return c.GetEmbeddingsForData(chunks, batchSize, embedModel)
+}
+
+func (c *OAIClient) cacheEmbedding(embedModel openai.EmbeddingModel, text string, embedding []float32) {
+	if c.EmbeddingCache == nil {
+		return
+	}
+
+	if err := c.EmbeddingCache.Set(EmbeddingCacheKey(embedModel, text), embedding); err != nil {
+		loggerOr(c.Logger).Warn("failed to cache embedding", "error", err)
+	}
+}
+
+// EmbeddingCacheStats returns the hit and miss counts of the EmbeddingCache
+func (c *OAIClient) EmbeddingCacheStats() CacheStats {
+	if c.EmbeddingCache == nil {
+		return CacheStats{}
+	}
+
+	return c.EmbeddingCache.Stats()
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,346 @@
+package botMaker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reranker reorders a candidate set of matches returned by Storage.Retrieve and keeps the best topN
+type Reranker interface {
+	Rerank(query string, queryEmbedding []float32, matches []QueryMatch, topN int) ([]QueryMatch, error)
+}
+
+var RERANK_TEMPLATE = `Rate how relevant each numbered passage is to the question on a scale of 0 to 10.
+Reply with one line per passage in the form "<number>: <score>" and nothing else.
+
+Question: {{.Body}}
+{{ range $i, $ctx := .ContextToRender }}
+[{{$i}}] {{$ctx}}
+{{ end }}`
+
+// contextReranker is implemented by rerankers whose calls are traced under the retrieval span
+type contextReranker interface {
+	rerank(ctx context.Context, query string, queryEmbedding []float32, matches []QueryMatch,
+		topN int) ([]QueryMatch, error)
+}
+
+// rerank runs a reranker, under ctx if it supports it
+func rerank(ctx context.Context, r Reranker, query string, queryEmbedding []float32, matches []QueryMatch,
+	topN int) ([]QueryMatch, error) {
+	if cr, ok := r.(contextReranker); ok {
+		return cr.rerank(ctx, query, queryEmbedding, matches, topN)
+	}
+
+	return r.Rerank(query, queryEmbedding, matches, topN)
+}
+
+// LLMReranker asks a language model to judge the relevance of every candidate in a single call
+type LLMReranker struct {
+	Client   LLMAPIClient
+	Settings *BotSettings // Model and sampling for the judge, it runs with HelperSettings
+}
+
+var rerankScoreLine = regexp.MustCompile(`(?m)^\s*\[?(\d+)\]?\s*[:\-]\s*(\d+(?:\.\d+)?)`)
+
+func (r *LLMReranker) Rerank(query string, queryEmbedding []float32, matches []QueryMatch,
+	topN int) ([]QueryMatch, error) {
+	return r.rerank(context.Background(), query, queryEmbedding, matches, topN)
+}
+
+func (r *LLMReranker) rerank(ctx context.Context, query string, _ []float32, matches []QueryMatch,
+	topN int) ([]QueryMatch, error) {
+	if len(matches) == 0 {
+		return matches, nil
+	}
+
+	if r.Client == nil || r.Settings == nil {
+		return nil, fmt.Errorf("%w: LLMReranker needs a Client and Settings", ErrConfig)
+	}
+
+	prompt, err := LoadBotPrompt(RERANK_TEMPLATE, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	prompt.ctx = ctx
+	prompt.Instructions = "You are a search relevance judge."
+	prompt.Body = query
+	for i := range matches {
+		prompt.ContextToRender = append(prompt.ContextToRender,
+			strings.ReplaceAll(matches[i].Metadata["text"], "\n", " "))
+	}
+
+	// the judge must not retrieve contexts or rerank of its own
+	resp, _, err := r.Client.CallCompletionAPI(r.Settings.HelperSettings(), prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	scored := make([]QueryMatch, len(matches))
+	copy(scored, matches)
+	for i := range scored {
+		scored[i].Score = 0
+	}
+
+	for _, line := range rerankScoreLine.FindAllStringSubmatch(resp, -1) {
+		i, err := strconv.Atoi(line[1])
+		if err != nil || i < 0 || i >= len(scored) {
+			continue
+		}
+
+		score, err := strconv.ParseFloat(line[2], 32)
+		if err != nil {
+			continue
+		}
+
+		scored[i].Score = float32(score / 10)
+	}
+
+	return topMatches(scored, topN), nil
+}
+
+// MMRReranker applies maximal marginal relevance so that the kept matches are relevant but not repetitive, it uses
+// match vectors when the store returns them and falls back to term overlap otherwise. Relevance is scaled to [0,1]
+// across the candidates first, so fused rank scores weigh the same as cosine similarities against redundancy
+type MMRReranker struct {
+	Lambda float32 // 1 is pure relevance, towards 0 is diversity, 0 means DefaultMMRLambda
+}
+
+// DefaultMMRLambda weighs relevance and diversity equally
+const DefaultMMRLambda = 0.5
+
+// NewMMRReranker returns an MMRReranker with DefaultMMRLambda
+func NewMMRReranker() *MMRReranker {
+	return &MMRReranker{Lambda: DefaultMMRLambda}
+}
+
+func (r *MMRReranker) Rerank(_ string, queryEmbedding []float32, matches []QueryMatch, topN int) ([]QueryMatch, error) {
+	lambda := r.Lambda
+	if lambda == 0 {
+		lambda = DefaultMMRLambda
+	}
+
+	remaining := make([]QueryMatch, len(matches))
+	copy(remaining, matches)
+	relevances := mmrRelevance(queryEmbedding, remaining)
+
+	selected := make([]QueryMatch, 0, topN)
+	for len(selected) < topN && len(remaining) > 0 {
+		best := 0
+		bestScore := float32(math.Inf(-1))
+		for i := range remaining {
+			relevance := relevances[i]
+
+			var redundancy float32
+			for j := range selected {
+				sim := matchSimilarity(&remaining[i], &selected[j])
+				if sim > redundancy {
+					redundancy = sim
+				}
+			}
+
+			score := lambda*relevance - (1-lambda)*redundancy
+			if score > bestScore {
+				best = i
+				bestScore = score
+			}
+		}
+
+		selected = append(selected, remaining[best])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		relevances = append(relevances[:best], relevances[best+1:]...)
+	}
+
+	return selected, nil
+}
+
+// mmrRelevance returns the relevance of each match min-max scaled to [0,1], from the query's similarity to the match
+// vector when there is one and from the match score otherwise
+func mmrRelevance(queryEmbedding []float32, matches []QueryMatch) []float32 {
+	relevances := make([]float32, len(matches))
+	for i := range matches {
+		relevances[i] = matches[i].Score
+		if len(queryEmbedding) > 0 && len(matches[i].Values) > 0 {
+			relevances[i] = CosineSimilarity(queryEmbedding, matches[i].Values)
+		}
+	}
+
+	if len(relevances) == 0 {
+		return relevances
+	}
+
+	lo, hi := relevances[0], relevances[0]
+	for _, rel := range relevances {
+		if rel < lo {
+			lo = rel
+		}
+		if rel > hi {
+			hi = rel
+		}
+	}
+
+	for i := range relevances {
+		if hi > lo {
+			relevances[i] = (relevances[i] - lo) / (hi - lo)
+		} else {
+			relevances[i] = 1
+		}
+	}
+
+	return relevances
+}
+
+func matchSimilarity(a, b *QueryMatch) float32 {
+	if len(a.Values) > 0 && len(a.Values) == len(b.Values) {
+		return CosineSimilarity(a.Values, b.Values)
+	}
+
+	return TermOverlap(a.Metadata["text"], b.Metadata["text"])
+}
+
+// CrossEncoderReranker scores query/passage pairs with a cross-encoder served over HTTP, the endpoint should accept
+// the text-embeddings-inference /rerank format: {"query": "...", "texts": [...]} -> [{"index": 0, "score": 0.9}]
+type CrossEncoderReranker struct {
+	Endpoint string
+	APIKey   string // Optional, sent as a bearer token
+	Client   *http.Client
+}
+
+type crossEncoderRequest struct {
+	Query string   `json:"query"`
+	Texts []string `json:"texts"`
+}
+
+type crossEncoderScore struct {
+	Index int     `json:"index"`
+	Score float32 `json:"score"`
+}
+
+func (r *CrossEncoderReranker) Rerank(query string, _ []float32, matches []QueryMatch, topN int) ([]QueryMatch, error) {
+	if len(matches) == 0 {
+		return matches, nil
+	}
+
+	texts := make([]string, len(matches))
+	for i := range matches {
+		texts[i] = matches[i].Metadata["text"]
+	}
+
+	requestBody, err := json.Marshal(crossEncoderRequest{Query: query, Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", r.Endpoint, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(body))
+	}
+
+	var scores []crossEncoderScore
+	if err := json.Unmarshal(body, &scores); err != nil {
+		return nil, fmt.Errorf("unexpected cross-encoder response: %v", err)
+	}
+
+	scored := make([]QueryMatch, len(matches))
+	copy(scored, matches)
+	for _, s := range scores {
+		if s.Index >= 0 && s.Index < len(scored) {
+			scored[s.Index].Score = s.Score
+		}
+	}
+
+	return topMatches(scored, topN), nil
+}
+
+// topMatches sorts matches by score and returns the first n
+func topMatches(matches []QueryMatch, n int) []QueryMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+
+	return matches
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors of the same length
+func CosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
+
+// TermOverlap returns the Jaccard similarity of the terms in two texts
+func TermOverlap(a, b string) float32 {
+	ta := make(map[string]bool)
+	for _, t := range Tokenize(a) {
+		ta[t] = true
+	}
+
+	tb := make(map[string]bool)
+	for _, t := range Tokenize(b) {
+		tb[t] = true
+	}
+
+	if len(ta) == 0 && len(tb) == 0 {
+		return 1
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	return float32(shared) / float32(len(ta)+len(tb)-shared)
+}
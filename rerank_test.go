@@ -0,0 +1,76 @@
+package botMaker_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func TestLLMRerankerScoresFromTheJudge(t *testing.T) {
+	client := botmakertest.NewClient("0: 2\n1: 9\n2: 5")
+	r := &botMaker.LLMReranker{Client: client, Settings: newSettings()}
+	matches := []botMaker.QueryMatch{match("a", "", "one"), match("b", "", "two"), match("c", "", "three")}
+
+	ranked, err := r.Rerank("question", nil, matches, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ranked) != 2 || ranked[0].ID != "b" || ranked[1].ID != "c" || ranked[0].Score != 0.9 {
+		t.Errorf("got %+v", ranked)
+	}
+
+	call, _ := client.LastCall()
+	if !strings.Contains(call.Prompt, "[2] three") {
+		t.Errorf("the candidates are not in the prompt: %q", call.Prompt)
+	}
+}
+
+func TestLLMRerankerNeedsSettings(t *testing.T) {
+	r := &botMaker.LLMReranker{Client: botmakertest.NewClient("0: 1")}
+	_, err := r.Rerank("question", nil, []botMaker.QueryMatch{match("a", "", "one")}, 1)
+	if !errors.Is(err, botMaker.ErrConfig) {
+		t.Errorf("got %v, want ErrConfig", err)
+	}
+}
+
+func TestMMRRerankerDefaultsToBalanced(t *testing.T) {
+	// fused hybrid scores are small, they must still count against the redundancy of near duplicates
+	matches := []botMaker.QueryMatch{
+		{ID: "a", Score: 0.033, Metadata: map[string]string{"text": "reset the admin password from settings"}},
+		{ID: "b", Score: 0.032, Metadata: map[string]string{"text": "reset the admin password from settings"}},
+		{ID: "c", Score: 0.031, Metadata: map[string]string{"text": "billing runs monthly"}},
+		{ID: "d", Score: 0.010, Metadata: map[string]string{"text": "the office has a cat"}},
+	}
+
+	for _, r := range []*botMaker.MMRReranker{{}, botMaker.NewMMRReranker()} {
+		ranked, err := r.Rerank("", nil, matches, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ranked) != 2 || ranked[0].ID != "a" || ranked[1].ID != "c" {
+			t.Errorf("lambda %v got %+v, want a then c", r.Lambda, ranked)
+		}
+	}
+
+	relevant, _ := (&botMaker.MMRReranker{Lambda: 1}).Rerank("", nil, matches, 2)
+	if relevant[1].ID != "b" {
+		t.Errorf("pure relevance got %+v", relevant)
+	}
+}
+
+func TestMMRRerankerUsesVectors(t *testing.T) {
+	matches := []botMaker.QueryMatch{
+		{ID: "far", Values: []float32{0, 1}},
+		{ID: "close", Values: []float32{1, 0.1}},
+	}
+
+	ranked, _ := botMaker.NewMMRReranker().Rerank("", []float32{1, 0}, matches, 1)
+	if ranked[0].ID != "close" {
+		t.Errorf("got %+v", ranked)
+	}
+}
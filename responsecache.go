@@ -0,0 +1,262 @@
+package botMaker
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type ResponseCacheMode int
+
+const (
+	// ResponseCacheExact returns a cached response only for an identical rendered request
+	ResponseCacheExact ResponseCacheMode = iota
+	// ResponseCacheSemantic returns a cached response when the question embeds close to a previous one
+	ResponseCacheSemantic
+)
+
+// ResponseCache is an opt-in cache of completions, entries are scoped to a bot (BotSettings.ID and Model) and
+// remember the namespaces they were answered from so they can be dropped when a namespace is re-learned. Semantic
+// lookups compare the question with every entry of the bot's scope, so keep MaxEntries moderate
+type ResponseCache struct {
+	cacheCounter
+	Mode       ResponseCacheMode
+	TTL        time.Duration // 0 means entries never expire
+	Threshold  float32       // Minimum cosine similarity for a semantic hit
+	MaxEntries int           // Entries kept across all scopes, the least recently used go first, 0 is unbounded
+
+	mu     sync.Mutex
+	scopes map[string][]*responseEntry
+	order  *list.List // Entries, most recently used first
+}
+
+type responseEntry struct {
+	scope      string
+	key        string
+	embedding  []float32
+	response   string
+	namespaces []string
+	created    time.Time
+	elem       *list.Element
+}
+
+// DefaultResponseCacheEntries is the MaxEntries of a cache made by NewResponseCache
+const DefaultResponseCacheEntries = 10000
+
+func NewResponseCache(mode ResponseCacheMode, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		Mode:       mode,
+		TTL:        ttl,
+		Threshold:  0.95,
+		MaxEntries: DefaultResponseCacheEntries,
+		scopes:     make(map[string][]*responseEntry),
+		order:      list.New(),
+	}
+}
+
+// ResponseCacheScope returns the scope responses for a bot are cached under
+func ResponseCacheScope(s *BotSettings) string {
+	return s.ID + "\x00" + s.Model
+}
+
+// RequestCacheKey hashes a rendered completion or chat completion request for exact lookups
+func RequestCacheKey(request interface{}) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func (r *ResponseCache) expired(e *responseEntry, now time.Time) bool {
+	return r.TTL > 0 && now.Sub(e.created) > r.TTL
+}
+
+// Get returns the response cached under key in scope
+func (r *ResponseCache) Get(scope, key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range r.scopes[scope] {
+		if e.key == key && !r.expired(e, now) {
+			r.record(true)
+			r.order.MoveToFront(e.elem)
+			return e.response, true
+		}
+	}
+
+	r.record(false)
+	return "", false
+}
+
+// GetSimilar returns the cached response in scope whose question embedding is most similar to embedding, as long
+// as it is at least Threshold
+func (r *ResponseCache) GetSimilar(scope string, embedding []float32) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	best := -1
+	var bestScore float32
+	for i, e := range r.scopes[scope] {
+		if r.expired(e, now) || len(e.embedding) == 0 {
+			continue
+		}
+
+		score := CosineSimilarity(embedding, e.embedding)
+		if score >= r.Threshold && score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+
+	if best < 0 {
+		r.record(false)
+		return "", false
+	}
+
+	r.record(true)
+	e := r.scopes[scope][best]
+	r.order.MoveToFront(e.elem)
+	return e.response, true
+}
+
+// Put caches a response in scope, key is used by exact lookups and embedding by semantic ones. Expired entries are
+// removed and, past MaxEntries, the least recently used ones
+func (r *ResponseCache) Put(scope, key string, embedding []float32, response string, namespaces []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.scopes == nil {
+		r.scopes = make(map[string][]*responseEntry)
+	}
+	if r.order == nil {
+		r.order = list.New()
+	}
+
+	// drop expired entries and any older entry for the same key while we are here
+	now := time.Now()
+	r.removeWhere(func(e *responseEntry) bool {
+		return r.expired(e, now) || (key != "" && e.scope == scope && e.key == key)
+	})
+
+	e := &responseEntry{
+		scope:      scope,
+		key:        key,
+		embedding:  embedding,
+		response:   response,
+		namespaces: namespaces,
+		created:    now,
+	}
+	e.elem = r.order.PushFront(e)
+	r.scopes[scope] = append(r.scopes[scope], e)
+
+	for r.MaxEntries > 0 && r.order.Len() > r.MaxEntries {
+		r.remove(r.order.Back().Value.(*responseEntry))
+	}
+}
+
+// InvalidateNamespace drops every cached response that was answered using contexts from namespace
+func (r *ResponseCache) InvalidateNamespace(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeWhere(func(e *responseEntry) bool {
+		return containsString(e.namespaces, namespace)
+	})
+}
+
+// InvalidateBot drops every cached response for a bot
+func (r *ResponseCache) InvalidateBot(s *BotSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	scope := ResponseCacheScope(s)
+	r.removeWhere(func(e *responseEntry) bool {
+		return e.scope == scope
+	})
+}
+
+// Len returns the number of cached responses, expired ones included until the next Put
+func (r *ResponseCache) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, entries := range r.scopes {
+		n += len(entries)
+	}
+
+	return n
+}
+
+// remove drops a single entry, r.mu must be held
+func (r *ResponseCache) remove(e *responseEntry) {
+	r.order.Remove(e.elem)
+
+	entries := r.scopes[e.scope]
+	for i := range entries {
+		if entries[i] == e {
+			r.scopes[e.scope] = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+
+	if len(r.scopes[e.scope]) == 0 {
+		delete(r.scopes, e.scope)
+	}
+}
+
+// removeWhere drops the entries drop returns true for, r.mu must be held
+func (r *ResponseCache) removeWhere(drop func(e *responseEntry) bool) {
+	for scope, entries := range r.scopes {
+		kept := entries[:0]
+		for _, e := range entries {
+			if !drop(e) {
+				kept = append(kept, e)
+				continue
+			}
+
+			if e.elem != nil {
+				r.order.Remove(e.elem)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(r.scopes, scope)
+		} else {
+			r.scopes[scope] = kept
+		}
+	}
+}
+
+// responseNamespaces returns the namespaces a bot's responses depend on
+func responseNamespaces(s *BotSettings) []string {
+	if s.Memory == nil && len(s.Sources) == 0 {
+		return nil
+	}
+
+	sources := sourcesFor(s, s.Memory)
+	namespaces := make([]string, 0, len(sources))
+	for _, src := range sources {
+		namespaces = append(namespaces, src.Namespace)
+	}
+
+	return namespaces
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
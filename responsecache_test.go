@@ -0,0 +1,181 @@
+package botMaker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestResponseCacheExact(t *testing.T) {
+	cache := botMaker.NewResponseCache(botMaker.ResponseCacheExact, 0)
+	cache.Put("bot", "key", nil, "first", nil)
+	cache.Put("bot", "key", nil, "second", nil)
+
+	if resp, ok := cache.Get("bot", "key"); !ok || resp != "second" {
+		t.Errorf("got %q, %v, want the latest response", resp, ok)
+	}
+
+	if _, ok := cache.Get("other", "key"); ok {
+		t.Error("scopes leaked")
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	cache := botMaker.NewResponseCache(botMaker.ResponseCacheExact, time.Millisecond)
+	cache.Put("bot", "key", nil, "stale", nil)
+	cache.Put("other", "key", nil, "stale", nil)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("bot", "key"); ok {
+		t.Error("an expired entry was served")
+	}
+
+	cache.Put("bot", "fresh", nil, "fresh", nil)
+	if n := cache.Len(); n != 1 {
+		t.Errorf("got %d entries, want the expired ones removed from every scope", n)
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := botMaker.NewResponseCache(botMaker.ResponseCacheExact, 0)
+	cache.MaxEntries = 2
+	cache.Put("a", "one", nil, "1", nil)
+	cache.Put("b", "two", nil, "2", nil)
+	cache.Get("a", "one")
+	cache.Put("a", "three", nil, "3", nil)
+
+	if _, ok := cache.Get("b", "two"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+
+	for _, key := range []string{"one", "three"} {
+		if _, ok := cache.Get("a", key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	if n := cache.Len(); n != 2 {
+		t.Errorf("got %d entries", n)
+	}
+}
+
+func TestResponseCacheSimilar(t *testing.T) {
+	cache := botMaker.NewResponseCache(botMaker.ResponseCacheSemantic, 0)
+	cache.Put("bot", "", []float32{1, 0}, "x axis", nil)
+	cache.Put("bot", "", []float32{0, 1}, "y axis", nil)
+
+	if resp, ok := cache.GetSimilar("bot", []float32{0.1, 1}); !ok || resp != "y axis" {
+		t.Errorf("got %q, %v", resp, ok)
+	}
+
+	if _, ok := cache.GetSimilar("bot", []float32{1, 1}); ok {
+		t.Error("a match below Threshold was served")
+	}
+}
+
+func TestResponseCacheInvalidation(t *testing.T) {
+	cache := botMaker.NewResponseCache(botMaker.ResponseCacheExact, 0)
+	cache.Put("a", "one", nil, "from docs", []string{"docs"})
+	cache.Put("a", "two", nil, "from wiki", []string{"wiki"})
+
+	cache.InvalidateNamespace("docs")
+	if _, ok := cache.Get("a", "one"); ok {
+		t.Error("an answer from the invalidated namespace was served")
+	}
+	if _, ok := cache.Get("a", "two"); !ok {
+		t.Error("an answer from another namespace was dropped")
+	}
+
+	settings := &botMaker.BotSettings{ID: "bot", Model: openai.GPT3Dot5Turbo}
+	cache.Put(botMaker.ResponseCacheScope(settings), "key", nil, "answer", nil)
+	cache.InvalidateBot(settings)
+	if _, ok := cache.Get(botMaker.ResponseCacheScope(settings), "key"); ok {
+		t.Error("InvalidateBot kept the bot's answers")
+	}
+}
+
+func cachedClient(t *testing.T, mode botMaker.ResponseCacheMode, responses ...string) (*botmakertest.Server,
+	*botMaker.OAIClient) {
+	t.Helper()
+
+	srv := botmakertest.NewServer(botmakertest.NewClient(responses...), nil)
+	t.Cleanup(srv.Close)
+
+	cl := srv.NewOAIClient()
+	cl.ResponseCache = botMaker.NewResponseCache(mode, 0)
+	return srv, cl
+}
+
+func chatRequests(srv *botmakertest.Server) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if r.Path == "/v1/chat/completions" {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestCallCompletionAPIUsesTheResponseCache(t *testing.T) {
+	srv, cl := cachedClient(t, botMaker.ResponseCacheExact, "first", "second")
+	settings := newSettings()
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := ask(t, cl, settings, "What is the capital of Germany?"); resp != "first" {
+			t.Errorf("call %d got %q", i, resp)
+		}
+	}
+
+	if n := chatRequests(srv); n != 1 {
+		t.Errorf("got %d chat requests, want the second answer from the cache", n)
+	}
+
+	if resp, _ := ask(t, cl, settings.WithoutResponseCache(), "What is the capital of Germany?"); resp != "second" {
+		t.Errorf("got %q, want a fresh answer without the cache", resp)
+	}
+}
+
+func TestSemanticCacheSkipsFollowUps(t *testing.T) {
+	srv, cl := cachedClient(t, botMaker.ResponseCacheSemantic, "Berlin.", "Paris.")
+	settings := newSettings()
+	ask(t, cl, settings, "What is the capital of Germany?")
+
+	prompt, _ := botMaker.LoadBotPrompt("", cl)
+	prompt.Body = "What is the capital of Germany?"
+	prompt.History = []*botMaker.RenderContext{
+		{Role: openai.ChatMessageRoleUser, Content: "Tell me about France."},
+	}
+	resp, _, err := cl.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp != "Paris." || chatRequests(srv) != 2 {
+		t.Errorf("a question with history was answered from the cache: %q", resp)
+	}
+}
+
+func TestGetStructuredIsNotCached(t *testing.T) {
+	srv, cl := cachedClient(t, botMaker.ResponseCacheExact, `{"city": 1}`, `{"city": "Berlin"}`)
+	prompt, _ := botMaker.LoadBotPrompt("", cl)
+	prompt.Body = "What is the capital of Germany?"
+
+	var out struct {
+		City string `json:"city"`
+	}
+	if err := botMaker.GetStructured(cl, newSettings(), prompt, &out, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.City != "Berlin" || chatRequests(srv) != 2 {
+		t.Errorf("got %+v after %d requests", out, chatRequests(srv))
+	}
+}
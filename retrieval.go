@@ -0,0 +1,124 @@
+package botMaker
+
+import (
+	"github.com/sashabaranov/go-openai"
+)
+
+// RetrievalSettings controls how contexts are fetched from memory, each bot has its own so recall can be tuned
+// separately
+type RetrievalSettings struct {
+	TopK             int                   // Contexts to keep for the prompt
+	CandidatePool    int                   // Matches fetched from each store before filtering and reranking
+	MinScore         float32               // Vector matches below this similarity are dropped
+	EmbeddingModel   openai.EmbeddingModel // Used to embed the question, must match the model used to learn
+	MaxContextTokens int                   // Upper bound on tokens spent on contexts, 0 means no bound beyond TokenLimit
+	DedupThreshold   float32               // Matches whose term overlap with a better match is at least this are dropped, 0 disables
+	ContextShare     float32               // Share of TokenLimit that contexts may use
+	Rewrite          QueryRewriteSettings  // Optional query transformations run before retrieval
+}
+
+// NewRetrievalSettings returns the retrieval defaults used by NewBotSettings
+func NewRetrievalSettings() RetrievalSettings {
+	return RetrievalSettings{
+		TopK:           3,
+		CandidatePool:  20,
+		MinScore:       0.9,
+		EmbeddingModel: openai.AdaEmbeddingV2,
+		DedupThreshold: 0.9,
+		ContextShare:   0.5,
+	}
+}
+
+// RetrievalConfig returns the effective retrieval settings, zero values are filled in from the older top level
+// fields (EmbeddingModel, MemoryAcceptScore) and from the library defaults
+func (s *BotSettings) RetrievalConfig() RetrievalSettings {
+	r := s.Retrieval
+	if r.TopK <= 0 {
+		r.TopK = 3
+	}
+
+	if r.CandidatePool < r.TopK {
+		r.CandidatePool = r.TopK
+	}
+
+	if r.MinScore == 0 {
+		r.MinScore = s.MemoryAcceptScore
+	}
+
+	if r.EmbeddingModel == openai.Unknown {
+		r.EmbeddingModel = s.EmbeddingModel
+	}
+
+	if r.EmbeddingModel == openai.Unknown {
+		r.EmbeddingModel = openai.AdaEmbeddingV2
+	}
+
+	if r.ContextShare <= 0 || r.ContextShare > 1 {
+		r.ContextShare = 0.5
+	}
+
+	return r
+}
+
+// ContextBudget returns the number of tokens contexts may use for a prompt with the given token limit
+func (r RetrievalSettings) ContextBudget(tokenLimit int) int {
+	budget := int(float32(tokenLimit) * r.ContextShare)
+	if r.MaxContextTokens > 0 && r.MaxContextTokens < budget {
+		budget = r.MaxContextTokens
+	}
+
+	return budget
+}
+
+// PackContexts walks matches in rank order (as returned by the retriever or reranker) and keeps up to cfg.TopK of
+// them that fit in budget tokens, skipping near-duplicates of contexts already kept and sources that have used up
+// their quota (0 or missing is unlimited). It returns the kept contexts and the dropped ones with the reason
+func PackContexts(matches []QueryMatch, cfg RetrievalSettings, budget int, quotas map[string]int,
+	countTokens func(string) int) ([]Context, []DroppedContext) {
+	included := make([]Context, 0, cfg.TopK)
+	dropped := make([]DroppedContext, 0)
+	perSource := make(map[string]int)
+	used := 0
+
+	for i := range matches {
+		ctx := NewContextFromMatch(&matches[i])
+		ctx.Tokens = countTokens(ctx.Text)
+
+		reason := ""
+		switch {
+		case isDuplicateContext(ctx, included, cfg.DedupThreshold):
+			reason = DroppedDuplicate
+		case len(included) >= cfg.TopK:
+			reason = DroppedTopK
+		case quotas[ctx.Source] > 0 && perSource[ctx.Source] >= quotas[ctx.Source]:
+			reason = DroppedSourceQuota
+		case used+ctx.Tokens > budget:
+			reason = DroppedTokenBudget
+		}
+
+		if reason != "" {
+			dropped = append(dropped, DroppedContext{Context: ctx, Reason: reason})
+			continue
+		}
+
+		included = append(included, ctx)
+		perSource[ctx.Source]++
+		used += ctx.Tokens
+	}
+
+	return included, dropped
+}
+
+func isDuplicateContext(ctx Context, kept []Context, threshold float32) bool {
+	for i := range kept {
+		if ctx.ID != "" && ctx.ID == kept[i].ID && ctx.Source == kept[i].Source {
+			return true
+		}
+
+		if threshold > 0 && TermOverlap(ctx.Text, kept[i].Text) >= threshold {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package botMaker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+// learned returns a client and a store that has learned text sentence by sentence
+func learned(t *testing.T, text string) (*botmakertest.Client, *botmakertest.Storage) {
+	t.Helper()
+
+	client := botmakertest.NewClient()
+	store := botmakertest.NewStorage("capitals")
+	learn := &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store, Client: client}
+	if _, err := learn.Learn(text, "capitals"); err != nil {
+		t.Fatal(err)
+	}
+
+	return client, store
+}
+
+func TestGetContextsHonoursRetrievalSettings(t *testing.T) {
+	client, store := learned(t, "Berlin is the capital of Germany. Paris is the capital of France. "+
+		"Rome is the capital of Italy.")
+
+	settings := newSettings()
+	settings.Memory = store
+	settings.Retrieval.TopK = 1
+	settings.Retrieval.MinScore = 0.1
+
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "What is the capital of Germany?"
+	contexts, err := botMaker.GetContexts(prompt, settings, store, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(contexts) != 1 || !strings.HasPrefix(contexts[0], "Berlin") {
+		t.Errorf("got %q, want the best match only", contexts)
+	}
+
+	for _, d := range prompt.DroppedContexts {
+		if d.Reason != botMaker.DroppedTopK && d.Reason != botMaker.DroppedDuplicate {
+			t.Errorf("%q dropped for %s", d.Text, d.Reason)
+		}
+	}
+
+	settings.Retrieval.MinScore = 0.999
+	if contexts, _ := botMaker.GetContexts(prompt, settings, store, client); len(contexts) != 0 {
+		t.Errorf("got %q above MinScore", contexts)
+	}
+
+	for _, d := range prompt.DroppedContexts {
+		if d.Reason != botMaker.DroppedBelowMinScore {
+			t.Errorf("%q dropped for %s, want %s", d.Text, d.Reason, botMaker.DroppedBelowMinScore)
+		}
+	}
+}
+
+func words(s string) int {
+	return len(strings.Fields(s))
+}
+
+func TestPackContexts(t *testing.T) {
+	matches := []botMaker.QueryMatch{
+		match("1", "docs", "berlin is the capital of germany"),
+		match("1", "docs", "berlin is the capital of germany"),
+		match("2", "docs", "paris is the capital of france"),
+		match("3", "wiki", "rome is the capital of italy and has many old buildings"),
+		match("4", "wiki", "madrid is in spain"),
+		match("5", "blog", "lisbon"),
+	}
+	cfg := botMaker.RetrievalSettings{TopK: 3, DedupThreshold: 0.9}
+	quotas := map[string]int{"docs": 1}
+
+	included, dropped := botMaker.PackContexts(matches, cfg, 12, quotas, words)
+
+	var kept []string
+	for _, c := range included {
+		kept = append(kept, c.ID)
+	}
+	if strings.Join(kept, ",") != "1,4,5" {
+		t.Errorf("kept %v, want 1,4,5", kept)
+	}
+
+	want := map[string]string{
+		"1": botMaker.DroppedDuplicate,
+		"2": botMaker.DroppedSourceQuota,
+		"3": botMaker.DroppedTokenBudget,
+	}
+	if len(dropped) != len(want) {
+		t.Fatalf("dropped %+v", dropped)
+	}
+	for _, d := range dropped {
+		if want[d.ID] != d.Reason {
+			t.Errorf("%s dropped for %s, want %s", d.ID, d.Reason, want[d.ID])
+		}
+	}
+
+	if included[0].Tokens != 6 {
+		t.Errorf("got %d tokens, want the count of the context text", included[0].Tokens)
+	}
+}
+
+func TestPackContextsTopK(t *testing.T) {
+	matches := []botMaker.QueryMatch{match("1", "", "one"), match("2", "", "two"), match("3", "", "three")}
+
+	included, dropped := botMaker.PackContexts(matches, botMaker.RetrievalSettings{TopK: 2}, 100, nil, words)
+	if len(included) != 2 || len(dropped) != 1 || dropped[0].Reason != botMaker.DroppedTopK {
+		t.Errorf("got %+v and %+v", included, dropped)
+	}
+}
+
+func TestContextBudget(t *testing.T) {
+	r := botMaker.RetrievalSettings{ContextShare: 0.5}
+	if got := r.ContextBudget(4000); got != 2000 {
+		t.Errorf("got %d, want half the limit", got)
+	}
+
+	r.MaxContextTokens = 500
+	if got := r.ContextBudget(4000); got != 500 {
+		t.Errorf("got %d, want MaxContextTokens", got)
+	}
+}
+
+func TestRetrievalConfigDefaults(t *testing.T) {
+	s := &botMaker.BotSettings{MemoryAcceptScore: 0.8, Retrieval: botMaker.RetrievalSettings{TopK: 5}}
+	r := s.RetrievalConfig()
+
+	if r.CandidatePool != 5 || r.MinScore != 0.8 || r.ContextShare != 0.5 || r.EmbeddingModel == 0 {
+		t.Errorf("got %+v", r)
+	}
+}
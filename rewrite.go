@@ -0,0 +1,107 @@
+package botMaker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// QueryRewriteSettings controls how the question is transformed before retrieval, follow-up questions in a chat
+// ("how is a cluster different?") embed poorly on their own
+type QueryRewriteSettings struct {
+	Condense    bool // Rewrite the history and the follow-up into a standalone question
+	Paraphrases int  // Number of paraphrases of the question to retrieve with as well
+	HyDE        bool // Also retrieve with a hypothetical answer to the question
+}
+
+// Enabled returns true if any transformation is switched on
+func (q QueryRewriteSettings) Enabled() bool {
+	return q.Condense || q.Paraphrases > 0 || q.HyDE
+}
+
+var CONDENSE_INSTRUCTIONS = "Given a conversation and a follow-up question, rewrite the follow-up as a standalone " +
+	"question that can be understood without the conversation. Reply with the question only."
+
+var PARAPHRASE_INSTRUCTIONS = "Write %d different rephrasings of the question that keep its meaning. Reply with " +
+	"one rephrasing per line and nothing else."
+
+var HYDE_INSTRUCTIONS = "Write a short passage that answers the question as a reference document would. " +
+	"It does not matter if you are unsure of the facts."
+
+// RewriteQueries returns the queries to retrieve contexts with for the prompt, the first is always the (possibly
+// condensed) standalone question followed by any paraphrases and the hypothetical answer
+func RewriteQueries(b *BotPrompt, s *BotSettings, c LLMAPIClient, q QueryRewriteSettings) ([]string, error) {
+	return rewriteQueries(b.context(), b, s, c, q)
+}
+
+func rewriteQueries(ctx context.Context, b *BotPrompt, s *BotSettings, c LLMAPIClient,
+	q QueryRewriteSettings) ([]string, error) {
+	question := b.Body
+	if q.Condense && len(b.History) > 0 {
+		var conversation strings.Builder
+		for _, h := range b.History {
+			conversation.WriteString(h.Role + ": " + h.Content + "\n")
+		}
+
+		condensed, err := completeHelper(ctx, c, s, CONDENSE_INSTRUCTIONS,
+			fmt.Sprintf("Conversation:\n%s\nFollow-up question: %s", conversation.String(), b.Body))
+		if err != nil {
+			return nil, err
+		}
+
+		if condensed != "" {
+			question = condensed
+		}
+	}
+
+	queries := []string{question}
+
+	if q.Paraphrases > 0 {
+		resp, err := completeHelper(ctx, c, s, fmt.Sprintf(PARAPHRASE_INSTRUCTIONS, q.Paraphrases), question)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(resp, "\n") {
+			line = strings.TrimSpace(strings.TrimLeft(line, "0123456789.-*) "))
+			if line != "" && len(queries) <= q.Paraphrases {
+				queries = append(queries, line)
+			}
+		}
+	}
+
+	if q.HyDE {
+		hypothetical, err := completeHelper(ctx, c, s, HYDE_INSTRUCTIONS, question)
+		if err != nil {
+			return nil, err
+		}
+
+		if hypothetical != "" {
+			queries = append(queries, hypothetical)
+		}
+	}
+
+	return queries, nil
+}
+
+// completeHelper runs a one-off prompt with the bot's model and HelperSettings, without history
+func completeHelper(ctx context.Context, c LLMAPIClient, s *BotSettings, instructions, body string) (string, error) {
+	prompt, err := LoadBotPrompt("{{.Body}}", c)
+	if err != nil {
+		return "", err
+	}
+	prompt.ctx = ctx
+	prompt.Instructions = instructions
+	prompt.Body = body
+	if !IsChatModel(s.Model) {
+		// completion models have no system message
+		prompt.Body = instructions + "\n\n" + body
+	}
+
+	resp, _, err := c.CallCompletionAPI(s.HelperSettings(), prompt)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(resp), nil
+}
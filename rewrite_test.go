@@ -0,0 +1,63 @@
+package botMaker_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+)
+
+func TestRewriteQueries(t *testing.T) {
+	client, store := learned(t, "Berlin is the capital of Germany.")
+	client.When("standalone", "What is the capital of Germany?").
+		When("rephrasings", "1. Which city is Germany's capital?\n2. Germany's capital city?\n3. One too many").
+		When("reference document", "Berlin is the capital of Germany.")
+
+	settings := newSettings()
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+	rewrite := botMaker.QueryRewriteSettings{Condense: true, Paraphrases: 2, HyDE: true}
+
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "And its capital?"
+	prompt.History = []*botMaker.RenderContext{{Role: "user", Content: "Tell me about Germany."}}
+
+	queries, err := botMaker.RewriteQueries(prompt, settings, client, rewrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"What is the capital of Germany?", "Which city is Germany's capital?",
+		"Germany's capital city?", "Berlin is the capital of Germany."}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("got %q, want %q", queries, want)
+	}
+
+	// the helpers run without memory, so they never retrieve or rewrite themselves
+	for _, call := range client.Calls() {
+		if len(call.Contexts) != 0 {
+			t.Errorf("a helper completion retrieved contexts: %+v", call)
+		}
+	}
+}
+
+func TestGetContextsRetrievesWithEveryQuery(t *testing.T) {
+	client, store := learned(t, "Berlin is the capital of Germany.")
+	client.When("reference document", "Berlin is the capital of Germany.")
+
+	settings := newSettings()
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+	settings.Retrieval.Rewrite.HyDE = true
+
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "Which city?"
+	contexts, err := botMaker.GetContexts(prompt, settings, store, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prompt.RetrievalQueries) != 2 || len(contexts) == 0 {
+		t.Errorf("got queries %q and contexts %q", prompt.RetrievalQueries, contexts)
+	}
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/sashabaranov/go-openai"
+)
+
+type BotInfo struct {
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	Documents bool   `json:"documents"` // Accepts document uploads
+}
+
+type ChatRequest struct {
+	SessionID string `json:"session_id,omitempty"` // Empty starts a new session
+	Message   string `json:"message"`
+	Stream    bool   `json:"stream,omitempty"` // Stream the response as server-sent events
+}
+
+type ChatResponse struct {
+	SessionID string             `json:"session_id"`
+	Response  string             `json:"response"`
+	Tokens    int                `json:"tokens"`
+	Contexts  []botMaker.Context `json:"contexts"`
+}
+
+type SearchRequest struct {
+	Query string `json:"query"`
+	TopK  int    `json:"top_k,omitempty"` // Defaults to the bot's Retrieval.TopK
+}
+
+type SearchResponse struct {
+	Query    string                    `json:"query"`
+	Queries  []string                  `json:"queries"`
+	Contexts []botMaker.Context        `json:"contexts"`
+	Dropped  []botMaker.DroppedContext `json:"dropped"`
+}
+
+type UploadedDocument struct {
+	Name       string `json:"name"`
+	Embeddings int    `json:"embeddings"`
+}
+
+type UploadResponse struct {
+	Documents []UploadedDocument `json:"documents"`
+}
+
+type SessionResponse struct {
+	SessionID string                    `json:"session_id"`
+	History   []*botMaker.RenderContext `json:"history"`
+}
+
+func (s *Server) handleListBots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	bots := s.Bots()
+	out := make([]BotInfo, len(bots))
+	for i, b := range bots {
+		out[i] = BotInfo{Name: b.Name, Model: b.Settings.Model, Documents: b.Learn != nil}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request, bot *Bot, caller string) {
+	var req ChatRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("message is required"))
+		return
+	}
+
+	if req.SessionID == "" {
+		req.SessionID = NewSessionID()
+	}
+
+	prompt, err := bot.NewPrompt(s.Client)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	key := callerSession(caller, req.SessionID)
+
+	// a turn reads the history and saves it with the new messages, so the turns of a session run one at a time
+	unlock := s.turns.lock(sessionKey(bot.Name, key))
+	defer unlock()
+
+	prompt.Session = req.SessionID
+	prompt.History = s.Sessions.Load(bot.Name, key)
+	prompt.Body = req.Message
+
+	var resp string
+	var tokens int
+	if req.Stream {
+		sse, ok := newSSEWriter(w)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+			return
+		}
+
+		resp, tokens, err = s.stream(bot.Settings, prompt, func(delta string) error {
+			return sse.event("delta", map[string]string{"content": delta})
+		})
+		if err != nil {
+			s.logger().Error("chat failed", "bot", bot.Name, "session", req.SessionID, "error", err)
+			sse.event("error", errorResponse{Error: err.Error()})
+			return
+		}
+
+		s.saveTurn(bot, key, prompt.History, req.Message, resp)
+		sse.event("done", ChatResponse{
+			SessionID: req.SessionID,
+			Response:  resp,
+			Tokens:    tokens,
+			Contexts:  prompt.IncludedContexts,
+		})
+		return
+	}
+
+	resp, tokens, err = s.Client.CallCompletionAPI(bot.Settings, prompt)
+	if err != nil {
+		s.logger().Error("chat failed", "bot", bot.Name, "session", req.SessionID, "error", err)
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	s.saveTurn(bot, key, prompt.History, req.Message, resp)
+	writeJSON(w, http.StatusOK, ChatResponse{
+		SessionID: req.SessionID,
+		Response:  resp,
+		Tokens:    tokens,
+		Contexts:  prompt.IncludedContexts,
+	})
+}
+
+// stream streams the response when the client supports it, otherwise the whole response is sent as one delta
+func (s *Server) stream(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt,
+	onDelta func(string) error) (string, int, error) {
+	if sc, ok := s.Client.(botMaker.StreamingLLMAPIClient); ok {
+		return sc.StreamCompletionAPI(settings, prompt, onDelta)
+	}
+
+	resp, tokens, err := s.Client.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return resp, tokens, onDelta(resp)
+}
+
+func (s *Server) saveTurn(bot *Bot, session string, history []*botMaker.RenderContext, message, resp string) {
+	history = append(history,
+		&botMaker.RenderContext{Role: openai.ChatMessageRoleUser, Content: message},
+		&botMaker.RenderContext{Role: openai.ChatMessageRoleAssistant, Content: resp})
+	s.Sessions.Save(bot.Name, session, history)
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request, bot *Bot, key, session string) {
+	writeJSON(w, http.StatusOK, SessionResponse{
+		SessionID: session,
+		History:   s.Sessions.Load(bot.Name, key),
+	})
+}
+
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request, bot *Bot, key string) {
+	s.Sessions.Delete(bot.Name, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callerSession is the ID a session is stored under, prefixed with the caller so callers only see their own sessions
+func callerSession(caller, session string) string {
+	if caller == "" {
+		return session
+	}
+
+	return caller + "\x00" + session
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request, bot *Bot) {
+	var req SearchRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query is required"))
+		return
+	}
+
+	settings := *bot.Settings
+	if settings.Memory == nil && len(settings.Sources) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bot %s has no memory to search", bot.Name))
+		return
+	}
+
+	if req.TopK > 0 {
+		settings.Retrieval = settings.RetrievalConfig()
+		settings.Retrieval.TopK = req.TopK
+	}
+
+	prompt, err := bot.NewPrompt(s.Client)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	prompt.Body = req.Query
+
+	if _, err := botMaker.GetContexts(prompt, &settings, settings.Memory, s.Client); err != nil {
+		s.logger().Error("search failed", "bot", bot.Name, "error", err)
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SearchResponse{
+		Query:    req.Query,
+		Queries:  prompt.RetrievalQueries,
+		Contexts: prompt.IncludedContexts,
+		Dropped:  prompt.DroppedContexts,
+	})
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, bot *Bot) {
+	if bot.Learn == nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("bot %s does not accept documents", bot.Name))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadBytes)
+	if err := r.ParseMultipartForm(s.MaxUploadBytes); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid upload: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no file in the upload, use the \"file\" field"))
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "botmaker-upload-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Learn isn't safe for concurrent use
+	bot.learnMu.Lock()
+	defer bot.learnMu.Unlock()
+
+	out := UploadResponse{Documents: make([]UploadedDocument, 0, len(files))}
+	for _, fh := range files {
+		name := filepath.Base(fh.Filename)
+		if _, ok := bot.Learn.ExtensionSupported(name); !ok {
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("%s: file format is not supported", name))
+			return
+		}
+
+		path := filepath.Join(dir, name)
+		if err := saveUpload(fh, path); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		n, err := learnUpload(bot.Learn, path, name)
+		if err != nil {
+			s.logger().Error("upload failed", "bot", bot.Name, "file", name, "error", err)
+			writeError(w, statusFor(err), fmt.Errorf("%s: %w", name, err))
+			return
+		}
+
+		out.Documents = append(out.Documents, UploadedDocument{Name: name, Embeddings: n})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// learnUpload learns an uploaded file titled with the client's file name, rather than the temporary path or a title
+// read from the file
+func learnUpload(l *botMaker.Learn, path, name string) (int, error) {
+	_, contents, err := l.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.Learn(contents, name)
+}
+
+func saveUpload(fh *multipart.FileHeader, path string) error {
+	src, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// sseWriter writes server-sent events
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	return &sseWriter{w: w, flusher: flusher}, true
+}
+
+// event writes a named event with v as JSON data, an empty name writes an unnamed (message) event
+func (s *sseWriter) event(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if name != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	return s.data(string(data))
+}
+
+// data writes an unnamed event with raw data
+func (s *sseWriter) data(data string) error {
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
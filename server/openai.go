@@ -0,0 +1,337 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAIHandler returns routes compatible with OpenAI's API, so OpenAI SDKs and UIs can talk to the bots. Every
+// bot is listed as a model under its name:
+//
+//	GET  /v1/models
+//	GET  /v1/models/{bot}
+//	POST /v1/chat/completions
+//
+// Handler serves these routes too
+func (s *Server) OpenAIHandler() http.Handler {
+	mux := http.NewServeMux()
+	s.handleOpenAI(mux)
+	return mux
+}
+
+func (s *Server) handleOpenAI(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.HandleFunc("/v1/models/", s.handleModels)
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+}
+
+// oaiModel is a model in OpenAI's format
+type oaiModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// oaiStreamChunk is a streamed chunk, the last one also carries the usage of the whole response
+type oaiStreamChunk struct {
+	openai.ChatCompletionStreamResponse
+	Usage *openai.Usage `json:"usage,omitempty"`
+}
+
+type oaiModelList struct {
+	Object string     `json:"object"`
+	Data   []oaiModel `json:"data"`
+}
+
+func modelFor(b *Bot) oaiModel {
+	return oaiModel{ID: b.Name, Object: "model", Created: b.added.Unix(), OwnedBy: "botmaker"}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/")
+	if s.Auth != nil {
+		if _, err := s.Auth(r, name); err != nil {
+			writeOpenAIError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	if name != "" {
+		bot, ok := s.Bot(name)
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, fmt.Errorf("the model %q does not exist", name))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, modelFor(bot))
+		return
+	}
+
+	bots := s.Bots()
+	out := oaiModelList{Object: "list", Data: make([]oaiModel, len(bots))}
+	for i, b := range bots {
+		out.Data[i] = modelFor(b)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleChatCompletions answers an OpenAI chat completion request with the bot named by the request's model. The
+// last message must be from the user and becomes the prompt's body, earlier user and assistant messages become its
+// history. Retrieval and the bot's template are applied as for any other prompt
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	var req openai.ChatCompletionRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeOpenAIError(w, status, err)
+		return
+	}
+
+	if s.Auth != nil {
+		if _, err := s.Auth(r, req.Model); err != nil {
+			writeOpenAIError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	bot, ok := s.Bot(req.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Errorf("the model %q does not exist", req.Model))
+		return
+	}
+
+	if req.N > 1 {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("n > 1 is not supported"))
+		return
+	}
+
+	prompt, err := bot.NewPrompt(s.Client)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := fromMessages(prompt, bot, req.Messages); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+	prompt.Session = req.User
+	if len(req.Stop) > 0 {
+		prompt.Stop = req.Stop
+	}
+
+	settings := withRequestOptions(bot.Settings, &req)
+
+	id := "chatcmpl-" + NewSessionID()
+	created := time.Now().Unix()
+
+	if req.Stream {
+		s.streamChatCompletion(w, bot, settings, prompt, id, created)
+		return
+	}
+
+	resp, tokens, err := s.Client.CallCompletionAPI(settings, prompt)
+	if err != nil {
+		s.logger().Error("chat completion failed", "bot", bot.Name, "error", err)
+		writeOpenAIError(w, statusFor(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, openai.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   bot.Name,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: resp},
+			FinishReason: openai.FinishReasonStop,
+		}},
+		Usage: usageFor(prompt, resp, settings.Model, tokens),
+	})
+}
+
+func (s *Server) streamChatCompletion(w http.ResponseWriter, bot *Bot, settings *botMaker.BotSettings,
+	prompt *botMaker.BotPrompt, id string, created int64) {
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	chunk := func(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason,
+		usage *openai.Usage) error {
+		return sse.event("", oaiStreamChunk{
+			ChatCompletionStreamResponse: openai.ChatCompletionStreamResponse{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   bot.Name,
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finish}},
+			},
+			Usage: usage,
+		})
+	}
+
+	if err := chunk(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "", nil); err != nil {
+		return
+	}
+
+	resp, tokens, err := s.stream(settings, prompt, func(delta string) error {
+		return chunk(openai.ChatCompletionStreamChoiceDelta{Content: delta}, "", nil)
+	})
+	if err != nil {
+		s.logger().Error("chat completion failed", "bot", bot.Name, "error", err)
+		sse.event("", openAIErrorFor(statusFor(err), err))
+		return
+	}
+
+	usage := usageFor(prompt, resp, settings.Model, tokens)
+	if err := chunk(openai.ChatCompletionStreamChoiceDelta{}, openai.FinishReasonStop, &usage); err != nil {
+		return
+	}
+
+	sse.data("[DONE]")
+}
+
+// fromMessages sets the prompt's body and history from OpenAI chat messages, system messages replace the bot's
+// instructions when the bot allows it and are ignored otherwise
+func fromMessages(prompt *botMaker.BotPrompt, bot *Bot, messages []openai.ChatCompletionMessage) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("messages is required")
+	}
+
+	last := messages[len(messages)-1]
+	if last.Role != openai.ChatMessageRoleUser {
+		return fmt.Errorf("the last message must be from the user")
+	}
+	prompt.Body = messageText(last)
+
+	var system []string
+	for _, m := range messages[:len(messages)-1] {
+		switch m.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, messageText(m))
+		case openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+			prompt.History = append(prompt.History, &botMaker.RenderContext{Role: m.Role, Content: messageText(m)})
+		}
+	}
+
+	if bot.SystemMessages && len(system) > 0 {
+		prompt.Instructions = strings.Join(system, "\n\n")
+	}
+
+	return nil
+}
+
+// messageText returns a message's content, only the text parts of multi-part messages are kept
+func messageText(m openai.ChatCompletionMessage) string {
+	if len(m.MultiContent) == 0 {
+		return m.Content
+	}
+
+	parts := make([]string, 0, len(m.MultiContent))
+	for _, p := range m.MultiContent {
+		if p.Type == openai.ChatMessagePartTypeText {
+			parts = append(parts, p.Text)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// withRequestOptions returns the bot's settings with the sampling options and max_tokens set in the request
+func withRequestOptions(settings *botMaker.BotSettings, req *openai.ChatCompletionRequest) *botMaker.BotSettings {
+	s := *settings
+	if req.Temperature != 0 {
+		s.Temp = req.Temperature
+	}
+	if req.TopP != 0 {
+		s.TopP = req.TopP
+	}
+	if req.PresencePenalty != 0 {
+		s.PresencePenalty = req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		s.FrequencyPenalty = req.FrequencyPenalty
+	}
+	if req.MaxTokens > 0 {
+		s.MaxCompletionTokens = req.MaxTokens
+	}
+
+	return &s
+}
+
+// usageFor returns the usage the client reported on the prompt. Clients that only return the total have it split
+// into the response's tokens, counted with the model's tokenizer, and the prompt's, the rest, which is an estimate.
+// Cached responses used no tokens and report none
+func usageFor(prompt *botMaker.BotPrompt, resp, model string, tokens int) openai.Usage {
+	if tokens == 0 {
+		return openai.Usage{}
+	}
+
+	if prompt.Usage.TotalTokens > 0 {
+		return prompt.Usage
+	}
+
+	completion, _ := botMaker.CountTokens(resp, model)
+	if completion > tokens {
+		completion = tokens
+	}
+
+	return openai.Usage{PromptTokens: tokens - completion, CompletionTokens: completion, TotalTokens: tokens}
+}
+
+type oaiError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+type oaiErrorResponse struct {
+	Error oaiError `json:"error"`
+}
+
+func openAIErrorFor(status int, err error) oaiErrorResponse {
+	typ := "server_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		typ = "authentication_error"
+	case status == http.StatusTooManyRequests:
+		typ = "insufficient_quota"
+	case status < 500:
+		typ = "invalid_request_error"
+	}
+
+	var tooLong *botMaker.TokenLimitError
+	var code *string
+	if errors.As(err, &tooLong) {
+		c := "context_length_exceeded"
+		code = &c
+	}
+
+	return oaiErrorResponse{Error: oaiError{Message: err.Error(), Type: typ, Code: code}}
+}
+
+// writeOpenAIError writes an error in OpenAI's format, which OpenAI SDKs know how to report
+func writeOpenAIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, openAIErrorFor(status, err))
+}
@@ -0,0 +1,210 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIClient returns an OpenAI SDK client for the server
+func openAIClient(ts *httptest.Server) *openai.Client {
+	cfg := openai.DefaultConfig("key")
+	cfg.BaseURL = ts.URL + "/v1"
+	cfg.HTTPClient = ts.Client()
+	return openai.NewClientWithConfig(cfg)
+}
+
+func question(messages ...string) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{Model: "capitals"}
+	for i, m := range messages {
+		role := openai.ChatMessageRoleUser
+		if i%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{Role: role, Content: m})
+	}
+
+	return req
+}
+
+func TestOpenAIModels(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient())
+	client := openAIClient(ts)
+
+	models, err := client.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(models.Models) != 1 || models.Models[0].ID != "capitals" {
+		t.Errorf("got %+v", models.Models)
+	}
+
+	_, err = client.GetModel(context.Background(), "missing")
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusNotFound {
+		t.Errorf("got %v, want a 404", err)
+	}
+}
+
+func TestOpenAIChatCompletion(t *testing.T) {
+	fake := botmakertest.NewClient("Berlin.")
+	_, ts := serve(t, fake)
+
+	resp, err := openAIClient(ts).CreateChatCompletion(context.Background(),
+		question("France?", "Paris.", "Germany?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Model != "capitals" || resp.Choices[0].Message.Content != "Berlin." {
+		t.Errorf("got %+v", resp)
+	}
+
+	call, _ := fake.LastCall()
+	if call.Body != "Germany?" || !strings.Contains(call.Prompt, "Paris.") {
+		t.Errorf("the history was not passed on: %+v", call)
+	}
+
+	// the offline tokenizer counts a token per byte
+	if resp.Usage.CompletionTokens != len("Berlin.") || resp.Usage.PromptTokens == 0 ||
+		resp.Usage.TotalTokens != resp.Usage.PromptTokens+resp.Usage.CompletionTokens {
+		t.Errorf("got usage %+v", resp.Usage)
+	}
+}
+
+// reportingClient reports usage the way OAIClient passes on the API's
+type reportingClient struct {
+	*botmakertest.Client
+}
+
+func (c reportingClient) CallCompletionAPI(settings *botMaker.BotSettings, prompt *botMaker.BotPrompt) (string, int,
+	error) {
+	resp, _, err := c.Client.CallCompletionAPI(settings, prompt)
+	prompt.Usage = openai.Usage{PromptTokens: 11, CompletionTokens: 3, TotalTokens: 14}
+	return resp, 14, err
+}
+
+func TestOpenAIChatCompletionReportsTheClientsUsage(t *testing.T) {
+	_, ts := serve(t, reportingClient{botmakertest.NewClient("Berlin.")})
+
+	resp, err := openAIClient(ts).CreateChatCompletion(context.Background(), question("Germany?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (openai.Usage{PromptTokens: 11, CompletionTokens: 3, TotalTokens: 14}); resp.Usage != want {
+		t.Errorf("got usage %+v, want %+v", resp.Usage, want)
+	}
+}
+
+func TestOpenAIChatCompletionStream(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient("The capital is Berlin."))
+
+	req := question("Germany?")
+	stream, err := openAIClient(ts).CreateChatCompletionStream(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var content string
+	var finish openai.FinishReason
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content += chunk.Choices[0].Delta.Content
+		if chunk.Choices[0].FinishReason != "" {
+			finish = chunk.Choices[0].FinishReason
+		}
+	}
+
+	if content != "The capital is Berlin." || finish != openai.FinishReasonStop {
+		t.Errorf("got %q, finished with %q", content, finish)
+	}
+}
+
+func TestOpenAIStreamReportsUsage(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient("Berlin."))
+
+	body := strings.NewReader(`{"model": "capitals", "stream": true,
+		"messages": [{"role": "user", "content": "Germany?"}]}`)
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	events := strings.Split(strings.TrimSpace(string(data)), "\n\n")
+	if len(events) < 2 || events[len(events)-1] != "data: [DONE]" {
+		t.Fatalf("got %q", data)
+	}
+
+	if last := events[len(events)-2]; !strings.Contains(last, `"usage":{"prompt_tokens":`) ||
+		!strings.Contains(last, `"completion_tokens":7`) {
+		t.Errorf("the last chunk has no usage: %s", last)
+	}
+}
+
+func TestOpenAIChatCompletionErrors(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient())
+	client := openAIClient(ts)
+
+	for name, req := range map[string]openai.ChatCompletionRequest{
+		"unknown model":       {Model: "missing", Messages: question("Germany?").Messages},
+		"no messages":         {Model: "capitals"},
+		"assistant last":      question("Germany?", "Berlin."),
+		"several completions": {Model: "capitals", N: 2, Messages: question("Germany?").Messages},
+	} {
+		_, err := client.CreateChatCompletion(context.Background(), req)
+		var apiErr *openai.APIError
+		if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode >= 500 {
+			t.Errorf("%s: got %v, want a client error", name, err)
+		}
+	}
+}
+
+func TestOpenAISystemMessages(t *testing.T) {
+	fake := &botmakertest.Client{Default: "Berlin."}
+	s, ts := serve(t, fake)
+	client := openAIClient(ts)
+
+	req := question("Germany?")
+	req.Messages = append([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "Answer in French."},
+	}, req.Messages...)
+
+	if _, err := client.CreateChatCompletion(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if call, _ := fake.LastCall(); strings.Contains(call.Prompt, "Answer in French.") {
+		t.Errorf("a system message replaced the instructions: %q", call.Prompt)
+	}
+
+	bot, _ := s.Bot("capitals")
+	bot.SystemMessages = true
+	if _, err := client.CreateChatCompletion(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if call, _ := fake.LastCall(); !strings.Contains(call.Prompt, "Answer in French.") ||
+		strings.Contains(call.Prompt, "Name capitals.") {
+		t.Errorf("the system message did not replace the instructions: %q", call.Prompt)
+	}
+}
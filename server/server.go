@@ -0,0 +1,336 @@
+// Package server exposes botMaker bots over a REST/JSON API: chat with sessions (optionally streamed as server-sent
+// events), document uploads into a bot's memory and retrieval-only search. See openapi.json for the API
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+)
+
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Bot is a bot served by the API, defined by its settings and prompt template
+type Bot struct {
+	Name         string
+	Settings     *botMaker.BotSettings
+	Template     string // text/template or a file:// path, empty uses botMaker.DEFAULT_TEMPLATE
+	Instructions string
+	ChatTemplate *botMaker.ChatTemplate // Optional
+	Learn        *botMaker.Learn        // Optional, enables document uploads into the bot's memory
+
+	// SystemMessages lets system messages in OpenAI-compatible requests replace Instructions
+	SystemMessages bool
+
+	learnMu sync.Mutex
+	added   time.Time
+}
+
+// NewPrompt returns a prompt for a single turn with the bot
+func (b *Bot) NewPrompt(client botMaker.LLMAPIClient) (*botMaker.BotPrompt, error) {
+	prompt, err := botMaker.LoadBotPrompt(b.Template, client)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt.Instructions = b.Instructions
+	prompt.ChatTemplate = b.ChatTemplate
+	return prompt, nil
+}
+
+// AuthFunc authorizes a request and returns who made it, bot is empty for requests that are not for a single bot
+// (e.g. listing bots). Returning an error rejects the request with 401. Sessions are scoped to the caller, so one
+// caller can't read or continue another's; an empty caller shares sessions between everyone allowed in
+type AuthFunc func(r *http.Request, bot string) (caller string, err error)
+
+// Server serves a set of bots
+type Server struct {
+	Client          botMaker.LLMAPIClient
+	Sessions        SessionStore
+	Auth            AuthFunc        // Optional, every request is allowed when nil
+	Logger          botMaker.Logger // Optional, defaults to botMaker.DefaultLogger
+	MaxUploadBytes  int64
+	MaxRequestBytes int64         // Largest JSON request body, 0 means DefaultMaxRequestBytes
+	ShutdownTimeout time.Duration // How long ListenAndServe waits for in-flight requests when shutting down
+
+	mu    sync.RWMutex
+	bots  map[string]*Bot
+	turns sessionLocks
+}
+
+// DefaultMaxRequestBytes is the largest JSON request body a Server reads when MaxRequestBytes is 0
+const DefaultMaxRequestBytes = 1 << 20
+
+func New(client botMaker.LLMAPIClient) *Server {
+	return &Server{
+		Client:          client,
+		Sessions:        NewMemorySessions(),
+		MaxUploadBytes:  32 << 20,
+		MaxRequestBytes: DefaultMaxRequestBytes,
+		ShutdownTimeout: 30 * time.Second,
+		bots:            make(map[string]*Bot),
+	}
+}
+
+// AddBot validates a bot's settings and template and starts serving it
+func (s *Server) AddBot(b *Bot) error {
+	if b.Name == "" || strings.Contains(b.Name, "/") {
+		return fmt.Errorf("%w: invalid bot name %q", botMaker.ErrConfig, b.Name)
+	}
+
+	if b.Settings == nil {
+		return fmt.Errorf("%w: bot %s has no settings", botMaker.ErrConfig, b.Name)
+	}
+
+	if err := b.Settings.Validate(); err != nil {
+		return fmt.Errorf("bot %s: %w", b.Name, err)
+	}
+
+	if _, err := b.NewPrompt(s.Client); err != nil {
+		return fmt.Errorf("bot %s: %w", b.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bots == nil {
+		s.bots = make(map[string]*Bot)
+	}
+	b.added = time.Now()
+	s.bots[b.Name] = b
+
+	return nil
+}
+
+// RemoveBot stops serving a bot
+func (s *Server) RemoveBot(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.bots, name)
+}
+
+// Bot returns a served bot by name
+func (s *Server) Bot(name string) (*Bot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, ok := s.bots[name]
+	return b, ok
+}
+
+// Bots returns the served bots sorted by name
+func (s *Server) Bots() []*Bot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bots := make([]*Bot, 0, len(s.bots))
+	for _, b := range s.bots {
+		bots = append(bots, b)
+	}
+
+	sort.Slice(bots, func(i, j int) bool { return bots[i].Name < bots[j].Name })
+	return bots
+}
+
+func (s *Server) logger() botMaker.Logger {
+	if s.Logger == nil {
+		return botMaker.DefaultLogger
+	}
+
+	return s.Logger
+}
+
+// Handler returns the API's routes:
+//
+//	GET    /openapi.json
+//	GET    /healthz
+//	GET    /v1/bots
+//	POST   /v1/bots/{bot}/chat
+//	GET    /v1/bots/{bot}/sessions/{id}
+//	DELETE /v1/bots/{bot}/sessions/{id}
+//	POST   /v1/bots/{bot}/documents
+//	POST   /v1/bots/{bot}/search
+//
+// and the OpenAI-compatible routes of OpenAIHandler
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(OpenAPI)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/v1/bots", s.authorized(s.handleListBots))
+	mux.HandleFunc("/v1/bots/", s.routeBot)
+	s.handleOpenAI(mux)
+
+	return mux
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth != nil {
+			if _, err := s.Auth(r, ""); err != nil {
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// routeBot dispatches /v1/bots/{bot}/... to the bot handlers
+func (s *Server) routeBot(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/bots/"), "/"), "/")
+	if len(parts) < 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	caller := ""
+	if s.Auth != nil {
+		var err error
+		if caller, err = s.Auth(r, parts[0]); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	bot, ok := s.Bot(parts[0])
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no bot named %q", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "chat":
+		s.only(w, r, http.MethodPost, func() { s.handleChat(w, r, bot, caller) })
+	case len(parts) == 2 && parts[1] == "documents":
+		s.only(w, r, http.MethodPost, func() { s.handleUpload(w, r, bot) })
+	case len(parts) == 2 && parts[1] == "search":
+		s.only(w, r, http.MethodPost, func() { s.handleSearch(w, r, bot) })
+	case len(parts) == 3 && parts[1] == "sessions":
+		switch r.Method {
+		case http.MethodGet:
+			s.handleGetSession(w, r, bot, callerSession(caller, parts[2]), parts[2])
+		case http.MethodDelete:
+			s.handleDeleteSession(w, r, bot, callerSession(caller, parts[2]))
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+func (s *Server) only(w http.ResponseWriter, r *http.Request, method string, handle func()) {
+	if r.Method != method {
+		methodNotAllowed(w, method)
+		return
+	}
+
+	handle()
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled, then stops accepting connections and waits up to
+// ShutdownTimeout for in-flight requests to finish
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		s.logger().Info("serving bots", "addr", addr, "bots", len(s.Bots()))
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger().Info("shutting down", "addr", addr)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// decodeJSON reads a JSON request body of at most MaxRequestBytes into v, it returns the status to reject the
+// request with on failure
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) (int, error) {
+	limit := s.MaxRequestBytes
+	if limit <= 0 {
+		limit = DefaultMaxRequestBytes
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", limit)
+		}
+
+		return http.StatusBadRequest, fmt.Errorf("invalid request: %v", err)
+	}
+
+	return 0, nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// statusFor maps library errors to HTTP statuses
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, botMaker.ErrTokenLimit):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, botMaker.ErrBudgetExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, botMaker.ErrStorage):
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,276 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/lonelycode/botMaker/server"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMain(m *testing.M) {
+	botmakertest.UseOfflineTokenizer()
+	os.Exit(m.Run())
+}
+
+// serve starts a server for client with a "capitals" bot that has no memory
+func serve(t *testing.T, client botMaker.LLMAPIClient) (*server.Server, *httptest.Server) {
+	t.Helper()
+
+	settings := botMaker.NewBotSettings()
+	settings.ID = "capitals"
+	settings.Model = openai.GPT3Dot5Turbo
+
+	s := server.New(client)
+	s.Logger = botMaker.NopLogger{}
+	if err := s.AddBot(&server.Bot{Name: "capitals", Settings: settings, Instructions: "Name capitals."}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// post sends v as JSON and decodes the response into out, it returns the status
+func post(t *testing.T, url string, v, out interface{}) int {
+	t.Helper()
+
+	body, _ := json.Marshal(v)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return resp.StatusCode
+}
+
+func session(t *testing.T, url string) server.SessionResponse {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out server.SessionResponse
+	json.NewDecoder(resp.Body).Decode(&out)
+	return out
+}
+
+func TestChatSessions(t *testing.T) {
+	client := botmakertest.NewClient("Berlin.", "Paris.")
+	_, ts := serve(t, client)
+	chat := ts.URL + "/v1/bots/capitals/chat"
+
+	var first server.ChatResponse
+	if status := post(t, chat, server.ChatRequest{Message: "Germany?"}, &first); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	if first.SessionID == "" || first.Response != "Berlin." || first.Tokens == 0 {
+		t.Errorf("got %+v", first)
+	}
+
+	var second server.ChatResponse
+	post(t, chat, server.ChatRequest{SessionID: first.SessionID, Message: "France?"}, &second)
+	if second.SessionID != first.SessionID || second.Response != "Paris." {
+		t.Errorf("got %+v", second)
+	}
+
+	if call, _ := client.LastCall(); !strings.Contains(call.Prompt, "Berlin.") {
+		t.Errorf("the second turn was sent without the history: %q", call.Prompt)
+	}
+
+	url := ts.URL + "/v1/bots/capitals/sessions/" + first.SessionID
+	if got := session(t, url); len(got.History) != 4 || got.History[3].Content != "Paris." {
+		t.Errorf("got history %+v", got.History)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := session(t, url); resp.StatusCode != http.StatusNoContent || len(got.History) != 0 {
+		t.Errorf("got status %d and history %+v after deleting", resp.StatusCode, got.History)
+	}
+}
+
+func TestChatStreams(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient("The capital is Berlin."))
+
+	body, _ := json.Marshal(server.ChatRequest{Message: "Germany?", Stream: true})
+	resp, err := http.Post(ts.URL+"/v1/bots/capitals/chat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var events []string
+	var streamed, done string
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+			events = append(events, event)
+		case strings.HasPrefix(line, "data: ") && event == "delta":
+			var delta map[string]string
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &delta)
+			streamed += delta["content"]
+		case strings.HasPrefix(line, "data: ") && event == "done":
+			var out server.ChatResponse
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &out)
+			done = out.Response
+		}
+	}
+
+	if len(events) < 2 || events[len(events)-1] != "done" {
+		t.Errorf("got events %v", events)
+	}
+
+	if streamed != "The capital is Berlin." || done != streamed {
+		t.Errorf("streamed %q, done with %q", streamed, done)
+	}
+}
+
+func TestAuthScopesSessions(t *testing.T) {
+	s, ts := serve(t, &botmakertest.Client{Default: "Berlin."})
+	s.Auth = func(r *http.Request, bot string) (string, error) {
+		caller := r.Header.Get("Authorization")
+		if caller == "" {
+			return "", fmt.Errorf("no API key")
+		}
+		return caller, nil
+	}
+
+	chat := func(caller string, req server.ChatRequest) (int, server.ChatResponse) {
+		body, _ := json.Marshal(req)
+		r, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/bots/capitals/chat", bytes.NewReader(body))
+		if caller != "" {
+			r.Header.Set("Authorization", caller)
+		}
+
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var out server.ChatResponse
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if status, _ := chat("", server.ChatRequest{Message: "Germany?"}); status != http.StatusUnauthorized {
+		t.Errorf("got status %d without an API key", status)
+	}
+
+	_, alice := chat("alice", server.ChatRequest{Message: "Germany?"})
+	chat("bob", server.ChatRequest{SessionID: alice.SessionID, Message: "France?"})
+
+	r, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/bots/capitals/sessions/"+alice.SessionID, nil)
+	r.Header.Set("Authorization", "alice")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got server.SessionResponse
+	json.NewDecoder(resp.Body).Decode(&got)
+	if len(got.History) != 2 || got.History[0].Content != "Germany?" {
+		t.Errorf("bob's message reached alice's session: %+v", got.History)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	s, ts := serve(t, &botmakertest.Client{Default: "Berlin."})
+	s.MaxRequestBytes = 64
+
+	long := strings.Repeat("What is the capital of Germany? ", 10)
+	for path, req := range map[string]interface{}{
+		"/v1/bots/capitals/chat":   server.ChatRequest{Message: long},
+		"/v1/bots/capitals/search": server.SearchRequest{Query: long},
+		"/v1/chat/completions": openai.ChatCompletionRequest{Model: "capitals", Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: long},
+		}},
+	} {
+		if status := post(t, ts.URL+path, req, nil); status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got status %d, want 413", path, status)
+		}
+	}
+
+	if status := post(t, ts.URL+"/v1/bots/capitals/chat", server.ChatRequest{Message: "Germany?"}, nil); status != 200 {
+		t.Errorf("got status %d for a short request", status)
+	}
+}
+
+func TestConcurrentTurnsKeepEveryMessage(t *testing.T) {
+	client := &botmakertest.Client{Respond: func(call botmakertest.Call) (string, error) {
+		// give concurrent turns the chance to read the same history
+		time.Sleep(5 * time.Millisecond)
+		return "Berlin.", nil
+	}}
+	_, ts := serve(t, client)
+
+	const turns = 5
+	var wg sync.WaitGroup
+	for i := 0; i < turns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			post(t, ts.URL+"/v1/bots/capitals/chat", server.ChatRequest{SessionID: "shared",
+				Message: fmt.Sprintf("question %d", i)}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	if got := session(t, ts.URL+"/v1/bots/capitals/sessions/shared"); len(got.History) != 2*turns {
+		t.Errorf("got %d messages, want %d", len(got.History), 2*turns)
+	}
+}
+
+func TestSearchNeedsMemory(t *testing.T) {
+	_, ts := serve(t, botmakertest.NewClient())
+
+	if status := post(t, ts.URL+"/v1/bots/capitals/search", server.SearchRequest{Query: "Germany"}, nil); status != 400 {
+		t.Errorf("got status %d for a bot without memory", status)
+	}
+}
+
+func TestAddBotRejectsInvalidBots(t *testing.T) {
+	s := server.New(botmakertest.NewClient())
+
+	for _, b := range []*server.Bot{
+		{Name: "", Settings: botMaker.NewBotSettings()},
+		{Name: "a/b", Settings: botMaker.NewBotSettings()},
+		{Name: "nosettings"},
+	} {
+		if err := s.AddBot(b); err == nil {
+			t.Errorf("%q: added", b.Name)
+		}
+	}
+}
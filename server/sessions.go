@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+)
+
+// SessionStore keeps the chat history of every session with a bot
+type SessionStore interface {
+	Load(bot, session string) []*botMaker.RenderContext
+	Save(bot, session string, history []*botMaker.RenderContext)
+	Delete(bot, session string)
+}
+
+// MemorySessions is an in-process SessionStore, sessions idle for longer than TTL are forgotten
+type MemorySessions struct {
+	MaxMessages int           // Most recent messages kept per session, 0 keeps all
+	TTL         time.Duration // 0 keeps sessions forever
+
+	mu       sync.Mutex
+	sessions map[string]*memorySession
+}
+
+type memorySession struct {
+	history []*botMaker.RenderContext
+	touched time.Time
+}
+
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{
+		MaxMessages: 20,
+		TTL:         24 * time.Hour,
+		sessions:    make(map[string]*memorySession),
+	}
+}
+
+func sessionKey(bot, session string) string {
+	return bot + "\x00" + session
+}
+
+func (m *MemorySessions) Load(bot, session string) []*botMaker.RenderContext {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionKey(bot, session)]
+	if !ok || (m.TTL > 0 && time.Since(s.touched) > m.TTL) {
+		return make([]*botMaker.RenderContext, 0)
+	}
+
+	history := make([]*botMaker.RenderContext, len(s.history))
+	copy(history, s.history)
+	return history
+}
+
+func (m *MemorySessions) Save(bot, session string, history []*botMaker.RenderContext) {
+	if m.MaxMessages > 0 && len(history) > m.MaxMessages {
+		history = history[len(history)-m.MaxMessages:]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = make(map[string]*memorySession)
+	}
+
+	// forget idle sessions while we are here
+	now := time.Now()
+	if m.TTL > 0 {
+		for k, s := range m.sessions {
+			if now.Sub(s.touched) > m.TTL {
+				delete(m.sessions, k)
+			}
+		}
+	}
+
+	m.sessions[sessionKey(bot, session)] = &memorySession{history: history, touched: now}
+}
+
+func (m *MemorySessions) Delete(bot, session string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, sessionKey(bot, session))
+}
+
+// NewSessionID returns a random session ID
+func NewSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionLocks serialises the turns of each session, the zero value is ready to use
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock blocks until no other turn of the session holds its lock and returns the function that releases it
+func (l *sessionLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+
+	sl, ok := l.locks[key]
+	if !ok {
+		sl = &sessionLock{}
+		l.locks[key] = sl
+	}
+	sl.waiters++
+	l.mu.Unlock()
+
+	sl.Lock()
+	return func() {
+		sl.Unlock()
+
+		l.mu.Lock()
+		// drop the lock of a session nobody is waiting on so the map doesn't grow with every session
+		if sl.waiters--; sl.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,151 @@
+package botMaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MemorySource is one of several namespaces or stores a bot retrieves contexts from, e.g. shared product docs and a
+// customer specific namespace
+type MemorySource struct {
+	Name      string       // Reported on matches and contexts, defaults to Namespace
+	Storage   Storage      // Defaults to BotSettings.Memory
+	Keywords  KeywordStore // Optional, defaults to BotSettings.Keywords
+	Namespace string
+	Weight    float32 // Weight when merging with other sources, 0 means 1
+	Quota     int     // Most contexts this source may add to a prompt, 0 is unlimited
+}
+
+// sourcesFor returns the sources to retrieve from, a bot without Sources retrieves from m in its own namespace
+func sourcesFor(s *BotSettings, m Storage) []MemorySource {
+	if len(s.Sources) == 0 {
+		return []MemorySource{{Storage: m, Keywords: s.Keywords, Namespace: s.ID}}
+	}
+
+	return withSourceDefaults(s.Sources, s, m)
+}
+
+// withSourceDefaults fills in the storage, keyword store, name and weight sources leave out
+func withSourceDefaults(in []MemorySource, s *BotSettings, m Storage) []MemorySource {
+	sources := make([]MemorySource, len(in))
+	for i, src := range in {
+		if src.Storage == nil {
+			src.Storage = m
+		}
+
+		if src.Keywords == nil {
+			src.Keywords = s.Keywords
+		}
+
+		if src.Name == "" {
+			src.Name = src.Namespace
+		}
+
+		if src.Weight == 0 {
+			src.Weight = 1
+		}
+
+		sources[i] = src
+	}
+
+	return sources
+}
+
+// sourceQuotas returns the per source context quotas keyed by source name
+func sourceQuotas(sources []MemorySource) map[string]int {
+	quotas := make(map[string]int)
+	for _, src := range sources {
+		if src.Quota > 0 {
+			quotas[src.Name] = src.Quota
+		}
+	}
+
+	return quotas
+}
+
+type sourceResult struct {
+	matches  []QueryMatch
+	rejected []QueryMatch
+	err      error
+}
+
+// RetrieveFederated queries every source in parallel and merges the results into one ranked list with weighted
+// reciprocal rank fusion, each match is tagged with the name of the source it came from. With a single source the
+// matches are returned as they are so scores stay similarities. Sources default as in BotSettings.Sources
+func RetrieveFederated(sources []MemorySource, s *BotSettings, query string, questionEmbedding []float32,
+	cfg RetrievalSettings) ([]QueryMatch, []QueryMatch, error) {
+	return retrieveFederated(context.Background(), withSourceDefaults(sources, s, s.Memory), s, query,
+		questionEmbedding, cfg)
+}
+
+func retrieveFederated(ctx context.Context, sources []MemorySource, s *BotSettings, query string,
+	questionEmbedding []float32, cfg RetrievalSettings) ([]QueryMatch, []QueryMatch, error) {
+	results := make([]sourceResult, len(sources))
+
+	var wg sync.WaitGroup
+	for i := range sources {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			src := sources[i]
+			if src.Storage == nil {
+				results[i].err = fmt.Errorf("%w: memory source %q has no storage", ErrConfig, src.Name)
+				return
+			}
+
+			retriever := &HybridRetriever{
+				Vectors:       src.Storage,
+				Keywords:      src.Keywords,
+				VectorWeight:  s.VectorWeight,
+				KeywordWeight: s.KeywordWeight,
+			}
+
+			attrs := []attribute.KeyValue{AttrNamespace.String(src.Namespace), AttrSource.String(src.Name)}
+			_, span := startSpan(ctx, "Retrieve", append(attrs, AttrTopK.Int(cfg.CandidatePool))...)
+			started := time.Now()
+
+			matches, rejected, err := retriever.Retrieve(query, questionEmbedding, cfg, src.Namespace)
+			span.SetAttributes(AttrCandidates.Int(len(matches)))
+			endSpan(ctx, span, "retrieve", started, err, attrs...)
+
+			for j := range matches {
+				matches[j].Source = src.Name
+			}
+			for j := range rejected {
+				rejected[j].Source = src.Name
+			}
+
+			results[i] = sourceResult{matches: matches, rejected: rejected, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	lists := make([][]QueryMatch, 0, len(sources))
+	weights := make([]float32, 0, len(sources))
+	rejected := make([]QueryMatch, 0)
+	for i := range results {
+		if results[i].err != nil {
+			return nil, nil, results[i].err
+		}
+
+		lists = append(lists, results[i].matches)
+		weights = append(weights, sources[i].Weight)
+		rejected = append(rejected, results[i].rejected...)
+	}
+
+	if len(lists) == 1 {
+		return lists[0], rejected, nil
+	}
+
+	fused := FuseRRF(0, lists, weights)
+	if len(fused) > cfg.CandidatePool {
+		fused = fused[:cfg.CandidatePool]
+	}
+
+	return fused, rejected, nil
+}
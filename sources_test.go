@@ -0,0 +1,82 @@
+package botMaker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func learnInto(t *testing.T, client *botmakertest.Client, store botMaker.Storage, namespace, text string) {
+	t.Helper()
+
+	learn := &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store, Client: client,
+		Namespace: namespace}
+	if _, err := learn.Learn(text, namespace); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetContextsFromSeveralSources(t *testing.T) {
+	client := botmakertest.NewClient()
+	docs := botmakertest.NewStorage("docs")
+	wiki := botmakertest.NewStorage("wiki")
+	learnInto(t, client, docs, "docs", "The capital of Germany is Berlin.")
+	learnInto(t, client, wiki, "wiki", "The capital of Germany has been Berlin since 1990.")
+
+	settings := newSettings()
+	settings.Retrieval.MinScore = 0.1
+	settings.Retrieval.DedupThreshold = 0
+	settings.Sources = []botMaker.MemorySource{
+		{Storage: docs, Namespace: "docs"},
+		{Name: "encyclopedia", Storage: wiki, Namespace: "wiki", Quota: 1},
+	}
+
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "What is the capital of Germany?"
+	if _, err := botMaker.GetContexts(prompt, settings, nil, client); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := map[string]int{}
+	for _, c := range prompt.IncludedContexts {
+		sources[c.Source]++
+	}
+	if sources["docs"] != 1 || sources["encyclopedia"] != 1 {
+		t.Errorf("got contexts from %v, want one from each source by name", sources)
+	}
+}
+
+func TestRetrieveFederatedNeedsStorage(t *testing.T) {
+	sources := []botMaker.MemorySource{{Name: "empty", Namespace: "empty"}}
+	cfg := newSettings().RetrievalConfig()
+
+	_, _, err := botMaker.RetrieveFederated(sources, newSettings(), "question", []float32{1}, cfg)
+	if !errors.Is(err, botMaker.ErrConfig) {
+		t.Errorf("got %v, want ErrConfig", err)
+	}
+}
+
+func TestRetrieveFederatedWeighsSources(t *testing.T) {
+	a := botmakertest.NewStorage("a")
+	b := botmakertest.NewStorage("b")
+	a.Upsert("a", []botMaker.PineconeVector{{ID: "x", Values: []float32{1, 0}}})
+	b.Upsert("b", []botMaker.PineconeVector{{ID: "y", Values: []float32{1, 0}}})
+
+	sources := []botMaker.MemorySource{
+		{Storage: a, Namespace: "a", Weight: 0.5},
+		{Storage: b, Namespace: "b"},
+	}
+	cfg := botMaker.RetrievalSettings{TopK: 2, CandidatePool: 2, MinScore: 0.5}
+
+	matches, _, err := botMaker.RetrieveFederated(sources, newSettings(), "", []float32{1, 0}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 2 || matches[0].Source != "b" || matches[1].Source != "a" {
+		t.Errorf("got %+v, want the unweighted source first", matches)
+	}
+}
@@ -0,0 +1,164 @@
+package botMaker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// StreamingLLMAPIClient is implemented by clients that can stream completions, onDelta is called with every piece
+// of the response as it arrives and an error from it stops the stream
+type StreamingLLMAPIClient interface {
+	LLMAPIClient
+	StreamCompletionAPI(settings *BotSettings, prompt *BotPrompt, onDelta func(delta string) error) (string, int, error)
+}
+
+// StreamCompletionAPI is CallCompletionAPI with the response streamed to onDelta, it returns the whole response
+// and the tokens used. The API doesn't report the usage of streams, so prompt.Usage is counted with the model's
+// tokenizer. The response cache is not consulted, but streamed responses are added to it
+func (c *OAIClient) StreamCompletionAPI(settings *BotSettings, prompt *BotPrompt,
+	onDelta func(delta string) error) (string, int, error) {
+	attrs := []attribute.KeyValue{AttrBotID.String(settings.ID), AttrModel.String(settings.Model)}
+	ctx, span := startSpan(prompt.context(), "StreamCompletionAPI", attrs...)
+	started := time.Now()
+
+	resp, usage, err := c.streamCompletionAPI(ctx, settings, prompt, onDelta)
+	if err == nil {
+		recordTokens(ctx, span, settings.Model, "prompt", usage.PromptTokens)
+		recordTokens(ctx, span, settings.Model, "completion", usage.CompletionTokens)
+	}
+	endSpan(ctx, span, "completion", started, err, attrs...)
+
+	prompt.Usage = usage
+	if err != nil {
+		return "", 0, err
+	}
+
+	return resp, usage.TotalTokens, nil
+}
+
+func (c *OAIClient) streamCompletionAPI(ctx context.Context, settings *BotSettings, prompt *BotPrompt,
+	onDelta func(delta string) error) (string, openai.Usage, error) {
+	c, restore, err := c.forPrompt(settings, prompt)
+	if err != nil {
+		return "", openai.Usage{}, err
+	}
+	defer restore()
+	defer prompt.withContext(ctx)()
+
+	if err := c.checkBudget(settings.ID); err != nil {
+		return "", openai.Usage{}, err
+	}
+
+	var request interface{}
+	if IsChatModel(settings.Model) {
+		request, err = prompt.AsChatCompletionRequest(settings)
+	} else {
+		request, err = prompt.AsCompletionRequest(settings)
+	}
+
+	if err != nil {
+		return "", openai.Usage{}, err
+	}
+
+	var response string
+	switch r := request.(type) {
+	case *openai.ChatCompletionRequest:
+		response, err = c.streamChat(ctx, r, onDelta)
+	case *openai.CompletionRequest:
+		response, err = c.streamCompletion(ctx, r, onDelta)
+	}
+
+	if err != nil {
+		loggerOr(c.Logger).Error("streamed completion failed", "bot", settings.ID, "model", settings.Model,
+			"error", err)
+		return "", openai.Usage{}, err
+	}
+
+	// streamed responses don't report usage, count it ourselves
+	completionTokens, _ := CountTokens(response, settings.Model)
+	usage := openai.Usage{
+		PromptTokens:     prompt.PromptLength,
+		CompletionTokens: completionTokens,
+		TotalTokens:      prompt.PromptLength + completionTokens,
+	}
+	c.recordUsage(settings.Model, usage, false)
+
+	if cache := c.responseCache(settings); cache != nil && cache.Mode == ResponseCacheExact {
+		if key, err := RequestCacheKey(request); err == nil {
+			cache.Put(ResponseCacheScope(settings), key, nil, response, responseNamespaces(settings))
+		}
+	}
+
+	return response, usage, nil
+}
+
+func (c *OAIClient) streamChat(ctx context.Context, r *openai.ChatCompletionRequest,
+	onDelta func(string) error) (string, error) {
+	r.Stream = true
+	defer func() { r.Stream = false }()
+
+	stream, err := c.Client.CreateChatCompletionStream(ctx, *r)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var response []byte
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return string(response), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		response = append(response, delta...)
+		if err := onDelta(delta); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (c *OAIClient) streamCompletion(ctx context.Context, r *openai.CompletionRequest,
+	onDelta func(string) error) (string, error) {
+	r.Stream = true
+	defer func() { r.Stream = false }()
+
+	stream, err := c.Client.CreateCompletionStream(ctx, *r)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var response []byte
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return string(response), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Text == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Text
+		response = append(response, delta...)
+		if err := onDelta(delta); err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package botMaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema used to describe and validate structured output
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Nullable             bool                   `json:"-"` // Null is also valid, marshalled as "type": [Type, "null"]
+}
+
+// MarshalJSON writes the type of a nullable schema as [Type, "null"]
+func (s JSONSchema) MarshalJSON() ([]byte, error) {
+	type plain JSONSchema
+	if !s.Nullable || s.Type == "" {
+		return json.Marshal(plain(s))
+	}
+
+	return json.Marshal(struct {
+		Type []string `json:"type"`
+		plain
+	}{Type: []string{s.Type, "null"}, plain: plain(s)})
+}
+
+// UnmarshalJSON reads a type given as a string or as a list of a type and "null"
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	type plain JSONSchema
+	aux := struct {
+		Type json.RawMessage `json:"type"`
+		*plain
+	}{plain: (*plain)(s)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	s.Type, s.Nullable = "", false
+	if len(aux.Type) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(aux.Type, &s.Type); err == nil {
+		return nil
+	}
+
+	var types []string
+	if err := json.Unmarshal(aux.Type, &types); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings: %w", err)
+	}
+
+	for _, t := range types {
+		switch {
+		case t == "null":
+			s.Nullable = true
+		case s.Type == "":
+			s.Type = t
+		default:
+			return fmt.Errorf("unsupported union type %v", types)
+		}
+	}
+
+	return nil
+}
+
+var STRUCTURED_FORMAT = `Reply with a single JSON value, and nothing else, that matches this JSON schema:
+%s`
+
+var STRUCTURED_RETRY = `
+
+Your previous reply was rejected because it did not match the schema: %v
+Reply again with corrected JSON only.`
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaFor derives a JSON schema from a Go value, field names follow the json struct tags, fields without
+// omitempty are required, pointer fields are nullable and a `description` tag is passed on to the model. Recursive
+// types are an error, pass a JSONSchema of your own for those
+func SchemaFor(v interface{}) (*JSONSchema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot derive a schema from nil")
+	}
+
+	return schemaForType(t, make(map[reflect.Type]bool))
+}
+
+// schemaForType derives the schema of t, visiting holds the structs being expanded
+func schemaForType(t reflect.Type, visiting map[reflect.Type]bool) (*JSONSchema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	case reflect.String:
+		return &JSONSchema{Type: "string"}, nil
+	case reflect.Interface:
+		return &JSONSchema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string"}, nil
+		}
+
+		items, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		return &JSONSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, got %v", t.Key())
+		}
+
+		values, err := schemaForType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		return &JSONSchema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return nil, fmt.Errorf("unsupported recursive type %v", t)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		schema := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		if err := addStructFields(schema, t, visiting); err != nil {
+			return nil, err
+		}
+
+		return schema, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type for schema: %v", t)
+}
+
+func addStructFields(schema *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// embedded structs are flattened like encoding/json does
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				if visiting[ft] {
+					return fmt.Errorf("unsupported recursive type %v", ft)
+				}
+
+				visiting[ft] = true
+				err := addStructFields(schema, ft, visiting)
+				delete(visiting, ft)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := schemaForType(f.Type, visiting)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		prop.Description = f.Tag.Get("description")
+		prop.Nullable = f.Type.Kind() == reflect.Pointer
+
+		schema.Properties[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a decoded JSON value (as produced by json.Unmarshal into an interface{}) against the schema and
+// returns an error describing every mismatch
+func (s *JSONSchema) Validate(value interface{}) error {
+	problems := make([]string, 0)
+	s.validate("$", value, &problems)
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (s *JSONSchema) validate(path string, value interface{}, problems *[]string) {
+	if value == nil && s.Nullable {
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: %v is not one of %v", path, value, s.Enum))
+		}
+	}
+
+	switch s.Type {
+	case "":
+		return
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an object", path))
+			return
+		}
+
+		for _, r := range s.Required {
+			if _, ok := obj[r]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: missing required property %q", path, r))
+			}
+		}
+
+		for k, v := range obj {
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(path+"."+k, v, problems)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(path+"."+k, v, problems)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an array", path))
+			return
+		}
+
+		if s.Items != nil {
+			for i, v := range arr {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), v, problems)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a string", path))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a boolean", path))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a number", path))
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an integer", path))
+		}
+	case "null":
+		if value != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: expected null", path))
+		}
+	}
+}
+
+// ExtractJSON returns the JSON value in a model reply, stripping markdown code fences and any text around it
+func ExtractJSON(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		reply = strings.TrimPrefix(reply, "```json")
+		reply = strings.TrimPrefix(reply, "```")
+		reply = strings.TrimSuffix(reply, "```")
+		reply = strings.TrimSpace(reply)
+	}
+
+	start := strings.IndexAny(reply, "{[")
+	if start < 0 {
+		return reply
+	}
+
+	end := strings.LastIndexAny(reply, "}]")
+	if end < start {
+		return reply[start:]
+	}
+
+	return reply[start : end+1]
+}
+
+// GetStructured runs the prompt asking for JSON that matches schema (derived from out when nil), validates the
+// reply and unmarshals it into out. Invalid replies are retried up to maxRetries times with the validation error
+// added to the prompt. JSON mode is used when the model supports it
+func GetStructured(c LLMAPIClient, settings *BotSettings, prompt *BotPrompt, out interface{}, schema *JSONSchema,
+	maxRetries int) error {
+	var err error
+	if schema == nil {
+		schema, err = SchemaFor(out)
+		if err != nil {
+			return err
+		}
+	}
+
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	originalFormat := prompt.DesiredFormat
+	originalJSONMode := prompt.JSONMode
+	defer func() {
+		prompt.DesiredFormat = originalFormat
+		prompt.JSONMode = originalJSONMode
+	}()
+
+	format := fmt.Sprintf(STRUCTURED_FORMAT, schemaJSON)
+	prompt.DesiredFormat = format
+	prompt.JSONMode = true
+
+	// an invalid reply must not be cached, or every retry would get it back
+	uncached := settings.WithoutResponseCache()
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		resp, _, err := c.CallCompletionAPI(uncached, prompt)
+		if err != nil {
+			return err
+		}
+
+		lastErr = decodeStructured(resp, schema, out)
+		if lastErr == nil {
+			return nil
+		}
+
+		prompt.DesiredFormat = format + fmt.Sprintf(STRUCTURED_RETRY, lastErr)
+	}
+
+	return fmt.Errorf("structured output failed after %d attempts: %w", maxRetries+1, lastErr)
+}
+
+func decodeStructured(reply string, schema *JSONSchema, out interface{}) error {
+	raw := ExtractJSON(reply)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+
+	if err := schema.Validate(value); err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(raw), out)
+}
@@ -0,0 +1,183 @@
+package botMaker_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+type ticket struct {
+	Title    string            `json:"title" description:"a short summary"`
+	Priority int               `json:"priority"`
+	Tags     []string          `json:"tags,omitempty"`
+	Owner    *string           `json:"owner"`
+	Due      time.Time         `json:"due"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Internal string            `json:"-"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := botMaker.SchemaFor(ticket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(schema.Required, []string{"title", "priority", "owner", "due"}) {
+		t.Errorf("got required %v", schema.Required)
+	}
+
+	if _, ok := schema.Properties["Internal"]; ok {
+		t.Error(`a field tagged "-" is in the schema`)
+	}
+
+	p := schema.Properties
+	if p["title"].Description != "a short summary" || p["priority"].Type != "integer" ||
+		p["tags"].Items.Type != "string" || p["due"].Format != "date-time" ||
+		p["labels"].AdditionalProperties.Type != "string" {
+		t.Errorf("unexpected properties %+v", p)
+	}
+
+	data, _ := json.Marshal(p["owner"])
+	if string(data) != `{"type":["string","null"]}` {
+		t.Errorf("got %s for a pointer field", data)
+	}
+
+	var back botMaker.JSONSchema
+	if err := json.Unmarshal(data, &back); err != nil || back.Type != "string" || !back.Nullable {
+		t.Errorf("got %+v, %v", back, err)
+	}
+
+	if _, err := botMaker.SchemaFor(map[int]string{}); err == nil {
+		t.Error("expected an error for a map without string keys")
+	}
+}
+
+type node struct {
+	Name     string `json:"name"`
+	Children []node `json:"children"`
+}
+
+type list struct {
+	Value int   `json:"value"`
+	Next  *list `json:"next"`
+}
+
+// Chain embeds itself, embedded structs are flattened
+type Chain struct {
+	*Chain
+	Name string `json:"name"`
+}
+
+func TestSchemaForRecursiveTypes(t *testing.T) {
+	for _, v := range []interface{}{node{}, list{}, &list{}, Chain{}, map[string][]node{}} {
+		_, err := botMaker.SchemaFor(v)
+		if err == nil || !strings.Contains(err.Error(), "recursive") {
+			t.Errorf("%T: got %v, want a recursive type error", v, err)
+		}
+	}
+
+	// the same type in two fields is not recursion
+	type pair struct {
+		Left  ticket `json:"left"`
+		Right ticket `json:"right"`
+	}
+	if _, err := botMaker.SchemaFor(pair{}); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestGetStructuredRecursiveType(t *testing.T) {
+	client := botmakertest.NewClient(`{"name": "root", "children": []}`)
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "Build a tree"
+
+	var out node
+	if err := botMaker.GetStructured(client, newSettings(), prompt, &out, nil, 0); err == nil {
+		t.Error("expected an error for a recursive type")
+	}
+
+	// a schema of your own works
+	schema := &botMaker.JSONSchema{Type: "object", Required: []string{"name"}}
+	if err := botMaker.GetStructured(client, newSettings(), prompt, &out, schema, 0); err != nil || out.Name != "root" {
+		t.Errorf("got %+v, %v", out, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, _ := botMaker.SchemaFor(ticket{})
+
+	var value interface{}
+	json.Unmarshal([]byte(`{"title": 3, "priority": 1.5, "tags": ["a", 2], "owner": null}`), &value)
+
+	err := schema.Validate(value)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+
+	for _, want := range []string{`missing required property "due"`, "$.title: expected a string",
+		"$.priority: expected an integer", "$.tags[1]: expected a string"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q is missing %q", err, want)
+		}
+	}
+
+	json.Unmarshal([]byte(`{"title": "t", "priority": 1, "owner": null, "due": "2024-01-01T00:00:00Z"}`), &value)
+	if err := schema.Validate(value); err != nil {
+		t.Errorf("a valid value failed: %v", err)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	for reply, want := range map[string]string{
+		"```json\n{\"a\": 1}\n```":    `{"a": 1}`,
+		"Here you go: [1, 2]. Enjoy!": `[1, 2]`,
+		`{"a": 1}`:                    `{"a": 1}`,
+	} {
+		if got := botMaker.ExtractJSON(reply); got != want {
+			t.Errorf("got %q from %q, want %q", got, reply, want)
+		}
+	}
+}
+
+func TestGetStructuredRetries(t *testing.T) {
+	client := botmakertest.NewClient(`{"title": "Login fails"}`, "```json\n{\"title\": \"Login fails\", "+
+		"\"priority\": 2, \"owner\": null, \"due\": \"2024-01-01T00:00:00Z\"}\n```")
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "Users cannot log in"
+
+	var out ticket
+	if err := botMaker.GetStructured(client, newSettings(), prompt, &out, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Title != "Login fails" || out.Priority != 2 || out.Owner != nil {
+		t.Errorf("got %+v", out)
+	}
+
+	calls := client.Calls()
+	if len(calls) != 2 || !strings.Contains(calls[1].Prompt, "previous reply was rejected") ||
+		!strings.Contains(calls[1].Prompt, "priority") {
+		t.Errorf("the retry did not include the validation error: %+v", calls)
+	}
+
+	if prompt.JSONMode || prompt.DesiredFormat != "" {
+		t.Error("the prompt was not restored")
+	}
+}
+
+func TestGetStructuredGivesUp(t *testing.T) {
+	client := botmakertest.NewClient("not json", "still not json")
+	prompt, _ := botMaker.LoadBotPrompt("", client)
+	prompt.Body = "Users cannot log in"
+
+	var out ticket
+	err := botMaker.GetStructured(client, newSettings(), prompt, &out, nil, 1)
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package botMaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer and meter the library reports under
+const InstrumentationName = "github.com/lonelycode/botMaker"
+
+// Attribute keys recorded on spans and metrics
+const (
+	AttrBotID      = attribute.Key("botmaker.bot_id")
+	AttrModel      = attribute.Key("botmaker.model")
+	AttrNamespace  = attribute.Key("botmaker.namespace")
+	AttrSource     = attribute.Key("botmaker.source")
+	AttrTopK       = attribute.Key("botmaker.top_k")
+	AttrQueries    = attribute.Key("botmaker.queries")
+	AttrCandidates = attribute.Key("botmaker.candidates")
+	AttrContexts   = attribute.Key("botmaker.contexts")
+	AttrDropped    = attribute.Key("botmaker.dropped_contexts")
+	AttrTexts      = attribute.Key("botmaker.texts")
+	AttrChunks     = attribute.Key("botmaker.chunks")
+	AttrTokenType  = attribute.Key("botmaker.token_type")
+	AttrCacheHit   = attribute.Key("botmaker.cache_hit")
+	AttrOperation  = attribute.Key("botmaker.operation")
+)
+
+// telemetry holds the providers set by SetTelemetryProviders, the tracer and instruments are created from them once
+var telemetry atomic.Pointer[providers]
+
+type providers struct {
+	tp trace.TracerProvider
+	mp metric.MeterProvider
+
+	tracerOnce sync.Once
+	tracer     trace.Tracer
+	instOnce   sync.Once
+	inst       *instruments
+}
+
+type instruments struct {
+	duration  metric.Float64Histogram
+	tokens    metric.Int64Counter
+	contexts  metric.Int64Histogram
+	chunks    metric.Int64Counter
+	errors    metric.Int64Counter
+	cacheHits metric.Int64Counter
+}
+
+func init() {
+	telemetry.Store(&providers{})
+}
+
+// SetTelemetryProviders sets the tracer and meter providers the library reports to, nil uses the global providers
+// (otel.SetTracerProvider / otel.SetMeterProvider), which is the default and a no-op until they are set
+func SetTelemetryProviders(tp trace.TracerProvider, mp metric.MeterProvider) {
+	telemetry.Store(&providers{tp: tp, mp: mp})
+}
+
+func tracer() trace.Tracer {
+	p := telemetry.Load()
+	p.tracerOnce.Do(func() {
+		tp := p.tp
+		if tp == nil {
+			tp = otel.GetTracerProvider()
+		}
+		p.tracer = tp.Tracer(InstrumentationName)
+	})
+
+	return p.tracer
+}
+
+func meters() *instruments {
+	p := telemetry.Load()
+	p.instOnce.Do(func() {
+		mp := p.mp
+		if mp == nil {
+			mp = otel.GetMeterProvider()
+		}
+		p.inst = newInstruments(mp.Meter(InstrumentationName))
+	})
+
+	return p.inst
+}
+
+func newInstruments(m metric.Meter) *instruments {
+	// instrument errors only happen with invalid names, the returned no-op instruments are still safe to use
+	inst := &instruments{}
+	inst.duration, _ = m.Float64Histogram("botmaker.operation.duration", metric.WithUnit("s"),
+		metric.WithDescription("Duration of embedding, retrieval, completion and learning operations"))
+	inst.tokens, _ = m.Int64Counter("botmaker.tokens", metric.WithUnit("{token}"),
+		metric.WithDescription("Prompt, completion and embedding tokens used"))
+	inst.contexts, _ = m.Int64Histogram("botmaker.retrieval.contexts", metric.WithUnit("{context}"),
+		metric.WithDescription("Contexts included in a prompt"))
+	inst.chunks, _ = m.Int64Counter("botmaker.learn.chunks", metric.WithUnit("{chunk}"),
+		metric.WithDescription("Chunks learned into memory"))
+	inst.errors, _ = m.Int64Counter("botmaker.errors", metric.WithUnit("{error}"),
+		metric.WithDescription("Failed operations"))
+	inst.cacheHits, _ = m.Int64Counter("botmaker.response_cache.hits", metric.WithUnit("{hit}"),
+		metric.WithDescription("Completions answered from the response cache"))
+
+	return inst
+}
+
+// startSpan starts a span named botMaker.<name>
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "botMaker."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the duration of an operation and ends its span, marking both as failed when err is set
+func endSpan(ctx context.Context, span trace.Span, operation string, started time.Time, err error,
+	attrs ...attribute.KeyValue) {
+	attrs = append(attrs, AttrOperation.String(operation))
+	inst := meters()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		inst.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+
+	inst.duration.Record(ctx, time.Since(started).Seconds(), metric.WithAttributes(attrs...))
+	span.End()
+}
+
+// recordTokens adds tokens of a type (prompt, completion or embedding) to the token counter and the span
+func recordTokens(ctx context.Context, span trace.Span, model, tokenType string, tokens int) {
+	span.SetAttributes(attribute.Int("botmaker.tokens."+tokenType, tokens))
+	meters().tokens.Add(ctx, int64(tokens), metric.WithAttributes(AttrModel.String(model),
+		AttrTokenType.String(tokenType)))
+}
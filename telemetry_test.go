@@ -0,0 +1,81 @@
+package botMaker_test
+
+import (
+	"testing"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCallCompletionAPISpansShareATrace(t *testing.T) {
+	client := botmakertest.NewClient().When("reference document", "Berlin is the capital of Germany.")
+	client.Default = "Berlin."
+	store := botmakertest.NewStorage("capitals")
+	learn := &botMaker.Learn{Model: openai.GPT3Dot5Turbo, ChunkSize: 1, Memory: store, Client: client}
+	if _, err := learn.Learn("Berlin is the capital of Germany.", "capitals"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := botmakertest.NewServer(client, store)
+	defer srv.Close()
+
+	settings := botMaker.NewBotSettings()
+	settings.ID = "capitals"
+	settings.Model = openai.GPT3Dot5Turbo
+	settings.Memory = store
+	settings.Retrieval.MinScore = 0.1
+	settings.Retrieval.Rewrite.HyDE = true
+
+	cl := srv.NewOAIClient()
+	prompt, err := botMaker.LoadBotPrompt("", cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt.Body = "What is the capital of Germany?"
+
+	recorder := tracetest.NewSpanRecorder()
+	botMaker.SetTelemetryProviders(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), nil)
+	defer botMaker.SetTelemetryProviders(nil, nil)
+
+	if _, _, err := cl.CallCompletionAPI(settings, prompt); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	byName := map[string]int{}
+	var root sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		byName[s.Name()]++
+		if !s.Parent().IsValid() {
+			if root != nil {
+				t.Fatalf("more than one root span: %s and %s", root.Name(), s.Name())
+			}
+			root = s
+		}
+	}
+
+	if root == nil || root.Name() != "botMaker.CallCompletionAPI" {
+		t.Fatalf("expected CallCompletionAPI to be the root span, got %v", root)
+	}
+
+	for _, s := range spans {
+		if s.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("span %s is in another trace", s.Name())
+		}
+	}
+
+	for _, name := range []string{"botMaker.GetContexts", "botMaker.EmbedQuery", "botMaker.Embeddings",
+		"botMaker.CompletionRequest"} {
+		if byName[name] == 0 {
+			t.Errorf("no %s span, got %v", name, byName)
+		}
+	}
+
+	// the HyDE helper is a completion of its own, nested in the outer one
+	if byName["botMaker.CallCompletionAPI"] != 2 {
+		t.Errorf("expected the outer and the helper completion, got %d", byName["botMaker.CallCompletionAPI"])
+	}
+}
@@ -0,0 +1,240 @@
+package botMaker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// TemplateEncoding is the tokenizer used by the truncateTokens template helper
+var TemplateEncoding = "cl100k_base"
+
+// TemplateFuncs returns the helpers available to every prompt template, t is the template set that include
+// looks partials up in:
+//
+//	truncateTokens N TEXT  - cut TEXT down to at most N tokens
+//	join SEP LIST          - join a list of strings
+//	date LAYOUT            - the current time in a Go time layout
+//	json VALUE             - VALUE as JSON
+//	numbered LIST          - prefix every item with its citation number, e.g. "[1] ..."
+//	include NAME DATA      - render a named partial and return it as a string, so it can be piped
+func TemplateFuncs(t *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"truncateTokens": truncateTokens,
+		"join": func(sep string, items []string) string {
+			return strings.Join(items, sep)
+		},
+		"date": func(layout string) string {
+			return time.Now().Format(layout)
+		},
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"numbered": func(items []string) []string {
+			numbered := make([]string, len(items))
+			for i := range items {
+				numbered[i] = fmt.Sprintf("[%d] %s", i+1, items[i])
+			}
+			return numbered
+		},
+		"include": func(name string, data interface{}) (string, error) {
+			var out bytes.Buffer
+			if err := t.ExecuteTemplate(&out, name, data); err != nil {
+				return "", err
+			}
+			return out.String(), nil
+		},
+	}
+}
+
+func truncateTokens(n int, text string) (string, error) {
+	tke, err := tiktoken.GetEncoding(TemplateEncoding)
+	if err != nil {
+		return "", err
+	}
+
+	tokens := tke.Encode(text, nil, nil)
+	if len(tokens) <= n {
+		return text, nil
+	}
+
+	return tke.Decode(tokens[:n]), nil
+}
+
+// NewPromptTemplate parses a prompt template with the TemplateFuncs helpers
+func NewPromptTemplate(name, text string) (*template.Template, error) {
+	t := template.New(name)
+	t.Funcs(TemplateFuncs(t))
+
+	return t.Parse(text)
+}
+
+// TemplateLibrary is a set of named prompt templates and partials that can include each other. Every template is
+// parsed and test-rendered when it is added, so mistakes surface at load time rather than on the first prompt
+type TemplateLibrary struct {
+	root    *template.Template
+	sources map[string]string
+}
+
+func NewTemplateLibrary() *TemplateLibrary {
+	root := template.New("")
+	root.Funcs(TemplateFuncs(root))
+
+	return &TemplateLibrary{
+		root:    root,
+		sources: make(map[string]string),
+	}
+}
+
+// LoadTemplateDir loads every *.tmpl file in dir into a library, templates are named after the file without
+// the extension
+func LoadTemplateDir(dir string) (*TemplateLibrary, error) {
+	return LoadTemplateFS(os.DirFS(dir), "*.tmpl")
+}
+
+// LoadTemplateFS loads the files matching the glob patterns from fsys (e.g. an embed.FS) into a library
+func LoadTemplateFS(fsys fs.FS, patterns ...string) (*TemplateLibrary, error) {
+	lib := NewTemplateLibrary()
+
+	for _, pattern := range patterns {
+		files, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTemplateLoad, err)
+		}
+
+		for _, f := range files {
+			data, err := fs.ReadFile(fsys, f)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrTemplateLoad, err)
+			}
+
+			name := strings.TrimSuffix(path.Base(f), path.Ext(f))
+			if err := lib.parse(name, string(data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// partials may be defined in any file, so only validate once everything is parsed
+	if err := lib.Validate(); err != nil {
+		return nil, err
+	}
+
+	return lib, nil
+}
+
+// Add parses a template into the library and validates it. A template that fails is not added, and neither are
+// the partials it defines
+func (l *TemplateLibrary) Add(name, text string) error {
+	// parse into a copy so a rejected template never reaches the templates that could include it
+	root, err := l.root.Clone()
+	if err != nil {
+		return fmt.Errorf("%w: template %s: %w", ErrTemplateLoad, name, err)
+	}
+	root.Funcs(TemplateFuncs(root))
+
+	if _, err := root.New(name).Parse(text); err != nil {
+		return fmt.Errorf("%w: template %s: %w", ErrTemplateLoad, name, err)
+	}
+
+	if err := validateTemplate(root, name); err != nil {
+		return err
+	}
+
+	l.root = root
+	l.sources[name] = text
+	return nil
+}
+
+func (l *TemplateLibrary) parse(name, text string) error {
+	if _, err := l.root.New(name).Parse(text); err != nil {
+		return fmt.Errorf("%w: template %s: %w", ErrTemplateLoad, name, err)
+	}
+
+	l.sources[name] = text
+	return nil
+}
+
+// Validate test-renders every loaded template in the library against a sample prompt, partials are checked
+// through the templates that include them
+func (l *TemplateLibrary) Validate() error {
+	names := make([]string, 0, len(l.sources))
+	for name := range l.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := validateTemplate(l.root, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateTemplate test-renders the named template of root against a sample prompt
+func validateTemplate(root *template.Template, name string) error {
+	sample := &BotPrompt{
+		Instructions:    "instructions",
+		Body:            "body",
+		DesiredFormat:   "format",
+		ContextToRender: []string{"context"},
+		ContextTitles:   []string{"title"},
+		History:         []*RenderContext{{Role: "user", Content: "history"}},
+	}
+
+	if err := root.ExecuteTemplate(io.Discard, name, sample); err != nil {
+		return fmt.Errorf("%w: template %s: %w", ErrTemplateLoad, name, err)
+	}
+
+	return nil
+}
+
+// Names returns the names of all templates and partials in the library
+func (l *TemplateLibrary) Names() []string {
+	names := make([]string, 0)
+	for _, t := range l.root.Templates() {
+		if t.Name() != "" {
+			names = append(names, t.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the named template, it can include any partial in the library
+func (l *TemplateLibrary) Lookup(name string) (*template.Template, bool) {
+	t := l.root.Lookup(name)
+	return t, t != nil
+}
+
+// NewBotPrompt returns a prompt that renders the named template
+func (l *TemplateLibrary) NewBotPrompt(name string, withClient LLMAPIClient) (*BotPrompt, error) {
+	t, ok := l.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("no template named %q", name)
+	}
+
+	b, err := LoadBotPrompt("", withClient)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Template = l.sources[name]
+	b.tpl = t
+
+	return b, nil
+}
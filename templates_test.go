@@ -0,0 +1,112 @@
+package botMaker_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+)
+
+func render(t *testing.T, lib *botMaker.TemplateLibrary, name string, body string) string {
+	t.Helper()
+
+	client := botmakertest.NewClient("ok")
+	prompt, err := lib.NewBotPrompt(name, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt.Body = body
+	prompt.ContextToRender = []string{"first", "second"}
+
+	settings := newSettings()
+	if _, _, err := client.CallCompletionAPI(settings, prompt); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := client.LastCall()
+	return call.Prompt
+}
+
+func TestLoadTemplateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/partials.tmpl": {Data: []byte(`{{ define "contexts" }}{{ join "; " (numbered .) }}{{ end }}`)},
+		"templates/support.tmpl": {Data: []byte(`Q: {{ .Body | printf "%q" }} ` +
+			`C: {{ include "contexts" .ContextToRender | truncateTokens 100 }} J: {{ json .ContextTitles }}`)},
+	}
+
+	lib, err := botMaker.LoadTemplateFS(fsys, "templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(lib.Names(), ","); names != "contexts,partials,support" {
+		t.Errorf("got names %s", names)
+	}
+
+	got := render(t, lib, "support", "hi")
+	if !strings.Contains(got, `Q: "hi" C: [1] first; [2] second J: []`) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLoadTemplateFSValidates(t *testing.T) {
+	fsys := fstest.MapFS{"bad.tmpl": {Data: []byte(`{{ template "missing" . }}`)}}
+
+	if _, err := botMaker.LoadTemplateFS(fsys, "*.tmpl"); !errors.Is(err, botMaker.ErrTemplateLoad) {
+		t.Errorf("got %v, want ErrTemplateLoad", err)
+	}
+}
+
+func TestTemplateLibraryAddRejectsBrokenTemplates(t *testing.T) {
+	lib := botMaker.NewTemplateLibrary()
+	if err := lib.Add("greeting", `{{ define "name" }}world{{ end }}hello {{ template "name" }}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the partial parses, the template around it fails to render
+	err := lib.Add("broken", `{{ define "evil" }}evil{{ end }}{{ .Missing }}`)
+	if !errors.Is(err, botMaker.ErrTemplateLoad) {
+		t.Fatalf("got %v, want ErrTemplateLoad", err)
+	}
+
+	for _, name := range []string{"broken", "evil"} {
+		if _, ok := lib.Lookup(name); ok {
+			t.Errorf("%s is in the library", name)
+		}
+	}
+
+	if err := lib.Add("includes", `{{ include "evil" . }}`); err == nil {
+		t.Error("a template could include a partial from a rejected template")
+	}
+
+	if got := render(t, lib, "greeting", ""); !strings.Contains(got, "hello world") {
+		t.Errorf("got %q", got)
+	}
+
+	if err := lib.Add("polite", `{{ include "name" . | printf "dear %s" }}`); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, lib, "polite", ""); !strings.Contains(got, "dear world") {
+		t.Errorf("include does not see the library's partials: %q", got)
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	tpl, err := botMaker.NewPromptTemplate("t", `{{ truncateTokens 3 .Body }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := tpl.Execute(&out, &botMaker.BotPrompt{Body: "abcdefgh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the offline tokenizer has one token per byte
+	if out.String() != "abc" {
+		t.Errorf("got %q", out.String())
+	}
+}
@@ -0,0 +1,232 @@
+package botMaker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned (wrapped) for calls made by a bot that has spent its daily budget
+var ErrBudgetExceeded = errors.New("daily budget exceeded")
+
+// UsageRecord is the usage of a single API call
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	BotID            string    `json:"bot_id"`
+	Session          string    `json:"session,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	EmbeddingTokens  int       `json:"embedding_tokens"`
+	Cost             float64   `json:"cost"` // USD, from the model registry prices
+}
+
+// UsageSummary is the usage of a bot's session with one model over a day (UTC)
+type UsageSummary struct {
+	Day              string  `json:"day"`
+	BotID            string  `json:"bot_id"`
+	Session          string  `json:"session,omitempty"`
+	Model            string  `json:"model"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	EmbeddingTokens  int     `json:"embedding_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageTracker aggregates token usage and cost per day, bot, session and model, and enforces daily budgets
+type UsageTracker struct {
+	DailyBudgets  map[string]float64 // USD a bot ID may spend per day (UTC), bots without an entry are unlimited
+	DefaultBudget float64            // USD per day for bots without a DailyBudgets entry, 0 is unlimited
+	OnRecord      func(UsageRecord)  // Optional, called for every recorded call
+	RetentionDays int                // Days of summaries kept, today included, defaults to DefaultUsageRetentionDays
+
+	mu      sync.Mutex
+	summary map[usageKey]*UsageSummary
+	pruned  string // Day the summaries were last pruned on
+}
+
+// DefaultUsageRetentionDays is how many days of summaries a UsageTracker keeps when RetentionDays is 0
+const DefaultUsageRetentionDays = 31
+
+type usageKey struct {
+	day, bot, session, model string
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{
+		DailyBudgets: make(map[string]float64),
+		summary:      make(map[usageKey]*UsageSummary),
+	}
+}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Record adds a call to the tracker, a zero Time is set to now and a zero Cost is priced from the model registry
+func (u *UsageTracker) Record(r UsageRecord) {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	if r.Cost == 0 {
+		if m, ok := LookupModel(r.Model); ok {
+			r.Cost = m.Cost(r.PromptTokens+r.EmbeddingTokens, r.CompletionTokens)
+		}
+	}
+
+	u.mu.Lock()
+	if u.summary == nil {
+		u.summary = make(map[usageKey]*UsageSummary)
+	}
+
+	u.prune(time.Now())
+
+	// calls older than the retention window are passed to OnRecord but not summarised
+	if key := (usageKey{usageDay(r.Time), r.BotID, r.Session, r.Model}); key.day >= u.pruned {
+		s, ok := u.summary[key]
+		if !ok {
+			s = &UsageSummary{Day: key.day, BotID: r.BotID, Session: r.Session, Model: r.Model}
+			u.summary[key] = s
+		}
+
+		s.Calls++
+		s.PromptTokens += r.PromptTokens
+		s.CompletionTokens += r.CompletionTokens
+		s.EmbeddingTokens += r.EmbeddingTokens
+		s.Cost += r.Cost
+	}
+	u.mu.Unlock()
+
+	if u.OnRecord != nil {
+		u.OnRecord(r)
+	}
+}
+
+// prune drops the summaries of days before the retention window, once a day. u.mu must be held
+func (u *UsageTracker) prune(now time.Time) {
+	days := u.RetentionDays
+	if days <= 0 {
+		days = DefaultUsageRetentionDays
+	}
+
+	oldest := usageDay(now.AddDate(0, 0, 1-days))
+	if oldest == u.pruned {
+		return
+	}
+
+	for k := range u.summary {
+		if k.day < oldest {
+			delete(u.summary, k)
+		}
+	}
+	u.pruned = oldest
+}
+
+// SpentToday returns what a bot has spent in USD since midnight UTC
+func (u *UsageTracker) SpentToday(botID string) float64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	today := usageDay(time.Now())
+	spent := 0.0
+	for k, s := range u.summary {
+		if k.day == today && k.bot == botID {
+			spent += s.Cost
+		}
+	}
+
+	return spent
+}
+
+// CheckBudget returns an error wrapping ErrBudgetExceeded if the bot has reached its daily budget
+func (u *UsageTracker) CheckBudget(botID string) error {
+	budget, ok := u.DailyBudgets[botID]
+	if !ok {
+		budget = u.DefaultBudget
+	}
+
+	if budget <= 0 {
+		return nil
+	}
+
+	if spent := u.SpentToday(botID); spent >= budget {
+		return fmt.Errorf("bot %q spent $%.4f of $%.4f: %w", botID, spent, budget, ErrBudgetExceeded)
+	}
+
+	return nil
+}
+
+// Summary returns the aggregated usage sorted by day, bot, session and model
+func (u *UsageTracker) Summary() []UsageSummary {
+	u.mu.Lock()
+	out := make([]UsageSummary, 0, len(u.summary))
+	for _, s := range u.summary {
+		out = append(out, *s)
+	}
+	u.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.BotID != b.BotID {
+			return a.BotID < b.BotID
+		}
+		if a.Session != b.Session {
+			return a.Session < b.Session
+		}
+		return a.Model < b.Model
+	})
+
+	return out
+}
+
+// Reset drops all recorded usage
+func (u *UsageTracker) Reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.summary = make(map[usageKey]*UsageSummary)
+	u.pruned = ""
+}
+
+// ExportJSON writes the aggregated usage as a JSON array
+func (u *UsageTracker) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(u.Summary())
+}
+
+// ExportCSV writes the aggregated usage as CSV with a header row
+func (u *UsageTracker) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"day", "bot_id", "session", "model", "calls", "prompt_tokens",
+		"completion_tokens", "embedding_tokens", "cost"}); err != nil {
+		return err
+	}
+
+	for _, s := range u.Summary() {
+		if err := cw.Write([]string{
+			s.Day, s.BotID, s.Session, s.Model,
+			strconv.Itoa(s.Calls),
+			strconv.Itoa(s.PromptTokens),
+			strconv.Itoa(s.CompletionTokens),
+			strconv.Itoa(s.EmbeddingTokens),
+			strconv.FormatFloat(s.Cost, 'f', 6, 64),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,196 @@
+package botMaker_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lonelycode/botMaker"
+	"github.com/lonelycode/botMaker/botmakertest"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestUsageTrackerSummary(t *testing.T) {
+	u := botMaker.NewUsageTracker()
+	u.Record(botMaker.UsageRecord{BotID: "b", Session: "s", Model: openai.GPT4, PromptTokens: 1000})
+	u.Record(botMaker.UsageRecord{BotID: "b", Session: "s", Model: openai.GPT4, CompletionTokens: 1000})
+	u.Record(botMaker.UsageRecord{BotID: "a", Model: "unknown-model", PromptTokens: 5, Cost: 0.5})
+
+	got := u.Summary()
+	if len(got) != 2 {
+		t.Fatalf("got %d summaries, want 2: %+v", len(got), got)
+	}
+
+	if got[0].BotID != "a" || got[0].Cost != 0.5 || got[0].Calls != 1 {
+		t.Errorf("a: got %+v", got[0])
+	}
+
+	// 1000 prompt tokens at 0.03 and 1000 completion tokens at 0.06 per 1000
+	b := got[1]
+	if b.Calls != 2 || b.PromptTokens != 1000 || b.CompletionTokens != 1000 || !near(b.Cost, 0.09) {
+		t.Errorf("b: got %+v", b)
+	}
+
+	if spent := u.SpentToday("b"); !near(spent, 0.09) {
+		t.Errorf("b spent %v today, want 0.09", spent)
+	}
+
+	u.Reset()
+	if len(u.Summary()) != 0 || u.SpentToday("b") != 0 {
+		t.Errorf("Reset kept %+v", u.Summary())
+	}
+}
+
+func TestUsageTrackerBudgets(t *testing.T) {
+	u := botMaker.NewUsageTracker()
+	u.DailyBudgets["capped"] = 1
+	u.DefaultBudget = 2
+
+	u.Record(botMaker.UsageRecord{BotID: "capped", Cost: 1})
+	u.Record(botMaker.UsageRecord{BotID: "default", Cost: 1})
+
+	if err := u.CheckBudget("capped"); !errors.Is(err, botMaker.ErrBudgetExceeded) {
+		t.Errorf("capped: got %v, want ErrBudgetExceeded", err)
+	}
+
+	if err := u.CheckBudget("default"); err != nil {
+		t.Errorf("default: got %v under the default budget", err)
+	}
+
+	u.Record(botMaker.UsageRecord{BotID: "default", Cost: 1})
+	if err := u.CheckBudget("default"); !errors.Is(err, botMaker.ErrBudgetExceeded) {
+		t.Errorf("default: got %v, want ErrBudgetExceeded", err)
+	}
+
+	// yesterday's spending does not count against today's budget
+	u.Record(botMaker.UsageRecord{BotID: "yesterday", Cost: 10, Time: time.Now().AddDate(0, 0, -1)})
+	u.DailyBudgets["yesterday"] = 1
+	if err := u.CheckBudget("yesterday"); err != nil {
+		t.Errorf("yesterday: got %v", err)
+	}
+}
+
+func TestUsageTrackerRetention(t *testing.T) {
+	u := botMaker.NewUsageTracker()
+	u.RetentionDays = 2
+
+	var seen []botMaker.UsageRecord
+	u.OnRecord = func(r botMaker.UsageRecord) {
+		seen = append(seen, r)
+	}
+
+	u.Record(botMaker.UsageRecord{BotID: "b", Cost: 1, Time: time.Now().AddDate(0, 0, -5)})
+	u.Record(botMaker.UsageRecord{BotID: "b", Cost: 1, Time: time.Now().AddDate(0, 0, -1)})
+	u.Record(botMaker.UsageRecord{BotID: "b", Cost: 1})
+
+	if len(seen) != 3 {
+		t.Errorf("OnRecord saw %d records, want 3", len(seen))
+	}
+
+	if got := u.Summary(); len(got) != 2 {
+		t.Errorf("got %d days, want the 2 inside the retention window: %+v", len(got), got)
+	}
+}
+
+func TestUsageTrackerExport(t *testing.T) {
+	u := botMaker.NewUsageTracker()
+	u.Record(botMaker.UsageRecord{BotID: "b", Session: "s", Model: "m", PromptTokens: 3, Cost: 0.25})
+
+	var buf bytes.Buffer
+	if err := u.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0][0] != "day" || rows[1][1] != "b" || rows[1][5] != "3" || rows[1][8] != "0.250000" {
+		t.Errorf("got %v", rows)
+	}
+
+	buf.Reset()
+	if err := u.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var summaries []botMaker.UsageSummary
+	if err := json.Unmarshal(buf.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summaries) != 1 || summaries[0].Session != "s" || summaries[0].Calls != 1 {
+		t.Errorf("got %+v", summaries)
+	}
+}
+
+func TestCallCompletionAPIRecordsUsage(t *testing.T) {
+	srv := botmakertest.NewServer(botmakertest.NewClient("Berlin."), nil)
+	defer srv.Close()
+
+	cl := srv.NewOAIClient()
+	cl.Usage = botMaker.NewUsageTracker()
+	settings := newSettings()
+
+	ask(t, cl, settings, "What is the capital of Germany?")
+
+	got := cl.Usage.Summary()
+	if len(got) != 1 {
+		t.Fatalf("got %d summaries, want 1", len(got))
+	}
+
+	if got[0].BotID != settings.ID || got[0].Calls != 1 || got[0].PromptTokens == 0 || got[0].CompletionTokens == 0 {
+		t.Errorf("got %+v", got[0])
+	}
+}
+
+func TestCallCompletionAPISetsThePromptsUsage(t *testing.T) {
+	srv := botmakertest.NewServer(botmakertest.NewClient("Berlin.", "Paris."), nil)
+	defer srv.Close()
+
+	cl := srv.NewOAIClient()
+	settings := newSettings()
+
+	// the mock API reports a token per byte of the messages it was sent
+	_, prompt := ask(t, cl, settings, "What is the capital of Germany?")
+	if prompt.Usage.CompletionTokens != len("Berlin.") || prompt.Usage.PromptTokens == 0 ||
+		prompt.Usage.TotalTokens != prompt.Usage.PromptTokens+prompt.Usage.CompletionTokens {
+		t.Errorf("got %+v", prompt.Usage)
+	}
+
+	prompt, _ = botMaker.LoadBotPrompt("", cl)
+	prompt.Body = "What is the capital of France?"
+	if _, _, err := cl.StreamCompletionAPI(settings, prompt, func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt.Usage.CompletionTokens != len("Paris.") || prompt.Usage.PromptTokens != prompt.PromptLength {
+		t.Errorf("got %+v for a stream", prompt.Usage)
+	}
+}
+
+func TestCachedAnswersIgnoreTheBudget(t *testing.T) {
+	_, cl := cachedClient(t, botMaker.ResponseCacheExact, "Berlin.")
+	cl.Usage = botMaker.NewUsageTracker()
+	settings := newSettings()
+	cl.Usage.DailyBudgets[settings.ID] = 1e-9
+
+	ask(t, cl, settings, "What is the capital of Germany?")
+	if resp, _ := ask(t, cl, settings, "What is the capital of Germany?"); resp != "Berlin." {
+		t.Errorf("got %q from the cache", resp)
+	}
+
+	prompt, _ := botMaker.LoadBotPrompt("", cl)
+	prompt.Body = "What is the capital of France?"
+	if _, _, err := cl.CallCompletionAPI(settings, prompt); !errors.Is(err, botMaker.ErrBudgetExceeded) {
+		t.Errorf("got %v, want ErrBudgetExceeded", err)
+	}
+}
+
+func near(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}